@@ -0,0 +1,438 @@
+package rexec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// This file implements the audit log of executed commands:
+// AuditRecord, AuditSink (JSONL file and in-memory implementations), and an
+// AuditExecutor that feeds every execution into a sink.
+//
+// Records are hash-chained: each record contains the hash of the previous
+// one, so that deleting or modifying a record breaks the chain
+// (see VerifyAuditChain).
+
+// AuditRecord is the record of one command execution.
+type AuditRecord struct {
+	// Seq is the 1-based sequence number of the record in the chain.
+	Seq uint64
+
+	// Principal is who ran the command, from the context (see WithPrincipal).
+	Principal string
+	// Target is where the command ran.
+	Target TargetInfo
+
+	// Command, Workdir and Env of the command, with the secrets masked
+	// (see Command.Redact and Command.RedactedEnv).
+	Command string
+	Workdir string
+	Env     map[string]string
+
+	Start time.Time
+	End   time.Time
+
+	// Status is the exit status of the command (see Command.Status).
+	Status int
+	// Error is the error returned by the executor, if any.
+	Error string
+
+	StdoutBytes  int64
+	StdoutSHA256 string
+	StderrBytes  int64
+	StderrSHA256 string
+
+	// PrevHash is the Hash of the previous record in the chain.
+	// Empty for the first record.
+	PrevHash string
+	// Hash is the SHA-256 of this record (with Hash itself empty),
+	// in hex.
+	Hash string
+}
+
+// computeHash returns the hash of the record, excluding the Hash field.
+func (r AuditRecord) computeHash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditSink receives the audit records.
+//
+// WriteAudit is called by AuditExecutor after each execution. The sink is
+// responsible for sealing the record into its hash chain (Seq, PrevHash and
+// Hash) before persisting it. Implementations must be safe for concurrent
+// use.
+type AuditSink interface {
+	WriteAudit(record *AuditRecord) error
+}
+
+// auditChain seals records into a hash chain.
+type auditChain struct {
+	seq      uint64
+	prevHash string
+}
+
+// seal sets the Seq, PrevHash and Hash of the record, and advances the chain.
+// It must be called with the lock of the sink held.
+func (c *auditChain) seal(record *AuditRecord) error {
+	record.Seq = c.seq + 1
+	record.PrevHash = c.prevHash
+	h, err := record.computeHash()
+	if err != nil {
+		return err
+	}
+	record.Hash = h
+
+	c.seq = record.Seq
+	c.prevHash = record.Hash
+	return nil
+}
+
+// MemoryAuditSink keeps the audit records in memory.
+// The zero value is ready to use.
+type MemoryAuditSink struct {
+	mu      sync.Mutex
+	chain   auditChain
+	records []AuditRecord
+}
+
+var _ AuditSink = (*MemoryAuditSink)(nil)
+
+// WriteAudit seals the record and appends it.
+func (s *MemoryAuditSink) WriteAudit(record *AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.chain.seal(record); err != nil {
+		return err
+	}
+	s.records = append(s.records, *record)
+	return nil
+}
+
+// Records returns a copy of the records written so far.
+func (s *MemoryAuditSink) Records() []AuditRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]AuditRecord, len(s.records))
+	copy(records, s.records)
+	return records
+}
+
+// JSONLAuditSink writes the audit records as JSON lines to a writer,
+// typically an append-only file (see OpenJSONLAuditFile).
+type JSONLAuditSink struct {
+	mu    sync.Mutex
+	w     io.Writer
+	chain auditChain
+}
+
+var _ AuditSink = (*JSONLAuditSink)(nil)
+
+// NewJSONLAuditSink creates a JSONLAuditSink writing to w, starting a new
+// hash chain.
+func NewJSONLAuditSink(w io.Writer) *JSONLAuditSink {
+	return &JSONLAuditSink{w: w}
+}
+
+// OpenJSONLAuditFile opens (or creates) the JSONL audit file for appending.
+// If the file already contains records, the hash chain continues from the
+// last one.
+//
+// Close the returned sink to close the file.
+func OpenJSONLAuditFile(name string) (*JSONLAuditSink, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAudit, err)
+	}
+
+	records, err := ReadAuditLog(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	sink := &JSONLAuditSink{w: f}
+	if n := len(records); n > 0 {
+		sink.chain = auditChain{seq: records[n-1].Seq, prevHash: records[n-1].Hash}
+	}
+	return sink, nil
+}
+
+// WriteAudit seals the record and writes it as a JSON line.
+func (s *JSONLAuditSink) WriteAudit(record *AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.chain
+	if err := next.seal(record); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := s.w.Write(line); err != nil {
+		return err
+	}
+
+	// advance the chain only if the record is persisted.
+	s.chain = next
+	return nil
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (s *JSONLAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if closer, ok := s.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// ReadAuditLog reads the JSONL audit records from r.
+// It does not verify the chain, see VerifyAuditChain.
+func ReadAuditLog(r io.Reader) ([]AuditRecord, error) {
+	var records []AuditRecord
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrAudit, line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAudit, err)
+	}
+	return records, nil
+}
+
+// VerifyAuditChain checks that the records form an intact hash chain
+// starting from the first record (Seq 1): every record has the expected Seq,
+// links to the Hash of the previous one, and has not been modified.
+//
+// It returns an error wrapping ErrAuditChainBroken naming the first bad
+// record. Notice that truncating records at the end of the chain can not be
+// detected by the chain itself; compare the last Seq or Hash with a copy kept
+// elsewhere to detect it.
+func VerifyAuditChain(records []AuditRecord) error {
+	prevHash := ""
+	for i, r := range records {
+		wantSeq := uint64(i + 1)
+		if r.Seq != wantSeq {
+			return fmt.Errorf("%w: record #%d: Seq is %d, want %d (missing or reordered records)",
+				ErrAuditChainBroken, i, r.Seq, wantSeq)
+		}
+		if r.PrevHash != prevHash {
+			return fmt.Errorf("%w: record Seq=%d: PrevHash does not match the previous record (missing records)",
+				ErrAuditChainBroken, r.Seq)
+		}
+		h, err := r.computeHash()
+		if err != nil {
+			return fmt.Errorf("%w: record Seq=%d: %w", ErrAuditChainBroken, r.Seq, err)
+		}
+		if r.Hash != h {
+			return fmt.Errorf("%w: record Seq=%d: Hash mismatch (modified record)",
+				ErrAuditChainBroken, r.Seq)
+		}
+		prevHash = r.Hash
+	}
+	return nil
+}
+
+// principalKey is the context key of the principal.
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal (who runs the
+// commands, e.g. a user name or service account), to be recorded in the
+// audit records.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal carried by ctx, or "" if none.
+func PrincipalFrom(ctx context.Context) string {
+	p, _ := ctx.Value(principalKey{}).(string)
+	return p
+}
+
+// AuditExecutor is an Executor decorator that writes an AuditRecord to the
+// Sink for every execution (including failed and rejected ones) by the
+// underlying Executor.
+//
+// If the record can not be written, Execute returns an error wrapping
+// ErrAudit, in addition to the error of the execution (if any).
+type AuditExecutor struct {
+	// Executor is the underlying executor.
+	Executor Executor
+	// Sink receives the audit records.
+	Sink AuditSink
+
+	// Logger, if not nil, overrides the global Logger for this executor.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+}
+
+var (
+	_ Executor      = (*AuditExecutor)(nil)
+	_ ExecuteCloser = (*AuditExecutor)(nil)
+	_ Targeter      = (*AuditExecutor)(nil)
+)
+
+// Execute the command with the underlying executor and audit it.
+func (e *AuditExecutor) Execute(ctx context.Context, cmd *Command) error {
+	logger := loggerFor(ctx, e.Logger).With("field", "rexec.AuditExecutor.Execute", "cmd", cmd)
+
+	if e.Executor == nil || e.Sink == nil {
+		logger.Warn("reject execution: nil underlying executor or sink")
+		return fmt.Errorf("%w: nil underlying executor or sink of AuditExecutor", ErrInternalError)
+	}
+
+	if cmd == nil {
+		logger.Warn("reject execution: nil command")
+		return ErrNilCommand
+	}
+
+	// the stdio of a running command must not be replaced: the executor
+	// would reject it anyway.
+	if cmd.started.Load() {
+		logger.Warn("reject execution: command already started")
+		return ErrStartedCommand
+	}
+
+	record := &AuditRecord{
+		Principal: PrincipalFrom(ctx),
+		Target:    TargetOf(e.Executor),
+		Command:   cmd.Redact(cmd.Command),
+		Workdir:   cmd.Redact(cmd.Workdir),
+		Env:       cmd.RedactedEnv(),
+		Start:     time.Now().UTC(),
+	}
+
+	// count and hash the outputs.
+	cmd.setDefaultStdio()
+	stdout := newAuditWriter(cmd.Stdout)
+	stderr := newAuditWriter(cmd.Stderr)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err := e.Executor.Execute(ctx, cmd)
+
+	cmd.Stdout, cmd.Stderr = stdout.w, stderr.w
+
+	record.End = time.Now().UTC()
+	record.Status = cmd.Status
+	if err != nil {
+		record.Error = err.Error()
+	}
+	record.StdoutBytes, record.StdoutSHA256 = stdout.sum()
+	record.StderrBytes, record.StderrSHA256 = stderr.sum()
+
+	if auditErr := e.Sink.WriteAudit(record); auditErr != nil {
+		logger.Error("failed to write audit record", "err", auditErr)
+		return errors.Join(err, fmt.Errorf("%w: %w", ErrAudit, auditErr))
+	}
+
+	logger.Debug("audit record written", "seq", record.Seq)
+	return err
+}
+
+// auditWriter counts and hashes the bytes written through it.
+type auditWriter struct {
+	w io.Writer
+
+	mu sync.Mutex
+	n  int64
+	h  hash.Hash
+}
+
+func newAuditWriter(w io.Writer) *auditWriter {
+	return &auditWriter{w: w, h: sha256.New()}
+}
+
+func (a *auditWriter) Write(p []byte) (int, error) {
+	n, err := a.w.Write(p)
+
+	a.mu.Lock()
+	a.n += int64(n)
+	a.h.Write(p[:n])
+	a.mu.Unlock()
+
+	return n, err
+}
+
+// sum returns the number of bytes written and their SHA-256 in hex.
+func (a *auditWriter) sum() (int64, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.n, hex.EncodeToString(a.h.Sum(nil))
+}
+
+func (e *AuditExecutor) TargetInfo() TargetInfo { return TargetOf(e.Executor) }
+
+// Close closes the underlying Executor if it is an ExecuteCloser.
+// The Sink is not closed.
+func (e *AuditExecutor) Close() error {
+	if closer, ok := e.Executor.(ExecuteCloser); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (e *AuditExecutor) Validate() error {
+	if e == nil {
+		return ErrNilExecutor
+	}
+	if e.Executor == nil {
+		return fmt.Errorf("%w: underlying executor is nil", ErrExecutorBadConfig)
+	}
+	if e.Sink == nil {
+		return fmt.Errorf("%w: audit sink is nil", ErrExecutorBadConfig)
+	}
+	if v, ok := e.Executor.(ExecuteCloser); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// WithAudit returns a Middleware that audits every execution to the sink.
+// See AuditExecutor.
+func WithAudit(sink AuditSink) Middleware {
+	return func(next Executor) Executor {
+		return &AuditExecutor{Executor: next, Sink: sink}
+	}
+}
+
+// audit errors
+var (
+	ErrAudit            = errors.New("audit failed")
+	ErrAuditChainBroken = errors.New("audit chain broken")
+)
@@ -0,0 +1,155 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditExecutor_Execute(t *testing.T) {
+	sink := &MemoryAuditSink{}
+	executor := Chain(&LocalExecutor{}, WithAudit(sink))
+
+	ctx := WithPrincipal(context.Background(), "alice")
+
+	m := NewManagedIO()
+	cmd := &Command{Command: "echo hello", Env: map[string]string{"API_TOKEN": "s3cr3t"}}
+	m.Hijack(cmd)
+	if err := executor.Execute(ctx, cmd); err != nil {
+		t.Fatalf("❌ Execute() error = %v", err)
+	}
+	if cmd.Stdout != m.Stdout {
+		t.Errorf("❌ Execute() did not restore the stdout of the command")
+	}
+
+	_ = executor.Execute(ctx, &Command{Command: "ls /not/exist/path"})
+	_ = executor.Execute(ctx, &Command{Command: ""}) // invalid
+
+	records := sink.Records()
+	if len(records) != 3 {
+		t.Fatalf("❌ got %d audit records, want 3", len(records))
+	}
+
+	r := records[0]
+	wantStdoutSum := sha256.Sum256([]byte("hello\n"))
+	switch {
+	case r.Principal != "alice":
+		t.Errorf("❌ record Principal = %q, want alice", r.Principal)
+	case r.Target.Kind != "Local":
+		t.Errorf("❌ record Target = %v, want Local", r.Target)
+	case r.Command != "echo hello" || r.Status != 0 || r.Error != "":
+		t.Errorf("❌ record = %+v, want succeeded echo hello", r)
+	case r.StdoutBytes != 6 || r.StdoutSHA256 != hex.EncodeToString(wantStdoutSum[:]):
+		t.Errorf("❌ record stdout = %d bytes %s, want 6 bytes of hello", r.StdoutBytes, r.StdoutSHA256)
+	case r.Env["API_TOKEN"] == "s3cr3t":
+		t.Errorf("❌ record Env is not redacted: %v", r.Env)
+	case r.End.Before(r.Start):
+		t.Errorf("❌ record End %v before Start %v", r.End, r.Start)
+	default:
+		t.Logf("✅ record: %+v", r)
+	}
+
+	if records[1].Status == 0 || records[1].Error == "" || records[1].StderrBytes == 0 {
+		t.Errorf("❌ failed command record = %+v, want non-zero status, error and stderr", records[1])
+	}
+	if records[2].Error == "" {
+		t.Errorf("❌ invalid command record = %+v, want error", records[2])
+	}
+
+	if err := VerifyAuditChain(records); err != nil {
+		t.Errorf("❌ VerifyAuditChain() error = %v", err)
+	}
+
+	// tampering
+	deleted := append([]AuditRecord{records[0]}, records[2:]...)
+	if err := VerifyAuditChain(deleted); !errors.Is(err, ErrAuditChainBroken) {
+		t.Errorf("❌ VerifyAuditChain() deleted record error = %v, want %v", err, ErrAuditChainBroken)
+	} else {
+		t.Logf("✅ VerifyAuditChain() deleted record: %v", err)
+	}
+
+	modified := sink.Records()
+	modified[1].Status = 0
+	if err := VerifyAuditChain(modified); !errors.Is(err, ErrAuditChainBroken) {
+		t.Errorf("❌ VerifyAuditChain() modified record error = %v, want %v", err, ErrAuditChainBroken)
+	} else {
+		t.Logf("✅ VerifyAuditChain() modified record: %v", err)
+	}
+}
+
+func TestAuditExecutor_Execute_started(t *testing.T) {
+	sink := &MemoryAuditSink{}
+	executor := &AuditExecutor{Executor: &LocalExecutor{}, Sink: sink}
+
+	stdout := &bytes.Buffer{}
+	cmd := &Command{Command: "echo hello", Stdout: stdout}
+	cmd.started.Store(true) // running elsewhere
+	if err := executor.Execute(context.Background(), cmd); !errors.Is(err, ErrStartedCommand) {
+		t.Errorf("❌ Execute() error = %v, want %v", err, ErrStartedCommand)
+	}
+	if cmd.Stdout != stdout || cmd.Stderr != nil {
+		t.Errorf("❌ Execute() replaced the stdio of the started command")
+	}
+	t.Logf("✅ started command rejected untouched")
+}
+
+// failingAuditSink always fails to write.
+type failingAuditSink struct{}
+
+func (failingAuditSink) WriteAudit(*AuditRecord) error { return errors.New("disk full") }
+
+func TestAuditExecutor_Execute_sinkError(t *testing.T) {
+	executor := &AuditExecutor{Executor: &LocalExecutor{}, Sink: failingAuditSink{}}
+	err := executor.Execute(context.Background(), &Command{Command: "true"})
+	if !errors.Is(err, ErrAudit) {
+		t.Errorf("❌ Execute() error = %v, want %v", err, ErrAudit)
+	} else {
+		t.Logf("✅ Execute() error = %v", err)
+	}
+}
+
+func TestJSONLAuditSink(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	write := func(commands ...string) {
+		sink, err := OpenJSONLAuditFile(name)
+		if err != nil {
+			t.Fatalf("❌ OpenJSONLAuditFile() error = %v", err)
+		}
+		defer sink.Close()
+
+		executor := &AuditExecutor{Executor: &LocalExecutor{}, Sink: sink}
+		for _, c := range commands {
+			if err := executor.Execute(context.Background(), &Command{Command: c}); err != nil {
+				t.Fatalf("❌ Execute() error = %v", err)
+			}
+		}
+	}
+
+	write("echo 1", "echo 2")
+	write("echo 3") // reopen: continue the chain
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := ReadAuditLog(f)
+	if err != nil {
+		t.Fatalf("❌ ReadAuditLog() error = %v", err)
+	}
+	if len(records) != 3 || records[2].Seq != 3 || records[2].Command != "echo 3" {
+		t.Fatalf("❌ ReadAuditLog() = %+v, want 3 records", records)
+	}
+	if err := VerifyAuditChain(records); err != nil {
+		t.Errorf("❌ VerifyAuditChain() error = %v", err)
+	} else {
+		t.Logf("✅ JSONL audit chain verified across reopen")
+	}
+}
@@ -0,0 +1,160 @@
+package rexec
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// This file provides the Clock of the Scheduler, and a FakeClock to test
+// the schedules deterministically.
+
+// Clock tells the time, and makes timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer of a Clock (see time.Timer).
+type Timer interface {
+	// C returns the channel the time is sent on when the timer fires.
+	C() <-chan time.Time
+	// Stop stops the timer. It returns false if the timer has already
+	// fired or been stopped.
+	Stop() bool
+}
+
+// SystemClock is the Clock of the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.t.C }
+func (t systemTimer) Stop() bool          { return t.t.Stop() }
+
+// FakeClock is a Clock whose time only moves when it is told to (see
+// Advance), for deterministic tests:
+//
+//	clock := rexec.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+//	scheduler := &rexec.Scheduler{Clock: clock}
+//	go scheduler.Run(ctx)
+//
+//	clock.WaitTimers(1)        // the scheduler is waiting for the next run
+//	clock.Advance(time.Minute) // fires it
+type FakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond // broadcast when a timer is created
+	now    time.Time
+	timers []*fakeTimer
+}
+
+var _ Clock = (*FakeClock)(nil)
+
+// NewFakeClock returns a FakeClock at the time now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer firing when the clock is advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, when: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by d, and fires the timers due, in
+// order.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set moves the clock to the time t (forward only: an earlier t is
+// ignored), and fires the timers due, in order.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(t)
+}
+
+// set must be called with the lock held.
+func (c *FakeClock) set(t time.Time) {
+	if t.Before(c.now) {
+		return
+	}
+	c.now = t
+
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.when.After(t) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- timer.when
+	}
+	c.timers = pending
+}
+
+// Timers returns the number of timers waiting to fire.
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// WaitTimers blocks until at least n timers are waiting to fire, e.g. until
+// a Scheduler waits for its next run.
+func (c *FakeClock) WaitTimers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
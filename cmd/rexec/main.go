@@ -0,0 +1,128 @@
+// Command rexec runs commands on local and remote targets with the rexec
+// package, so that scripts use the same validated code path as the services:
+//
+//	rexec run --target 'ssh://deploy@web1?key=/home/deploy/.ssh/id_ed25519' -- uptime
+//	rexec run --inventory hosts.yaml --select 'web&prod' --parallel 8 -- systemctl restart nginx
+//	rexec run --config executor.yaml --output json -- df -h
+//	rexec validate executor.yaml
+//
+// Run "rexec help" for the usage.
+//
+// The exit status of "rexec run" is:
+//
+//   - 0 if the command succeeded on all the targets;
+//   - 255 if any target failed to run the command (e.g. could not connect,
+//     timed out or was skipped);
+//   - otherwise, the highest exit status of the command on the targets.
+//
+// It is 2 for bad usages, and 1 for the other errors (e.g. bad configs).
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cdfmlr/rexec/v2"
+)
+
+// exit statuses
+const (
+	exitOK     = 0
+	exitError  = 1   // bad configs, or other errors before running
+	exitUsage  = 2   // bad command line
+	exitNotRun = 255 // the command was not run to the end on a target, like ssh(1)
+)
+
+const usage = `rexec runs commands on local and remote targets.
+
+Usage:
+
+	rexec run [flags] -- command [args...]
+	rexec validate config-file...
+	rexec help
+
+Run "rexec run -h" for the flags of run.
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}
+	code := c.main(ctx, os.Args[1:])
+	stop()
+	os.Exit(code)
+}
+
+// cli is the rexec command with its standard streams.
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// main runs the subcommand in the args, and returns the exit status.
+func (c *cli) main(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(c.stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "run":
+		return c.run(ctx, args[1:])
+	case "validate":
+		return c.validate(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(c.stdout, usage)
+		return exitOK
+	default:
+		c.errorf("unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+// validate checks the ExecutorFactory config files (see rexec.ValidateConfig),
+// and prints all the problems found.
+func (c *cli) validate(args []string) int {
+	if len(args) == 0 {
+		c.errorf("validate: no config file")
+		return exitUsage
+	}
+
+	code := exitOK
+	for _, name := range args {
+		data, err := os.ReadFile(name)
+		if err == nil {
+			err = rexec.ValidateConfig(data)
+		}
+		if err == nil {
+			fmt.Fprintf(c.stdout, "%s: ok\n", name)
+			continue
+		}
+
+		code = exitError
+		if errs, ok := err.(rexec.ConfigErrors); ok {
+			for _, e := range errs {
+				fmt.Fprintf(c.stdout, "%s: %v\n", name, e)
+			}
+		} else {
+			fmt.Fprintf(c.stdout, "%s: %v\n", name, err)
+		}
+	}
+	return code
+}
+
+// errorf prints the error message to the stderr.
+func (c *cli) errorf(format string, args ...any) {
+	fmt.Fprintf(c.stderr, "rexec: "+format+"\n", args...)
+}
+
+// enableLogging logs the rexec package to the stderr.
+func (c *cli) enableLogging() {
+	rexec.Logger = slog.New(slog.NewTextHandler(c.stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
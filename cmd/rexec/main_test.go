@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCli runs the rexec command with the args and stdin, and returns its
+// exit status and outputs.
+func runCli(t *testing.T, stdin string, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	var out, errOut strings.Builder
+	c := &cli{stdin: strings.NewReader(stdin), stdout: &out, stderr: &errOut}
+	code = c.main(context.Background(), args)
+	return code, out.String(), errOut.String()
+}
+
+// writeFile writes the content to a file in a temporary directory, and
+// returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCli_run(t *testing.T) {
+	shellConfig := writeFile(t, "shell.yaml", "shell:\n  shellPath: /bin/sh\n  shellArgs: [-c]\n")
+	typoConfig := writeFile(t, "typo.json", `{"Shell": {"ShelPath": "/bin/sh"}}`)
+	inventory := writeFile(t, "hosts.yaml", `
+defaults: {executor: Local}
+hosts:
+  web1: {vars: {service: nginx}}
+  web2: {vars: {service: apache2}}
+  db1: {vars: {service: postgres}}
+groups:
+  web: {hosts: [web1, web2]}
+`)
+
+	tests := []struct {
+		name       string
+		stdin      string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string // a substring
+	}{
+		{
+			name:       "target",
+			args:       []string{"run", "--target", "sh://", "--", "echo hello; echo oops >&2"},
+			wantCode:   exitOK,
+			wantStdout: "Shell: hello\n",
+			wantStderr: "Shell: oops\n",
+		},
+		{
+			name:       "args quoted",
+			args:       []string{"run", "--target", "sh://", "--", "echo", "a  b", "$HOME"},
+			wantCode:   exitOK,
+			wantStdout: "Shell: a  b $HOME\n",
+		},
+		{
+			name:       "highest exit status",
+			args:       []string{"run", "--config", shellConfig, "--target", "sh://", "--", "exit 3"},
+			wantCode:   3,
+			wantStderr: "Shell: exit status 3\n",
+		},
+		{
+			name:       "not run",
+			args:       []string{"run", "--target", "sh://", "--target", "local://", "--", "exit 3"},
+			wantCode:   exitNotRun,
+			wantStderr: "Local: error: ",
+		},
+		{
+			name:       "timeout",
+			args:       []string{"run", "--target", "sh://", "--timeout", "50ms", "--", "sleep 5"},
+			wantCode:   exitNotRun,
+			wantStderr: "deadline exceeded",
+		},
+		{
+			name:       "stdin",
+			stdin:      "from stdin",
+			args:       []string{"run", "--target", "sh://", "--stdin", "--output", "group", "--", "cat"},
+			wantCode:   exitOK,
+			wantStdout: "from stdin\n",
+		},
+		{
+			name:       "inventory template",
+			args:       []string{"run", "--inventory", inventory, "--select", "web", "--parallel", "1", "--template", "--", "echo {{.service}}"},
+			wantCode:   exitOK,
+			wantStdout: "web1: nginx\nweb2: apache2\n",
+		},
+		{
+			name:       "json",
+			args:       []string{"run", "--target", "sh://", "--output", "json", "--", "echo hi"},
+			wantCode:   exitOK,
+			wantStdout: `"Stdout":"hi\n"`,
+		},
+		{
+			name:       "config typo",
+			args:       []string{"run", "--config", typoConfig, "--", "true"},
+			wantCode:   exitError,
+			wantStderr: "Shell.ShelPath: unknown field",
+		},
+		{
+			name:       "bad url",
+			args:       []string{"run", "--target", "ftp://host", "--", "true"},
+			wantCode:   exitError,
+			wantStderr: "unknown scheme",
+		},
+		{
+			name:       "no command",
+			args:       []string{"run", "--target", "sh://"},
+			wantCode:   exitUsage,
+			wantStderr: "no command",
+		},
+		{
+			name:       "no target",
+			args:       []string{"run", "--", "true"},
+			wantCode:   exitUsage,
+			wantStderr: "no target",
+		},
+		{
+			name:       "bad output",
+			args:       []string{"run", "--target", "sh://", "--output", "xml", "--", "true"},
+			wantCode:   exitUsage,
+			wantStderr: "unknown output format",
+		},
+		{
+			name:       "unknown command",
+			args:       []string{"exec"},
+			wantCode:   exitUsage,
+			wantStderr: `unknown command "exec"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCli(t, tt.stdin, tt.args...)
+			if code != tt.wantCode {
+				t.Errorf("❌ exit status = %d, want %d\n\tstdout: %q\n\tstderr: %q", code, tt.wantCode, stdout, stderr)
+			}
+			if !strings.Contains(stdout, tt.wantStdout) {
+				t.Errorf("❌ stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("❌ stderr = %q, want %q", stderr, tt.wantStderr)
+			}
+			t.Logf("✅ exit status = %d, stdout = %q, stderr = %q", code, stdout, stderr)
+		})
+	}
+}
+
+func TestCli_validate(t *testing.T) {
+	good := writeFile(t, "good.yaml", "local: {}\n")
+	bad := writeFile(t, "bad.json", `{"Shell": {"ShelPath": "/bin/sh"}, "Local": {}}`)
+
+	code, stdout, _ := runCli(t, "", "validate", good, bad)
+	if code != exitError {
+		t.Errorf("❌ exit status = %d, want %d", code, exitError)
+	}
+	for _, want := range []string{
+		good + ": ok\n",
+		bad + ": multiple executors are set",
+		bad + ": Shell.ShelPath: unknown field\n",
+		bad + ": Shell.ShellPath: executor has bad configuration: shell path is empty\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("❌ stdout = %q, want %q", stdout, want)
+		}
+	}
+	t.Logf("✅ validate:\n%s", stdout)
+}
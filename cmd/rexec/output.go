@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cdfmlr/rexec/v2"
+)
+
+// output formats of "rexec run"
+const (
+	outputPrefix = "prefix" // every line prefixed by "name: "
+	outputGroup  = "group"  // the outputs of each target under a header
+	outputJSON   = "json"   // a jsonResult per line
+)
+
+// newPrinter returns the FanOut.OnResult printing the results in the format
+// to the stdout and stderr, as the targets finish.
+func newPrinter(format string, stdout, stderr io.Writer) (func(rexec.HostResult), error) {
+	switch format {
+	case outputPrefix:
+		return func(r rexec.HostResult) { printPrefixed(stdout, stderr, r) }, nil
+	case outputGroup:
+		return func(r rexec.HostResult) { printGrouped(stdout, stderr, r) }, nil
+	case outputJSON:
+		enc := json.NewEncoder(stdout)
+		return func(r rexec.HostResult) { _ = enc.Encode(newJSONResult(r)) }, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q: want %s, %s or %s", format, outputPrefix, outputGroup, outputJSON)
+	}
+}
+
+// printPrefixed prints every line of the outputs prefixed by the name of the
+// target, followed by the failure, if any, to the stderr.
+func printPrefixed(stdout, stderr io.Writer, r rexec.HostResult) {
+	prefix := []byte(r.Name + ": ")
+	writePrefixed(stdout, prefix, r.Stdout)
+	writePrefixed(stderr, prefix, r.Stderr)
+	if !r.OK() {
+		fmt.Fprintf(stderr, "%s%s\n", prefix, describe(r))
+	}
+}
+
+// writePrefixed writes every line of the data prefixed.
+func writePrefixed(w io.Writer, prefix, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	var b bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		b.Write(prefix)
+		b.Write(line)
+		if line[len(line)-1] != '\n' {
+			b.WriteByte('\n')
+		}
+	}
+	_, _ = w.Write(b.Bytes())
+}
+
+// printGrouped prints a header line with the name of the target and how the
+// command ended, then the stdout of the target to the stdout, and its stderr
+// to the stderr.
+func printGrouped(stdout, stderr io.Writer, r rexec.HostResult) {
+	fmt.Fprintf(stdout, "=== %s: %s (%s)\n", r.Name, describe(r), r.Duration.Round(time.Millisecond))
+	writeLines(stdout, r.Stdout)
+	writeLines(stderr, r.Stderr)
+}
+
+// writeLines writes the data, ending with a newline.
+func writeLines(w io.Writer, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	_, _ = w.Write(data)
+	if data[len(data)-1] != '\n' {
+		_, _ = io.WriteString(w, "\n")
+	}
+}
+
+// describe returns how the command ended on the target: "ok",
+// "exit status N", or "error: ..." if it was not run to the end.
+func describe(r rexec.HostResult) string {
+	switch {
+	case r.OK():
+		return "ok"
+	case r.Status > 0:
+		return fmt.Sprintf("exit status %d", r.Status)
+	default:
+		return fmt.Sprintf("error: %v", r.Err)
+	}
+}
+
+// jsonResult is the JSON output of a HostResult.
+type jsonResult struct {
+	Name   string
+	Target rexec.TargetInfo
+
+	Status int
+	// Error is the error of the executor, if any.
+	Error string `json:",omitempty"`
+
+	Stdout string
+	Stderr string
+
+	Start time.Time
+	End   time.Time
+}
+
+func newJSONResult(r rexec.HostResult) jsonResult {
+	result := jsonResult{
+		Name:   r.Name,
+		Target: r.Target,
+		Status: r.Status,
+		Stdout: string(r.Stdout),
+		Stderr: string(r.Stderr),
+		Start:  r.Start,
+		End:    r.Start.Add(r.Duration),
+	}
+	if r.Err != nil {
+		result.Error = r.Err.Error()
+	}
+	return result
+}
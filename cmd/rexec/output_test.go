@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdfmlr/rexec/v2"
+)
+
+func TestPrinters(t *testing.T) {
+	results := []rexec.HostResult{
+		{Name: "web1", Stdout: []byte("a\nb"), Stderr: []byte("warn\n"), Duration: 1500 * time.Millisecond},
+		{Name: "web2", Status: 2, Err: errors.New("exit status 2")},
+		{Name: "web3", Status: -1, Err: rexec.ErrSshDial},
+	}
+	tests := []struct {
+		format     string
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			format:     outputPrefix,
+			wantStdout: "web1: a\nweb1: b\n",
+			wantStderr: "web1: warn\nweb2: exit status 2\nweb3: error: failed to dial SSH\n",
+		},
+		{
+			format:     outputGroup,
+			wantStdout: "=== web1: ok (1.5s)\na\nb\n=== web2: exit status 2 (0s)\n=== web3: error: failed to dial SSH (0s)\n",
+			wantStderr: "warn\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			onResult, err := newPrinter(tt.format, &stdout, &stderr)
+			if err != nil {
+				t.Fatalf("❌ newPrinter() error = %v", err)
+			}
+			for _, r := range results {
+				onResult(r)
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("❌ stdout = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != tt.wantStderr {
+				t.Errorf("❌ stderr = %q, want %q", stderr.String(), tt.wantStderr)
+			}
+			t.Logf("✅ %s output:\n%s", tt.format, stdout.String())
+		})
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int
+	}{
+		{name: "ok", statuses: []int{0, 0}, want: exitOK},
+		{name: "highest", statuses: []int{0, 1, 3, 2}, want: 3},
+		{name: "not run", statuses: []int{3, -1, 0}, want: exitNotRun},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := make([]rexec.HostResult, len(tt.statuses))
+			for i, status := range tt.statuses {
+				results[i].Status = status
+				if status != 0 {
+					results[i].Err = errors.New("failed")
+				}
+			}
+			if got := exitStatus(results); got != tt.want {
+				t.Errorf("❌ exitStatus(%v) = %d, want %d", tt.statuses, got, tt.want)
+			} else {
+				t.Logf("✅ exitStatus(%v) = %d", tt.statuses, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cdfmlr/rexec/v2"
+)
+
+// runOptions are the flags of "rexec run".
+type runOptions struct {
+	targets   stringsFlag
+	configs   stringsFlag
+	inventory string
+	selector  string
+
+	parallel int
+	timeout  time.Duration
+	failFast bool
+	template bool
+	stdin    bool
+
+	output  string
+	verbose bool
+}
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// flagSet returns the flag set of "rexec run" parsing into the options.
+func (o *runOptions) flagSet(output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "Usage: rexec run [flags] -- command [args...]\n\n"+
+			"Runs the command on the targets: --target, --config and --inventory may be combined.\n\n")
+		fs.PrintDefaults()
+	}
+
+	fs.Var(&o.targets, "target", "executor URL of a target, e.g. ssh://user@host?key=PATH, sh:// or local:// (repeatable)")
+	fs.Var(&o.configs, "config", "ExecutorFactory JSON or YAML config file of a target (repeatable)")
+	fs.StringVar(&o.inventory, "inventory", "", "inventory JSON or YAML file of the targets")
+	fs.StringVar(&o.selector, "select", rexec.InventoryAll, "hosts of the --inventory to run on, e.g. 'web&prod:!web3'")
+
+	fs.IntVar(&o.parallel, "parallel", rexec.DefaultFanOutConcurrency, "maximum number of targets running at the same time")
+	fs.DurationVar(&o.timeout, "timeout", 0, "timeout of the whole run, e.g. 30s (0 for no timeout)")
+	fs.BoolVar(&o.failFast, "fail-fast", false, "stop at the first failed target")
+	fs.BoolVar(&o.template, "template", false, "render the command with the inventory vars of each target, e.g. {{.service}}")
+	fs.BoolVar(&o.stdin, "stdin", false, "send the stdin to the command on every target")
+
+	fs.StringVar(&o.output, "output", outputPrefix, "output format: prefix (lines prefixed by the target), group (grouped by target) or json (JSON lines)")
+	fs.BoolVar(&o.verbose, "v", false, "log the rexec package to the stderr")
+	return fs
+}
+
+// run implements "rexec run".
+func (c *cli) run(ctx context.Context, args []string) int {
+	var o runOptions
+	fs := o.flagSet(c.stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		c.errorf("run: no command")
+		fs.Usage()
+		return exitUsage
+	}
+	onResult, err := newPrinter(o.output, c.stdout, c.stderr)
+	if err != nil {
+		c.errorf("run: %v", err)
+		return exitUsage
+	}
+	if o.verbose {
+		c.enableLogging()
+	}
+
+	targets, err := o.loadTargets()
+	defer closeTargets(targets)
+	if err != nil {
+		c.errorf("run: %v", err)
+		return exitError
+	}
+	if len(targets) == 0 {
+		c.errorf("run: no target: use --target, --config or --inventory")
+		return exitUsage
+	}
+
+	cmd := &rexec.Command{Command: commandLine(fs.Args())}
+	if o.stdin {
+		cmd.Stdin = c.stdin
+	}
+
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	results, err := (&rexec.FanOut{
+		Targets:     targets,
+		Concurrency: o.parallel,
+		FailFast:    o.failFast,
+		Template:    o.template,
+		OnResult:    onResult,
+	}).Run(ctx, cmd)
+	if err != nil && !errors.Is(err, rexec.ErrFanOutFailed) {
+		c.errorf("run: %v", err)
+		return exitError
+	}
+	if err != nil && o.output != outputJSON {
+		c.errorf("%v", err)
+	}
+	return exitStatus(results)
+}
+
+// loadTargets returns the targets of the --target, --config and
+// --inventory flags, in this order.
+// The targets loaded before an error are returned with it, to be closed.
+func (o *runOptions) loadTargets() ([]rexec.FanOutTarget, error) {
+	var targets []rexec.FanOutTarget
+	add := func(f rexec.ExecutorFactory) error {
+		executor, err := f.Executor()
+		if err != nil {
+			return err
+		}
+		targets = append(targets, rexec.FanOutTarget{
+			Name:     rexec.TargetOf(executor).Name(),
+			Executor: executor,
+		})
+		return nil
+	}
+
+	for _, u := range o.targets {
+		f, err := rexec.ParseExecutorURL(u)
+		if err != nil {
+			return targets, err
+		}
+		if err := add(f); err != nil {
+			return targets, fmt.Errorf("target %s: %w", f, err)
+		}
+	}
+
+	for _, name := range o.configs {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return targets, err
+		}
+		if err := rexec.ValidateConfig(data); err != nil {
+			return targets, fmt.Errorf("%s: %w", name, err)
+		}
+		var f rexec.ExecutorFactory
+		if err := rexec.DecodeConfig(data, &f); err != nil {
+			return targets, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := add(f); err != nil {
+			return targets, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	if o.inventory != "" {
+		inv, err := rexec.LoadInventoryFile(o.inventory)
+		if err != nil {
+			return targets, err
+		}
+		selected, err := inv.Targets(o.selector)
+		if err != nil {
+			return targets, err
+		}
+		targets = append(targets, selected...)
+	}
+	return targets, nil
+}
+
+// closeTargets closes the executors of the targets.
+func closeTargets(targets []rexec.FanOutTarget) {
+	for _, t := range targets {
+		if c, ok := t.Executor.(io.Closer); ok {
+			_ = c.Close()
+		}
+	}
+}
+
+// commandLine returns the command line of the args: a single arg as is
+// (e.g. "uptime | cut -d, -f1"), or the args shell-quoted and joined.
+func commandLine(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = rexec.ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// exitStatus aggregates the results into the exit status of "rexec run":
+// exitNotRun if the command was not run to the end on any target, or the
+// highest exit status of the command otherwise.
+func exitStatus(results []rexec.HostResult) int {
+	code := exitOK
+	for _, r := range results {
+		switch {
+		case r.OK():
+		case r.Status <= 0:
+			return exitNotRun
+		default:
+			code = max(code, r.Status)
+		}
+	}
+	return code
+}
@@ -43,23 +43,37 @@ type Command struct {
 	Workdir string
 	// env is the environment variables to set for the command.
 	Env map[string]string
+	// Secrets are values (passwords, tokens, ...) that must never be logged.
+	// They are masked wherever they appear in the logs of the command.
+	// The values of env variables with secret keys are always masked, see
+	// IsSecretEnvKey.
+	Secrets []string
 
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
 
+	// Pty, if not nil, runs the command in a pseudo-terminal, e.g. for the
+	// programs prompting for a password or coloring their output only on a
+	// terminal. See PtyConfig.
+	Pty *PtyConfig
+
 	Status int
 
 	// executed is set to true after the command has been started.
 	// This is used to prevent running the same command multiple times.
 	started atomic.Bool
+	// resizer resizes the terminal of the running command, if any.
+	// See Resize.
+	resizer atomic.Pointer[func(rows, cols int) error]
 }
 
 // Validate checks if the shellCmd is safe to run.
 // It also sets the default Stdin, Stdout, and Stderr if they are nil.
 //
-// It returns an error if the command, workdir, or env contains dangerous
-// substrings defined by WorkdirDangerous, EnvDangerous, or CommandDangerous.
+// It returns an error if the command, workdir, env or pty term contains
+// dangerous substrings defined by WorkdirDangerous, EnvDangerous, or
+// CommandDangerous, or if the pty size is out of range.
 func (e *Command) Validate() error {
 	if e == nil {
 		return ErrNilCommand
@@ -73,22 +87,27 @@ func (e *Command) Validate() error {
 	if e.Workdir != "" {
 		if d, c := containsDangerous(e.Workdir, WorkdirDangerous); d {
 			return fmt.Errorf("workdir (%q) %w: %q",
-				e.Workdir, ErrContainsDangerous, c)
+				e.Redact(e.Workdir), ErrContainsDangerous, c)
 		}
 	}
 	for k, v := range e.Env {
 		if d, c := containsDangerous(k, EnvDangerous); d {
 			return fmt.Errorf("env key (%q=%q) %w: %q",
-				k, v, ErrContainsDangerous, c)
+				k, e.redactEnvValue(k, v), ErrContainsDangerous, c)
 		}
 		if d, c := containsDangerous(v, EnvDangerous); d {
 			return fmt.Errorf("env value (%q=%q) %w: %q",
-				k, v, ErrContainsDangerous, c)
+				k, e.redactEnvValue(k, v), ErrContainsDangerous, c)
 		}
 	}
 	if d, c := containsDangerous(e.Command, CommandDangerous); d {
 		return fmt.Errorf("command (%q) %w: %q",
-			e.Command, ErrContainsDangerous, c)
+			e.Redact(e.Command), ErrContainsDangerous, c)
+	}
+	if e.Pty != nil {
+		if err := e.Pty.validate(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -105,6 +124,72 @@ func (e *Command) setDefaultStdio() {
 	}
 }
 
+// Clone returns a copy of the command that can be executed again.
+//
+// The Env map, the Secrets and the Pty are deep-copied, the Status is reset
+// to 0, and the clone is not started, even if the original command has been
+// executed.
+//
+// The clone shares the Stdin, Stdout and Stderr of the original command,
+// unless a non-nil ManagedIO is given, which hijacks the clone with its
+// buffers (see ManagedIO.Hijack). Other streams can be assigned to the
+// fields of the returned clone directly:
+//
+//	again := cmd.Clone()               // same stdio as cmd
+//	again := cmd.Clone(NewManagedIO()) // fresh buffers
+//
+// Keep in mind that the shared Stdin may have been consumed by the
+// execution of the original command.
+func (e *Command) Clone(managed ...*ManagedIO) *Command {
+	if e == nil {
+		return nil
+	}
+
+	c := &Command{
+		Command: e.Command,
+		Workdir: e.Workdir,
+		Stdin:   e.Stdin,
+		Stdout:  e.Stdout,
+		Stderr:  e.Stderr,
+	}
+	if e.Secrets != nil {
+		c.Secrets = append([]string(nil), e.Secrets...)
+	}
+	if e.Env != nil {
+		c.Env = make(map[string]string, len(e.Env))
+		for k, v := range e.Env {
+			c.Env[k] = v
+		}
+	}
+	if e.Pty != nil {
+		c.Pty = e.Pty.clone()
+	}
+
+	for _, m := range managed {
+		if m != nil {
+			m.Hijack(c)
+		}
+	}
+
+	return c
+}
+
+// Reset makes an executed command executable again, by resetting its Status
+// and started state. The other fields, including the stdio, are kept as is.
+//
+// Reset is intended for deliberately reusing a Command value. It must not be
+// called while the command is being executed, and it does not rewind a
+// consumed Stdin: reassign it (e.g. by ManagedIO.Hijack) before the next
+// execution if needed. Prefer Clone if the previous execution may still be
+// running or its results are still needed.
+func (e *Command) Reset() {
+	if e == nil {
+		return
+	}
+	e.Status = 0
+	e.started.Store(false)
+}
+
 // ShellString returns a combined command line to run on a shell,
 // which cd to the workdir, sets the env variables, and runs the command:
 //
@@ -143,14 +228,15 @@ func (e *Command) envVarsParts() string {
 	return strings.Join(envs, " ") + " "
 }
 
+// LogValue implements slog.LogValuer. The secrets are masked, see Redact.
 func (e *Command) LogValue() slog.Value {
 	if e == nil {
 		return slog.StringValue("<nil>")
 	}
 	return slog.GroupValue(
-		slog.String("command", e.Command),
-		slog.String("workdir", e.Workdir),
-		slog.Any("env", e.Env),
+		slog.String("command", e.Redact(e.Command)),
+		slog.String("workdir", e.Redact(e.Workdir)),
+		slog.Any("env", e.RedactedEnv()),
 		// slog.Int("status", e.Status),
 	)
 }
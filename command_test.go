@@ -1,7 +1,10 @@
 package rexec
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"reflect"
 	"sort"
 	"testing"
@@ -152,7 +155,92 @@ func TestCommand_FromJson(t *testing.T) {
 		},
 	}
 
-	if !reflect.DeepEqual(cmd, expectedCmd) {
-		t.Errorf("Unmarshaled command does not match expected.\nGot: %#v\nWant: %#v", cmd, expectedCmd)
+	if !reflect.DeepEqual(&cmd, &expectedCmd) {
+		t.Errorf("Unmarshaled command does not match expected.\nGot: %#v\nWant: %#v", &cmd, &expectedCmd)
+	}
+}
+
+func TestCommand_Clone(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	cmd := &Command{
+		Command: "sh -c \"echo $REXEC1\"",
+		Env:     map[string]string{"REXEC1": "VALUE1"},
+		Stdout:  stdout,
+	}
+
+	if err := (&LocalExecutor{}).Execute(context.Background(), cmd); err != nil {
+		t.Fatalf("❌ Execute() error = %v", err)
+	}
+
+	clone := cmd.Clone()
+	if clone.Status != 0 || clone.started.Load() {
+		t.Errorf("❌ Clone() status = %d, started = %v, want a fresh command", clone.Status, clone.started.Load())
+	}
+	if clone.Stdout != cmd.Stdout || clone.Stdin != cmd.Stdin {
+		t.Errorf("❌ Clone() does not share the stdio")
+	}
+
+	// deep copy of env
+	clone.Env["REXEC1"] = "VALUE2"
+	if cmd.Env["REXEC1"] != "VALUE1" {
+		t.Errorf("❌ Clone() shares the env map: %v", cmd.Env)
+	}
+
+	if err := (&LocalExecutor{}).Execute(context.Background(), clone); err != nil {
+		t.Fatalf("❌ Execute() clone error = %v", err)
+	}
+	if got, want := stdout.String(), "VALUE1\nVALUE2\n"; got != want {
+		t.Errorf("❌ Execute() clone stdout = %q, want %q", got, want)
+	}
+
+	// with a new ManagedIO
+	m := NewManagedIO()
+	clone = cmd.Clone(m)
+	if clone.Stdout != m.Stdout || clone.Stderr != m.Stderr || clone.Stdin != m.Stdin {
+		t.Errorf("❌ Clone(ManagedIO) is not hijacked by the ManagedIO")
+	}
+	if err := (&LocalExecutor{}).Execute(context.Background(), clone); err != nil {
+		t.Fatalf("❌ Execute() clone error = %v", err)
+	}
+	if got, want := m.Stdout.String(), "VALUE1\n"; got != want {
+		t.Errorf("❌ Execute() clone stdout = %q, want %q", got, want)
+	}
+	if got, want := stdout.String(), "VALUE1\nVALUE2\n"; got != want {
+		t.Errorf("❌ Execute() clone wrote to the original stdout: %q", got)
+	}
+
+	if (*Command)(nil).Clone() != nil {
+		t.Errorf("❌ Clone() of nil command is not nil")
+	}
+	t.Logf("✅ Clone() ok")
+}
+
+func TestCommand_Reset(t *testing.T) {
+	m := NewManagedIO()
+	cmd := &Command{Command: "sh -c \"exit 3\""}
+	m.Hijack(cmd)
+
+	executor := &LocalExecutor{}
+	_ = executor.Execute(context.Background(), cmd)
+	if cmd.Status != 3 {
+		t.Fatalf("❌ Execute() status = %d, want 3", cmd.Status)
+	}
+	if err := executor.Execute(context.Background(), cmd); !errors.Is(err, ErrStartedCommand) {
+		t.Fatalf("❌ Execute() again error = %v, want %v", err, ErrStartedCommand)
+	}
+
+	cmd.Reset()
+	if cmd.Status != 0 {
+		t.Errorf("❌ Reset() status = %d, want 0", cmd.Status)
+	}
+
+	cmd.Command = "echo again"
+	if err := executor.Execute(context.Background(), cmd); err != nil {
+		t.Errorf("❌ Execute() after Reset() error = %v", err)
+	}
+	if got := m.Stdout.String(); got != "again\n" {
+		t.Errorf("❌ Execute() after Reset() stdout = %q, want %q", got, "again\n")
+	} else {
+		t.Logf("✅ Reset() ok")
 	}
 }
@@ -0,0 +1,309 @@
+package rexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// This file provides helpers to decode configurations (policies, executors,
+// ...) from JSON or YAML.
+//
+// YAML documents are converted to JSON before decoding, so that both formats
+// bind to the structs with the same (case-insensitive) field names, e.g.
+// ShellPath is "ShellPath" (or "shellPath") in both JSON and YAML.
+
+// decodeConfig decodes the JSON or YAML data into v.
+// JSON is detected by a leading '{' or '['; anything else is YAML.
+func decodeConfig(data []byte, v any) error {
+	data, err := configToJSON(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// configToJSON converts the JSON or YAML data to JSON.
+// JSON data is returned as is.
+func configToJSON(data []byte) ([]byte, error) {
+	if isJSON(data) {
+		return data, nil
+	}
+	return yamlToJSON(data)
+}
+
+// isJSON reports whether the data looks like a JSON object or array.
+func isJSON(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')
+}
+
+// yamlToJSON converts a YAML document to JSON.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadConfigFormat, err)
+	}
+	return json.Marshal(jsonCompatible(v))
+}
+
+// jsonCompatible converts the map[any]any values decoded from YAML
+// (e.g. with non-string keys) into map[string]any recursively.
+func jsonCompatible(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonCompatible(e)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = jsonCompatible(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// DecodeConfig strictly decodes the JSON or YAML data into v: unlike the
+// lenient json.Unmarshal, the unknown fields (e.g. a typo "ShelPath") and the
+// values of wrong types are errors.
+//
+// It reports all the problems at once, as ConfigErrors with the paths to
+// them, e.g. "Shell.ShelPath: unknown field".
+// Fields of interface types (e.g. ExecutorFactory.Config) are not checked.
+func DecodeConfig(data []byte, v any) error {
+	data, err := configToJSON(data)
+	if err != nil {
+		return err
+	}
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadConfigFormat, err)
+	}
+	if errs := checkConfigFields("", raw, reflect.TypeOf(v)); len(errs) > 0 {
+		return errs.sorted()
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ConfigError is a problem in a configuration at the Path, e.g.
+// "KeepAliveSsh.Config.Auth[0].PrivateKeyPath".
+// The Path is empty for the problems of the whole configuration.
+type ConfigError struct {
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// ConfigErrors are all the problems in a configuration, sorted by path.
+// errors.Is and errors.As match any of them.
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (errs ConfigErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, e := range errs {
+		unwrapped[i] = e
+	}
+	return unwrapped
+}
+
+// add appends a ConfigError at the path.
+func (errs *ConfigErrors) add(path string, err error) {
+	*errs = append(*errs, &ConfigError{Path: path, Err: err})
+}
+
+// sorted sorts the errors by path (stably) and returns them.
+func (errs ConfigErrors) sorted() ConfigErrors {
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+// orNil returns nil if there is no error, so that the result is a nil
+// error instead of an empty ConfigErrors.
+func (errs ConfigErrors) orNil() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs.sorted()
+}
+
+// configPath joins the path and the key of a field.
+func configPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// checkConfigFields checks the raw JSON value (decoded into any) against the
+// type t, as json.Unmarshal would decode it: it reports the unknown fields of
+// structs and the values of wrong types.
+func checkConfigFields(path string, raw any, t reflect.Type) (errs ConfigErrors) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if raw == nil || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+
+	wrongType := func(want string) ConfigErrors {
+		return ConfigErrors{{Path: path, Err: fmt.Errorf("%w: want %s, got %s", ErrConfigType, want, jsonTypeName(raw))}}
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return nil
+	case reflect.Struct:
+		obj, ok := raw.(map[string]any)
+		if !ok {
+			return wrongType("object")
+		}
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(obj) {
+			field, ok := lookupJSONField(fields, key)
+			if !ok {
+				errs.add(configPath(path, key), ErrUnknownConfigField)
+				continue
+			}
+			errs = append(errs, checkConfigFields(configPath(path, key), obj[key], field.Type)...)
+		}
+	case reflect.Map:
+		obj, ok := raw.(map[string]any)
+		if !ok {
+			return wrongType("object")
+		}
+		for _, key := range sortedKeys(obj) {
+			errs = append(errs, checkConfigFields(configPath(path, key), obj[key], t.Elem())...)
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if _, ok := raw.(string); !ok { // []byte is base64
+				return wrongType("string")
+			}
+			return nil
+		}
+		arr, ok := raw.([]any)
+		if !ok {
+			return wrongType("array")
+		}
+		for i, e := range arr {
+			errs = append(errs, checkConfigFields(fmt.Sprintf("%s[%d]", path, i), e, t.Elem())...)
+		}
+	case reflect.String:
+		if _, ok := raw.(string); !ok {
+			return wrongType("string")
+		}
+	case reflect.Bool:
+		if _, ok := raw.(bool); !ok {
+			return wrongType("boolean")
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := raw.(float64); !ok {
+			return wrongType("number")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := raw.(float64)
+		if !ok || n != float64(int64(n)) {
+			return wrongType("integer")
+		}
+	}
+	return errs
+}
+
+// jsonTypeName returns the JSON type of the raw value.
+func jsonTypeName(raw any) string {
+	switch v := raw.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return fmt.Sprintf("%T", raw)
+	}
+}
+
+// jsonFields returns the fields of the struct type t decoded by
+// encoding/json (including the promoted ones of embedded structs),
+// by their JSON names.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || (f.Anonymous && f.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// lookupJSONField finds the field of the key like encoding/json does:
+// an exact match, or else a case-insensitive one.
+func lookupJSONField(fields map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+	if f, ok := fields[key]; ok {
+		return f, true
+	}
+	for name, f := range fields {
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// config decoding errors
+var (
+	ErrBadConfigFormat    = fmt.Errorf("bad config format")
+	ErrUnknownConfigField = fmt.Errorf("unknown field")
+	ErrConfigType         = fmt.Errorf("wrong type")
+)
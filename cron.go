@@ -0,0 +1,297 @@
+package rexec
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements the cron expressions of the Scheduler.
+
+// CronSchedule is a parsed cron expression (see ParseCron).
+type CronSchedule struct {
+	expr string
+
+	// the allowed values of each field, as bit sets
+	second, minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set if the day-of-month or the day-of-week
+	// field is "*" or "?": see matchDay.
+	domAny, dowAny bool
+
+	// every is the interval of an "@every" schedule.
+	every time.Duration
+
+	// Location is the time zone the expression is evaluated in.
+	// If nil, time.Local is used.
+	Location *time.Location
+}
+
+// cronField describes a field of cron expressions.
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+	// question is set if "?" means "*" in the field.
+	question bool
+}
+
+var (
+	cronSecond = cronField{name: "second", min: 0, max: 59}
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31, question: true}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is Sunday too.
+	cronDow = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}, question: true}
+)
+
+// cronMacros are the predefined schedules.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// ParseCron parses a cron expression:
+//
+//	[CRON_TZ=<zone>] [second] minute hour day-of-month month day-of-week
+//
+// The second field is optional: without it, the expression runs at second
+// 0. Each field is "*", a value, a range "a-b", or a list of them
+// separated by ",", each optionally with a step "/n" (e.g. "*/15",
+// "1-30/2"). The months and the days of the week can be written by their 3
+// first letters (e.g. "jan", "MON"); Sunday is 0 or 7. "?" means "*" in the
+// day fields.
+//
+// As in the classic cron, if both the day of month and the day of week are
+// restricted, a day matches if either of them does.
+//
+// The macros @yearly (or @annually), @monthly, @weekly, @daily (or
+// @midnight) and @hourly are supported, as well as "@every <duration>"
+// (see time.ParseDuration) for fixed intervals.
+//
+// The expression is evaluated in the time zone of the CRON_TZ= (or TZ=)
+// prefix, e.g. "CRON_TZ=Europe/Paris 0 30 9 * * mon-fri", or in the
+// Location of the schedule (time.Local by default). In the hour repeated
+// when the clocks go back, the matching times match twice; the times
+// skipped when the clocks go forward do not match.
+func ParseCron(expr string) (*CronSchedule, error) {
+	s := &CronSchedule{expr: expr}
+
+	fields := strings.Fields(expr)
+	if len(fields) > 0 {
+		if zone, ok := cutCronTZ(fields[0]); ok {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q: %w", ErrBadCron, expr, err)
+			}
+			s.Location = loc
+			fields = fields[1:]
+		}
+	}
+
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		if fields[0] == "@every" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%w: %q: want @every <duration>", ErrBadCron, expr)
+			}
+			every, err := time.ParseDuration(fields[1])
+			if err != nil || every < time.Second {
+				return nil, fmt.Errorf("%w: %q: bad interval: want a duration >= 1s", ErrBadCron, expr)
+			}
+			s.every = every
+			return s, nil
+		}
+		macro, ok := cronMacros[strings.ToLower(fields[0])]
+		if !ok || len(fields) != 1 {
+			return nil, fmt.Errorf("%w: %q: unknown macro", ErrBadCron, expr)
+		}
+		fields = strings.Fields(macro)
+	}
+
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("%w: %q: want 5 or 6 fields, got %d", ErrBadCron, expr, len(fields))
+	}
+
+	var err error
+	for _, f := range []struct {
+		set   *uint64
+		any   *bool
+		field cronField
+		text  string
+	}{
+		{set: &s.second, field: cronSecond, text: fields[0]},
+		{set: &s.minute, field: cronMinute, text: fields[1]},
+		{set: &s.hour, field: cronHour, text: fields[2]},
+		{set: &s.dom, any: &s.domAny, field: cronDom, text: fields[3]},
+		{set: &s.month, field: cronMonth, text: fields[4]},
+		{set: &s.dow, any: &s.dowAny, field: cronDow, text: fields[5]},
+	} {
+		if f.any != nil && (f.text == "*" || f.text == "?") {
+			// only the plain wildcards: "*/2" restricts the days
+			*f.any = true
+		}
+		if *f.set, err = parseCronField(f.text, f.field); err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrBadCron, expr, err)
+		}
+	}
+	// Sunday is 0.
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+// cutCronTZ returns the zone of a "CRON_TZ=<zone>" or "TZ=<zone>" field.
+func cutCronTZ(field string) (string, bool) {
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if zone, ok := strings.CutPrefix(field, prefix); ok {
+			return zone, true
+		}
+	}
+	return "", false
+}
+
+// parseCronField parses a field of a cron expression into a bit set of the
+// values it allows.
+func parseCronField(text string, field cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(text, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("%s: bad step %q", field.name, stepText)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rng == "*" || rng == "?" && field.question:
+			lo, hi = field.min, field.max
+		default:
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = field.value(loText); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = field.value(hiText); err != nil {
+					return 0, err
+				}
+				if hi < lo {
+					return 0, fmt.Errorf("%s: bad range %q", field.name, rng)
+				}
+			} else if hasStep {
+				// "a/n" is "a-max/n"
+				hi = field.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// value parses a value (a number or a name) of the field.
+func (f cronField) value(text string) (int, error) {
+	if v, ok := f.names[strings.ToLower(text)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s: bad value %q: want %d-%d", f.name, text, f.min, f.max)
+	}
+	return v, nil
+}
+
+// String returns the expression the schedule was parsed from.
+func (s *CronSchedule) String() string {
+	return s.expr
+}
+
+// Next returns the first time of the schedule strictly after t, at a whole
+// second, or the zero time if there is none in the next 5 years (e.g.
+// "0 0 30 2 *").
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Truncate(time.Second).Add(s.every)
+	}
+
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc).Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+
+	nextHour := func(t time.Time) time.Time {
+		return t.Add(time.Hour - time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second)
+	}
+	// startOf returns the midnight of the date, or the next hour of t if the
+	// midnight does not exist (the DST starts at midnight in some zones) and
+	// time.Date normalizes it back before t.
+	startOf := func(year int, month time.Month, day int) time.Time {
+		if start := time.Date(year, month, day, 0, 0, 0, 0, loc); start.After(t) {
+			return start
+		}
+		return nextHour(t)
+	}
+
+	// the days and months are advanced on the wall clock, and the smaller
+	// units on the absolute time, so that t always moves forward across the
+	// DST transitions.
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = startOf(t.Year(), t.Month()+1, 1)
+		case !s.matchDay(t):
+			t = startOf(t.Year(), t.Month(), t.Day()+1)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = nextHour(t)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute - time.Duration(t.Second())*time.Second)
+		case s.second&(1<<t.Second()) == 0:
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay reports whether the day of t matches the day-of-month and the
+// day-of-week fields.
+func (s *CronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// cron errors
+var (
+	ErrBadCron = errors.New("bad cron expression")
+)
@@ -0,0 +1,96 @@
+package rexec
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Paris"); err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	utc := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.Parse(time.DateTime, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name  string
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		{name: "everySecond", expr: "* * * * * *", after: utc("2024-01-01 00:00:00"), want: utc("2024-01-01 00:00:01")},
+		{name: "fiveFields", expr: "*/15 * * * *", after: utc("2024-01-01 00:07:30"), want: utc("2024-01-01 00:15:00")},
+		{name: "seconds", expr: "30 */2 * * * *", after: utc("2024-01-01 00:00:30"), want: utc("2024-01-01 00:02:30")},
+		{name: "list", expr: "0 0 9,17 * * *", after: utc("2024-01-01 09:00:00"), want: utc("2024-01-01 17:00:00")},
+		{name: "range", expr: "0 0 9-10 * * *", after: utc("2024-01-01 10:00:00"), want: utc("2024-01-02 09:00:00")},
+		{name: "weekday", expr: "0 0 9 * * mon-fri", after: utc("2024-01-05 10:00:00"), want: utc("2024-01-08 09:00:00")},
+		{name: "sunday7", expr: "0 0 0 * * 7", after: utc("2024-01-01 00:00:00"), want: utc("2024-01-07 00:00:00")},
+		{name: "month", expr: "0 0 0 1 JUL *", after: utc("2024-01-01 00:00:00"), want: utc("2024-07-01 00:00:00")},
+		{name: "domOrDow", expr: "0 0 0 15 * mon", after: utc("2024-01-02 00:00:00"), want: utc("2024-01-08 00:00:00")},
+		{name: "domStep", expr: "0 0 0 */10 * ?", after: utc("2024-01-02 00:00:00"), want: utc("2024-01-11 00:00:00")},
+		{name: "leapDay", expr: "0 0 29 2 *", after: utc("2024-03-01 00:00:00"), want: utc("2028-02-29 00:00:00")},
+		{name: "never", expr: "0 0 30 2 *", after: utc("2024-01-01 00:00:00"), want: time.Time{}},
+		{name: "daily", expr: "@daily", after: utc("2024-01-01 12:00:00"), want: utc("2024-01-02 00:00:00")},
+		{name: "every", expr: "@every 90s", after: utc("2024-01-01 00:00:00"), want: utc("2024-01-01 00:01:30")},
+		{name: "timezone", expr: "CRON_TZ=Europe/Paris 0 30 9 * * *", after: utc("2024-01-01 00:00:00"), want: utc("2024-01-01 08:30:00")},
+		// the clocks go forward at 02:00 CET: 02:30 does not exist
+		{name: "dstGap", expr: "TZ=Europe/Paris 0 30 2 * * *", after: utc("2024-03-30 12:00:00"), want: utc("2024-04-01 00:30:00")},
+		// the clocks go back at 03:00 CEST: after 02:30 CEST comes 02:30 CET
+		{name: "dstRepeat", expr: "TZ=Europe/Paris 0 30 2 * * *", after: utc("2024-10-27 00:30:00"), want: utc("2024-10-27 01:30:00")},
+		// the clocks go forward at 00:00 -04: the midnight does not exist
+		{name: "dstAtMidnight", expr: "CRON_TZ=America/Santiago 0 0 12 * * mon", after: utc("2024-09-06 12:00:00"), want: utc("2024-09-09 15:00:00")},
+		{name: "dstAtMidnightFirstHour", expr: "CRON_TZ=America/Havana 0 0 * 10 3 *", after: utc("2024-03-08 12:00:00"), want: utc("2024-03-10 05:00:00")},
+		{name: "dstAtMidnightHavana", expr: "CRON_TZ=America/Havana 0 0 12 * * mon", after: utc("2024-03-08 12:00:00"), want: utc("2024-03-11 16:00:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("❌ ParseCron(%q) error = %v", tt.expr, err)
+			}
+			if s.Location == nil {
+				s.Location = time.UTC
+			}
+			got := s.Next(tt.after)
+			if !got.Equal(tt.want) {
+				t.Errorf("❌ Next(%v) = %v, want %v", tt.after, got.UTC(), tt.want)
+				return
+			}
+			t.Logf("✅ Next(%v) = %v", tt.after, got)
+		})
+	}
+}
+
+func TestParseCron_bad(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * * mon-",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"? * * * *",
+		"@weekly 1",
+		"@often",
+		"@every 1ms",
+		"CRON_TZ=Nowhere/Land * * * * *",
+	} {
+		if _, err := ParseCron(expr); !errors.Is(err, ErrBadCron) {
+			t.Errorf("❌ ParseCron(%q) error = %v, want %v", expr, err, ErrBadCron)
+		} else {
+			t.Logf("✅ ParseCron(%q) error = %v", expr, err)
+		}
+	}
+}
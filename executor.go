@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	osexec "os/exec"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -18,22 +20,33 @@ type Executor interface {
 	//  1. Fast fail if the command is nil.
 	//  2. Fast fail if the command has already been executed.
 	//  3. Set the command status to -1.
-	//  4. Validate the command.
+	//  4. Validate the command. (Hooks.OnValidated)
 	//  5. Prepare the command, make the proc/client/session/... to execute the command.
-	//  6. Start the command in another goroutine.
+	//  6. Start the command in another goroutine. (Hooks.OnStart, then Hooks.OnOutput for each chunk of output)
 	//  7. Wait for the command to finish in the main goroutine.
 	//  8. set status (exit code) of the command. (prefer to do this in a defer statement placing at 3~5 as early as possible)
-	//  9. return the error.
+	//  9. return the error. (Hooks.OnExit, from 4 on)
 	Execute(ctx context.Context, cmd *Command) error
 }
 
 // LocalExecutor runs command with os/exec on the local machine.
-type LocalExecutor struct{}
+type LocalExecutor struct {
+	// Logger, if not nil, overrides the global Logger for this executor.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+	// Tracer, if not nil, traces the phases of the executions. It can be
+	// overridden by the ctx (see WithTracer).
+	Tracer Tracer `json:"-"`
+	// Hooks, if not nil, are called at the points of the executions.
+	// The hooks in the ctx (see WithHooks) are called after them.
+	Hooks *Hooks `json:"-"`
+}
 
 var _ Executor = (*LocalExecutor)(nil)
 
-func (e *LocalExecutor) Execute(ctx context.Context, cmd *Command) error {
-	logger := Logger.With("field", "rexec.LocalExecutor.Execute", "cmd", cmd)
+func (e *LocalExecutor) Execute(ctx context.Context, cmd *Command) (err error) {
+	baseLogger := loggerFor(ctx, e.Logger)
+	logger := baseLogger.With("field", "rexec.LocalExecutor.Execute", "cmd", cmd)
 
 	if err := ctx.Err(); err != nil {
 		logger.Info("skipping execution: context done", "ctxErr", err)
@@ -56,11 +69,17 @@ func (e *LocalExecutor) Execute(ctx context.Context, cmd *Command) error {
 
 	cmd.Status = -1
 
+	hooks := hooksFor(ctx, e.Hooks, cmd, e.TargetInfo())
+	defer func() { hooks.exited(err) }() // after the status is set by the deferring below
+
 	if err := cmd.Validate(); err != nil {
 		logger.Warn("reject execution: invalid command", "err", err)
 		return fmt.Errorf("%w: %w", ErrInvalidCommand, err)
 	}
 
+	hooks.validated()
+	defer hooks.wrapOutput()()
+
 	// we don't rely on the ShellString() here,
 	// see proc.Dir and proc.Env below.
 	cmdStr := cmd.Command
@@ -74,12 +93,14 @@ func (e *LocalExecutor) Execute(ctx context.Context, cmd *Command) error {
 	}
 	proc := osexec.Command(cmdParts[0], cmdParts[1:]...)
 
+	// set by runProc: the ProcessState of the proc must not be read here,
+	// it may still be being written by Wait if the ctx is done.
+	status := -1
 	defer func() {
-		if proc != nil && proc.ProcessState != nil {
-			cmd.Status = proc.ProcessState.ExitCode()
+		cmd.Status = status
+		if status != -1 {
 			logger.Debug("command finished. setting status", "status", cmd.Status)
 		} else {
-			cmd.Status = -1
 			logger.Warn("failed to get exit code of the command. setting default -1")
 		}
 	}()
@@ -96,9 +117,24 @@ func (e *LocalExecutor) Execute(ctx context.Context, cmd *Command) error {
 	proc.Stdout = cmd.Stdout
 	proc.Stderr = cmd.Stderr
 
-	logger.Debug("os/exec.Cmd is ready to take off", "proc", proc.String())
+	started := hooks.started
+	if cmd.Pty != nil {
+		p, err := openLocalPty(cmd, proc)
+		if err != nil {
+			logger.Warn("failed to open pty", "err", err)
+			return err
+		}
+		defer p.close() // after the process exits, before the output is restored
+		started = func() {
+			p.start()
+			hooks.started()
+		}
+	}
 
-	err = runProc(ctx, proc)
+	logger.Debug("os/exec.Cmd is ready to take off", "proc", cmd.Redact(proc.String()))
+
+	status, err = runProc(ctx, baseLogger, tracerFor(ctx, e.Tracer), proc, started,
+		slog.String(AttrCommand, cmd.Redact(proc.String())))
 	if err != nil {
 		logger.Warn("command execution failed", "err", err)
 	} else {
@@ -118,12 +154,23 @@ func (e *LocalExecutor) Execute(ctx context.Context, cmd *Command) error {
 type ShellExecutor struct {
 	ShellPath string
 	ShellArgs []string
+
+	// Logger, if not nil, overrides the global Logger for this executor.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+	// Tracer, if not nil, traces the phases of the executions. It can be
+	// overridden by the ctx (see WithTracer).
+	Tracer Tracer `json:"-"`
+	// Hooks, if not nil, are called at the points of the executions.
+	// The hooks in the ctx (see WithHooks) are called after them.
+	Hooks *Hooks `json:"-"`
 }
 
 var _ Executor = (*ShellExecutor)(nil)
 
-func (e *ShellExecutor) Execute(ctx context.Context, cmd *Command) error {
-	logger := Logger.With("field", "rexec.ShellExecutor.Execute", "cmd", cmd)
+func (e *ShellExecutor) Execute(ctx context.Context, cmd *Command) (err error) {
+	baseLogger := loggerFor(ctx, e.Logger)
+	logger := baseLogger.With("field", "rexec.ShellExecutor.Execute", "cmd", cmd)
 
 	if err := ctx.Err(); err != nil {
 		logger.Info("skipping execution: context done", "ctxErr", err)
@@ -144,22 +191,30 @@ func (e *ShellExecutor) Execute(ctx context.Context, cmd *Command) error {
 
 	cmd.Status = -1
 
+	hooks := hooksFor(ctx, e.Hooks, cmd, e.TargetInfo())
+	defer func() { hooks.exited(err) }() // after the status is set by the deferring below
+
 	if err := cmd.Validate(); err != nil {
 		logger.Warn("reject execution: invalid command", "err", err)
 		return fmt.Errorf("%w: %w", ErrInvalidCommand, err)
 	}
 
+	hooks.validated()
+	defer hooks.wrapOutput()()
+
 	cmdStr := cmd.ShellString()
 
 	// Execute the command
 	proc := osexec.Command(e.ShellPath, append(e.ShellArgs, cmdStr)...)
 
+	// set by runProc: the ProcessState of the proc must not be read here,
+	// it may still be being written by Wait if the ctx is done.
+	status := -1
 	defer func() {
-		if proc != nil && proc.ProcessState != nil {
-			cmd.Status = proc.ProcessState.ExitCode()
+		cmd.Status = status
+		if status != -1 {
 			logger.Debug("command finished. setting status", "status", cmd.Status)
 		} else {
-			cmd.Status = -1
 			logger.Warn("failed to get exit code of the command. setting default -1")
 		}
 	}()
@@ -175,9 +230,24 @@ func (e *ShellExecutor) Execute(ctx context.Context, cmd *Command) error {
 	proc.Stdout = cmd.Stdout
 	proc.Stderr = cmd.Stderr
 
-	logger.Debug("os/exec.Cmd is ready to take off", "proc", proc.String())
+	started := hooks.started
+	if cmd.Pty != nil {
+		p, err := openLocalPty(cmd, proc)
+		if err != nil {
+			logger.Warn("failed to open pty", "err", err)
+			return err
+		}
+		defer p.close() // after the process exits, before the output is restored
+		started = func() {
+			p.start()
+			hooks.started()
+		}
+	}
+
+	logger.Debug("os/exec.Cmd is ready to take off", "proc", cmd.Redact(proc.String()))
 
-	err := runProc(ctx, proc)
+	status, err = runProc(ctx, baseLogger, tracerFor(ctx, e.Tracer), proc, started,
+		slog.String(AttrCommand, cmd.Redact(proc.String())))
 
 	if err != nil {
 		logger.Warn("command execution failed", "err", err)
@@ -190,21 +260,43 @@ func (e *ShellExecutor) Execute(ctx context.Context, cmd *Command) error {
 
 // runProc starts the os/exec process and waits for it to finish or
 // the context to be done.
-func runProc(ctx context.Context, proc *osexec.Cmd) error {
-	logger := Logger.With("field", "rexec.runProc", "proc", proc.String())
-
+//
+// The started callback (if not nil) is called after the process has been
+// started.
+//
+// It returns the exit status of the process, or -1 if it has not exited
+// (e.g. failed to start, or killed as the context is done).
+//
+// It is traced as a "rexec.runProc" span, with the attrs.
+func runProc(ctx context.Context, logger *slog.Logger, tracer Tracer, proc *osexec.Cmd, started func(), attrs ...slog.Attr) (status int, err error) {
 	if proc == nil {
-		return fmt.Errorf("%w: nil process", ErrInternalError)
+		return -1, fmt.Errorf("%w: nil process", ErrInternalError)
 	}
 
+	// do not log the args or env: they may contain secrets.
+	logger = logger.With("field", "rexec.runProc", "path", proc.Path)
+
+	_, span := tracer.Start(ctx, "rexec.runProc", append(attrs, slog.String(AttrPath, proc.Path))...)
+	defer func() { endSpan(span, err) }()
+
 	if err := proc.Start(); err != nil {
 		logger.Error("failed to start process", "err", err)
-		return err
+		return -1, err
+	}
+	if started != nil {
+		started()
 	}
 
-	done := make(chan error)
+	// the ProcessState is set by Wait: it is only read here, by the
+	// waiting goroutine, which sends the exit status with the result.
+	type waitResult struct {
+		err    error
+		status int
+	}
+	done := make(chan waitResult, 1)
 	go func() {
-		done <- proc.Wait()
+		err := proc.Wait()
+		done <- waitResult{err: err, status: proc.ProcessState.ExitCode()}
 		logger.Debug("process finished")
 	}()
 
@@ -213,10 +305,11 @@ func runProc(ctx context.Context, proc *osexec.Cmd) error {
 		err := ctx.Err()
 		logger.Debug("context done, killing process", "ctxErr", err)
 		_ = proc.Process.Kill()
-		return err
-	case err := <-done:
-		logger.Debug("process done", "exitErr", err)
-		return err
+		return -1, err
+	case r := <-done:
+		logger.Debug("process done", "exitErr", r.err)
+		span.SetAttributes(slog.Int(AttrExitStatus, r.status))
+		return r.status, r.err
 	}
 }
 
@@ -229,12 +322,27 @@ func runProc(ctx context.Context, proc *osexec.Cmd) error {
 // But keep in mind that the connections won't be reused between commands.
 type ImmediateSshExecutor struct {
 	Config *SshClientConfig
+
+	// Metrics, if not nil, collects the metrics of the SSH connections,
+	// e.g. the dial latency. See Metrics.
+	Metrics *Metrics `json:"-"`
+
+	// Logger, if not nil, overrides the global Logger for this executor.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+	// Tracer, if not nil, traces the phases of the executions. It can be
+	// overridden by the ctx (see WithTracer).
+	Tracer Tracer `json:"-"`
+	// Hooks, if not nil, are called at the points of the executions.
+	// The hooks in the ctx (see WithHooks) are called after them.
+	Hooks *Hooks `json:"-"`
 }
 
 var _ Executor = (*ImmediateSshExecutor)(nil)
 
 func (e *ImmediateSshExecutor) Execute(ctx context.Context, cmd *Command) error {
-	logger := Logger.With("field", "rexec.ImmediateSshExecutor.Execute", "cmd", cmd)
+	baseLogger := loggerFor(ctx, e.Logger)
+	logger := baseLogger.With("field", "rexec.ImmediateSshExecutor.Execute", "cmd", cmd)
 
 	var err error // Avoid shadowing, use this as the return value
 
@@ -262,6 +370,9 @@ func (e *ImmediateSshExecutor) Execute(ctx context.Context, cmd *Command) error
 
 	cmd.Status = -1
 
+	hooks := hooksFor(ctx, e.Hooks, cmd, e.TargetInfo())
+	defer func() { hooks.exited(err) }() // after the status is set by the deferring below
+
 	// after this deferring, ANY return path should set error to the `err`
 	// variable. do not `return someFunc()` directly!!
 	defer func() {
@@ -284,7 +395,12 @@ func (e *ImmediateSshExecutor) Execute(ctx context.Context, cmd *Command) error
 		return err
 	}
 
-	client, err := dialSsh(e.Config)
+	hooks.validated()
+	defer hooks.wrapOutput()()
+
+	dialStart := time.Now()
+	client, err := dialSsh(ctx, baseLogger, tracerFor(ctx, e.Tracer), e.Config)
+	e.Metrics.observeSshDial(e.Config.Addr, dialStart, err)
 	if err != nil {
 		logger.Warn("failed to dial SSH client", "err", err)
 		return err
@@ -293,7 +409,7 @@ func (e *ImmediateSshExecutor) Execute(ctx context.Context, cmd *Command) error
 		_ = client.Close()
 	}(client)
 
-	err = execWithSshClient(ctx, cmd, client)
+	err = execWithSshClient(ctx, baseLogger, tracerFor(ctx, e.Tracer), cmd, client, hooks.started)
 
 	if err != nil {
 		logger.Warn("command execution failed", "err", err)
@@ -314,6 +430,20 @@ func (e *ImmediateSshExecutor) Execute(ctx context.Context, cmd *Command) error
 type KeepAliveSshExecutor struct {
 	Config *SshClientConfig
 
+	// Metrics, if not nil, collects the metrics of the SSH connections,
+	// e.g. the dial latency, keep-alive failures and redials. See Metrics.
+	Metrics *Metrics `json:"-"`
+
+	// Logger, if not nil, overrides the global Logger for this executor.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+	// Tracer, if not nil, traces the phases of the executions. It can be
+	// overridden by the ctx (see WithTracer).
+	Tracer Tracer `json:"-"`
+	// Hooks, if not nil, are called at the points of the executions.
+	// The hooks in the ctx (see WithHooks) are called after them.
+	Hooks *Hooks `json:"-"`
+
 	ka *keepAliveSshClient
 }
 
@@ -323,6 +453,9 @@ var _ Executor = (*KeepAliveSshExecutor)(nil)
 func (e *KeepAliveSshExecutor) init() {
 	e.ka = &keepAliveSshClient{
 		SshClientConfig: e.Config,
+		logger:          e.Logger,
+		metrics:         e.Metrics,
+		tracer:          e.Tracer,
 	}
 }
 
@@ -334,7 +467,8 @@ func (e *KeepAliveSshExecutor) init() {
 //
 // The connection will be kept alive until Close() is called.
 func (e *KeepAliveSshExecutor) Execute(ctx context.Context, cmd *Command) error {
-	logger := Logger.With("field", "rexec.KeepAliveSshExecutor.Execute", "cmd", cmd)
+	baseLogger := loggerFor(ctx, e.Logger)
+	logger := baseLogger.With("field", "rexec.KeepAliveSshExecutor.Execute", "cmd", cmd)
 
 	if err := validateSshClientConfig(e.Config); err != nil {
 		logger.Warn("reject execution: bad SSH client config", "err", err)
@@ -372,6 +506,9 @@ func (e *KeepAliveSshExecutor) Execute(ctx context.Context, cmd *Command) error
 
 	cmd.Status = -1
 
+	hooks := hooksFor(ctx, e.Hooks, cmd, e.TargetInfo())
+	defer func() { hooks.exited(err) }() // after the status is set by the deferring below
+
 	// after this deferring, ANY return path should set error to the `err`
 	// variable. do not `return someFunc()` directly!!
 	defer func() {
@@ -393,14 +530,17 @@ func (e *KeepAliveSshExecutor) Execute(ctx context.Context, cmd *Command) error
 		return err
 	}
 
+	hooks.validated()
+	defer hooks.wrapOutput()()
+
 	var client *ssh.Client
-	client, err = e.ka.Client()
+	client, err = e.ka.ClientContext(ctx, tracerFor(ctx, e.Tracer))
 	if err != nil {
 		logger.Warn("failed to get SSH client", "err", err)
 		return err
 	}
 
-	err = execWithSshClient(ctx, cmd, client)
+	err = execWithSshClient(ctx, baseLogger, tracerFor(ctx, e.Tracer), cmd, client, hooks.started)
 
 	if err != nil {
 		logger.Warn("command execution failed", "err", err)
@@ -432,8 +572,15 @@ func (e *KeepAliveSshExecutor) Close() error {
 //   - the given client must be dialed and ready to use.
 //
 // Blocks until the command is finished or the context is done.
-func execWithSshClient(ctx context.Context, cmd *Command, client *ssh.Client) error {
-	logger := Logger.With("field", "rexec.execWithSshClient", "cmd", cmd, "client", sshClientString(client))
+//
+// The started callback (if not nil) is called after the command has been
+// started on the session.
+//
+// It is traced as a "rexec.execWithSshClient" span, with the
+// "rexec.ssh.newSession" and "rexec.runSshSession" phases as its children.
+func execWithSshClient(ctx context.Context, logger *slog.Logger, tracer Tracer, cmd *Command, client *ssh.Client, started func()) (err error) {
+	baseLogger := logger
+	logger = logger.With("field", "rexec.execWithSshClient", "cmd", cmd, "client", sshClientString(client))
 
 	if client == nil {
 		return fmt.Errorf("%w: nil ssh client", ErrInternalError)
@@ -442,10 +589,18 @@ func execWithSshClient(ctx context.Context, cmd *Command, client *ssh.Client) er
 		return ErrNilCommand
 	}
 
+	ctx, span := tracer.Start(ctx, "rexec.execWithSshClient",
+		slog.String(AttrHost, client.RemoteAddr().String()),
+		slog.String(AttrUser, client.User()),
+		slog.String(AttrCommand, cmd.RedactedShellString()))
+	defer func() { endSpan(span, err) }()
+
+	_, sessionSpan := tracer.Start(ctx, "rexec.ssh.newSession")
 	session, err := client.NewSession()
+	endSpan(sessionSpan, err)
 	if err != nil {
 		logger.Warn("failed to create SSH session", "err", err)
-		return err
+		return fmt.Errorf("%w: %w", ErrSshSession, err)
 	}
 	defer func(session *ssh.Session) {
 		closeErr := session.Close()
@@ -456,18 +611,33 @@ func execWithSshClient(ctx context.Context, cmd *Command, client *ssh.Client) er
 	session.Stdout = cmd.Stdout
 	session.Stderr = cmd.Stderr
 
+	if cmd.Pty != nil {
+		unsetResize, err := requestSshPty(session, cmd)
+		if err != nil {
+			logger.Warn("failed to request pty on SSH session", "err", err)
+			return err
+		}
+		defer unsetResize()
+	}
+
 	cmdStr := cmd.ShellString()
 
-	logger.Debug("executing command on SSH session", "cmd", cmdStr, "session", fmt.Sprintf("%p", session))
+	logger.Debug("executing command on SSH session", "cmd", cmd.RedactedShellString(), "session", fmt.Sprintf("%p", session))
 
-	err = runSshSession(ctx, session, cmdStr)
+	err = runSshSession(ctx, baseLogger, tracer, session, cmdStr, started)
 	return err
 }
 
 // runSshSession run the given command on the SSH session.
 // Blocks until the command is finished or the context is done.
-func runSshSession(ctx context.Context, session *ssh.Session, cmdStr string) error {
-	logger := Logger.With("field", "rexec.runSshSession", "cmd", cmdStr, "session", fmt.Sprintf("%p", session))
+//
+// The started callback (if not nil) is called after the command has been
+// started.
+//
+// It is traced as a "rexec.runSshSession" span.
+func runSshSession(ctx context.Context, logger *slog.Logger, tracer Tracer, session *ssh.Session, cmdStr string, started func()) (err error) {
+	// do not log the cmdStr: it may contain secrets.
+	logger = logger.With("field", "rexec.runSshSession", "session", fmt.Sprintf("%p", session))
 
 	if session == nil {
 		return fmt.Errorf("%w: nil session", ErrInternalError)
@@ -476,11 +646,25 @@ func runSshSession(ctx context.Context, session *ssh.Session, cmdStr string) err
 		return fmt.Errorf("%w: empty command", ErrParseCommand)
 	}
 
-	var err error
+	_, span := tracer.Start(ctx, "rexec.runSshSession")
+	defer func() {
+		var exitErr *ssh.ExitError
+		switch {
+		case err == nil:
+			span.SetAttributes(slog.Int(AttrExitStatus, 0))
+		case errors.As(err, &exitErr):
+			span.SetAttributes(slog.Int(AttrExitStatus, exitErr.ExitStatus()))
+		}
+		endSpan(span, err)
+	}()
+
 	if err = session.Start(cmdStr); err != nil {
 		logger.Warn("failed to start command on SSH session", "err", err)
 		return err
 	}
+	if started != nil {
+		started()
+	}
 
 	done := make(chan error)
 	go func() {
@@ -506,5 +690,7 @@ var (
 	ErrInvalidCommand = errors.New("invalid command")
 	ErrStartedCommand = errors.New("command has already been executed")
 	ErrBadSshConfig   = errors.New("bad SSH client configuration")
+	ErrSshDial        = errors.New("failed to dial SSH")
+	ErrSshSession     = errors.New("failed to open SSH session")
 	ErrInternalError  = errors.New("internal error") // should not happen, means a bug of code logic
 )
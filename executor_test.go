@@ -1474,7 +1474,7 @@ func BenchmarkExecutors(b *testing.B) {
 
 	ctx := context.Background()
 
-	cmd := Command{
+	cmd := &Command{
 		Command: "echo hello",
 	}
 
@@ -1492,8 +1492,7 @@ func BenchmarkExecutors(b *testing.B) {
 	for name, executor := range executors {
 		b.Run(name, func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
-				cmdCopy := cmd
-				err := executor.Execute(ctx, &cmdCopy)
+				err := executor.Execute(ctx, cmd.Clone())
 				if err != nil {
 					b.Fatalf("❌ Execute() error = %v", err)
 				}
@@ -1517,8 +1516,7 @@ func BenchmarkExecutors(b *testing.B) {
 			},
 		}}
 		for i := 0; i < b.N; i++ {
-			cmdCopy := cmd
-			err := executor.Execute(ctx, &cmdCopy)
+			err := executor.Execute(ctx, cmd.Clone())
 			if err != nil {
 				b.Fatalf("❌ Execute() error = %v", err)
 			}
@@ -3,6 +3,7 @@ package rexec
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"reflect"
 )
 
@@ -30,7 +31,8 @@ import (
 //	   Shell: &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}},
 //	}.Executor()
 type ExecutorFactory struct {
-	// All exported fields must be an ExecuteCloser.
+	// All exported fields must be an ExecuteCloser,
+	// except for the options listed in factoryOptionFields.
 
 	Local        *LocalExecutor
 	Shell        *ShellExecutor
@@ -41,14 +43,47 @@ type ExecutorFactory struct {
 	//   1. Add a new field here.
 	//   2. Implement the ExecuteCloser interface for the new executor.
 	// (3). Executor() will automatically pick up the new executor since
-	//      it uses reflection (see executorsMap).
+	//      it uses reflection (see executorsMap). It is also registered as
+	//      a kind named after the field (see RegisterExecutorKind).
+	// Executors outside this package are registered as kinds instead.
+
+	// Kind, if not empty, creates an executor of the registered kind (see
+	// RegisterExecutorKind) from the Config, instead of the fields above:
+	//
+	//	{"Kind": "Container", "Config": {"Image": "alpine:3"}}
+	//
+	// The executor fields above are also registered as kinds, e.g.
+	// {"Kind": "Shell", "Config": {"ShellPath": "/bin/sh"}}.
+	Kind string
+	// Config is the configuration of the executor of the Kind: a value of the
+	// config type of the kind (or a pointer to it), or a value that decodes
+	// into it from JSON (e.g. a map from a config file).
+	Config any
+
+	// Middlewares, if any, decorate the created executor (see Chain).
+	// The first middleware is the outermost one.
+	Middlewares []Middleware `json:"-"`
+
+	// Logger, if not nil, is the logger of the factory, and the default
+	// Logger of the created executor and its middlewares (the ones having a
+	// nil Logger field).
+	Logger *slog.Logger `json:"-"`
+}
+
+// factoryOptionFields are the exported fields of ExecutorFactory that are
+// options of the factory instead of executors.
+var factoryOptionFields = map[string]bool{
+	"Kind":        true,
+	"Config":      true,
+	"Middlewares": true,
+	"Logger":      true,
 }
 
 // panic if any exported field in ExecutorFactory is not an ExecuteCloser.
 func init() {
 	v := reflect.ValueOf(ExecutorFactory{})
 	for i := 0; i < v.NumField(); i++ {
-		if !v.Type().Field(i).IsExported() {
+		if !v.Type().Field(i).IsExported() || factoryOptionFields[v.Type().Field(i).Name] {
 			continue
 		}
 
@@ -68,7 +103,7 @@ func init() {
 //
 // executorsMap depends on reflection.
 func (f ExecutorFactory) executorsMap() map[string]ExecuteCloser {
-	logger := Logger.With("field", "rexec.ExecutorFactory.executorsMap")
+	logger := orGlobalLogger(f.Logger).With("field", "rexec.ExecutorFactory.executorsMap")
 
 	//executors := map[string] ExecuteCloser {
 	//	"Local":        f.Local,
@@ -81,6 +116,9 @@ func (f ExecutorFactory) executorsMap() map[string]ExecuteCloser {
 
 	v := reflect.ValueOf(f)
 	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).IsExported() || factoryOptionFields[v.Type().Field(i).Name] {
+			continue
+		}
 		executor, ok := v.Field(i).Interface().(ExecuteCloser)
 		if !ok {
 			logger.Warn("field is not ExecuteCloser. Unexpected, skipping", "field", v.Type().Field(i).Name)
@@ -91,21 +129,31 @@ func (f ExecutorFactory) executorsMap() map[string]ExecuteCloser {
 	return executors
 }
 
-// Executor creates the corresponding Executor.
+// Executor creates the corresponding Executor: the non-nil executor field,
+// or the executor of the Kind.
 //
 // It returns an error if no executor is properly set,
-// or multiple executors are set.
+// or multiple executors are set (including a Kind).
+//
+// If Middlewares are set, the returned executor is decorated with them,
+// and closing it closes the underlying executor.
+//
+// If the Logger is set, it is assigned to the created executor and
+// middlewares that do not have their own Logger.
 func (f ExecutorFactory) Executor() (ExecuteCloser, error) {
-	logger := Logger.With("field", "rexec.ExecutorFactory.Executor")
+	logger := orGlobalLogger(f.Logger).With("field", "rexec.ExecutorFactory.Executor")
 
 	executors := f.executorsMap() // "FieldName": ExecuteCloser(f.Field)
 
 	nonNilExecutors := make([]string, 0, 1)
 
-	for name, executor := range executors {
+	// in a fixed order, so that the first bad configuration reported is
+	// deterministic. See ValidateConfig to report all of them.
+	for _, name := range sortedKeys(executors) {
+		executor := executors[name]
 		logger := logger.With("executorKind", name) // intended shadowing
 
-		err := executor.validate()
+		err := executor.Validate()
 		if errors.Is(err, ErrNilExecutor) {
 			logger.Debug("validate executor: nil. Continue.")
 			continue
@@ -119,28 +167,58 @@ func (f ExecutorFactory) Executor() (ExecuteCloser, error) {
 		nonNilExecutors = append(nonNilExecutors, name)
 	}
 
+	if f.Kind != "" {
+		if len(nonNilExecutors) > 0 {
+			logger.Error("multiple executors are set. Error.", "executors", nonNilExecutors, "kind", f.Kind)
+			return nil, fmt.Errorf("%w: %v and Kind %q", ErrMultipleExecutors, nonNilExecutors, f.Kind)
+		}
+		executor, err := newExecutorOfKind(f.Kind, f.Config)
+		if err != nil {
+			logger.Error("create executor of kind: failed. Abort.", "executorKind", f.Kind, "err", err)
+			return nil, err
+		}
+		return f.finish(logger, f.Kind, executor), nil
+	}
+	if f.Config != nil {
+		logger.Error("config without kind. Error.")
+		return nil, fmt.Errorf("%w: Config is set without Kind", ErrExecutorBadConfig)
+	}
+
 	switch len(nonNilExecutors) {
 	case 0:
 		logger.Error("no executor is properly set. Error.")
 		return nil, fmt.Errorf("%w: all nil", ErrExecutorNotSet)
 	case 1:
 		name := nonNilExecutors[0]
-		executor := executors[name]
-		logger.Info("executor created", "executorKind", name, "executor", executor)
-		return executor, nil
+		return f.finish(logger, name, executors[name]), nil
 	default:
 		logger.Error("multiple executors are set. Error.", "executors", nonNilExecutors)
 		return nil, fmt.Errorf("%w: %v", ErrMultipleExecutors, nonNilExecutors)
 	}
 }
 
+// finish sets the Logger and Middlewares of the factory to the executor
+// created of the kind.
+func (f ExecutorFactory) finish(logger *slog.Logger, kind string, executor ExecuteCloser) ExecuteCloser {
+	logger.Info("executor created", "executorKind", kind, "executor", executor)
+	setDefaultLogger(executor, f.Logger)
+	if len(f.Middlewares) > 0 {
+		return chain(executor, f.Logger, f.Middlewares...)
+	}
+	return executor
+}
+
 // ExecuteCloser is an interface that combines Executor and Closer.
 //
 // ExecutorFactory will create executors that implement this interface.
+// Implement it to register a custom executor kind (see RegisterExecutorKind).
 type ExecuteCloser interface {
 	Executor
 	Close() error
-	validate() error // validate checks if the executors are properly set and ready to use.
+	// Validate checks if the executor is properly set and ready to use.
+	// It returns ErrNilExecutor for a nil executor, and an error wrapping
+	// ErrExecutorBadConfig for a bad configuration.
+	Validate() error
 }
 
 var (
@@ -158,17 +236,17 @@ func (e *ShellExecutor) Close() error { return nil }
 
 func (e *ImmediateSshExecutor) Close() error { return nil }
 
-// impl validate() for each executor.
+// impl Validate() for each executor.
 // notice that the nil check is required. See also ExecutorFactory.Executor().
 
-func (e *LocalExecutor) validate() error {
+func (e *LocalExecutor) Validate() error {
 	if e == nil {
 		return ErrNilExecutor
 	}
 	return nil
 }
 
-func (e *ShellExecutor) validate() error {
+func (e *ShellExecutor) Validate() error {
 	if e == nil {
 		return ErrNilExecutor
 	}
@@ -178,7 +256,7 @@ func (e *ShellExecutor) validate() error {
 	return nil
 }
 
-func (e *ImmediateSshExecutor) validate() error {
+func (e *ImmediateSshExecutor) Validate() error {
 	if e == nil {
 		return ErrNilExecutor
 	}
@@ -193,7 +271,7 @@ func (e *ImmediateSshExecutor) validate() error {
 
 }
 
-func (e *KeepAliveSshExecutor) validate() error {
+func (e *KeepAliveSshExecutor) Validate() error {
 	if e == nil {
 		return ErrNilExecutor
 	}
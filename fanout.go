@@ -0,0 +1,271 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// This file provides FanOut to run the same command on many hosts
+// concurrently.
+
+// DefaultFanOutConcurrency is the number of hosts a FanOut runs on
+// concurrently if its Concurrency is not set.
+const DefaultFanOutConcurrency = 16
+
+// FanOutTarget is a named executor that a FanOut runs the command on.
+type FanOutTarget struct {
+	// Name identifies the target in the results, e.g. the host address.
+	Name string
+	// Executor runs the command on the target.
+	Executor Executor
+	// Vars are the variables to render the command for the target with,
+	// if the FanOut renders templates (see FanOut.Template).
+	Vars map[string]string
+}
+
+// SshTargets returns a FanOutTarget for each config, named by its Addr,
+// with an ImmediateSshExecutor that connects for the command only.
+func SshTargets(configs ...*SshClientConfig) []FanOutTarget {
+	targets := make([]FanOutTarget, 0, len(configs))
+	for _, config := range configs {
+		name := ""
+		if config != nil {
+			name = config.Addr
+		}
+		targets = append(targets, FanOutTarget{
+			Name:     name,
+			Executor: &ImmediateSshExecutor{Config: config},
+		})
+	}
+	return targets
+}
+
+// FanOut runs the same command on multiple targets concurrently,
+// at most Concurrency at a time.
+//
+//	results, err := (&rexec.FanOut{
+//		Targets:     rexec.SshTargets(configs...),
+//		Concurrency: 32,
+//	}).Run(ctx, &rexec.Command{Command: "uptime"})
+//
+// Cancelling the ctx stops the running commands and skips the remaining
+// targets.
+type FanOut struct {
+	// Targets to run the command on.
+	Targets []FanOutTarget
+	// Concurrency is the maximum number of targets running at the same time.
+	// If <= 0, DefaultFanOutConcurrency is used.
+	Concurrency int
+	// FailFast stops the fan-out at the first failed target: the running
+	// commands are canceled and the remaining targets are skipped.
+	// Otherwise, the fan-out continues on errors.
+	FailFast bool
+	// Template renders the command for each target with its Vars before
+	// running it (see Command.Render), e.g. "systemctl restart {{.service}}".
+	// A target fails if its command can not be rendered.
+	Template bool
+
+	// OnResult, if not nil, is called with the result of each target when it
+	// finishes (or is skipped). The calls are serialized.
+	OnResult func(HostResult) `json:"-"`
+
+	// Logger, if not nil, overrides the global Logger for this fan-out.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+}
+
+// HostResult is the result of the command on a target of a FanOut.
+type HostResult struct {
+	// Name of the FanOutTarget.
+	Name string
+	// Target is the TargetInfo of the executor of the target.
+	Target TargetInfo
+
+	Stdout []byte
+	Stderr []byte
+	// Status is the exit status of the command (see Command.Status).
+	// It is -1 if the command was not run to the end (e.g. skipped).
+	Status int
+	// Err is the error returned by the executor, or an ErrFanOutSkipped
+	// error if the target was skipped.
+	Err error
+
+	Start    time.Time
+	Duration time.Duration
+}
+
+// OK reports whether the command succeeded on the target.
+func (r HostResult) OK() bool {
+	return r.Err == nil && r.Status == 0
+}
+
+// Run runs the command tmpl on all the targets.
+//
+// The tmpl is cloned for each target (see Command.Clone), with its Stdin
+// read once and replayed to every target. The outputs are collected into
+// the results instead of the Stdout and Stderr of the tmpl.
+//
+// It returns the results in the order of the Targets, and an error wrapping
+// ErrFanOutFailed if any target failed (or was skipped).
+func (f *FanOut) Run(ctx context.Context, tmpl *Command) ([]HostResult, error) {
+	logger := loggerFor(ctx, f.Logger).With("field", "rexec.FanOut.Run", "cmd", tmpl, "targets", len(f.Targets))
+
+	if tmpl == nil {
+		logger.Warn("reject fan-out: nil command")
+		return nil, ErrNilCommand
+	}
+
+	var stdin []byte
+	if tmpl.Stdin != nil {
+		var err error
+		if stdin, err = io.ReadAll(tmpl.Stdin); err != nil {
+			logger.Warn("reject fan-out: failed to read stdin", "err", err)
+			return nil, fmt.Errorf("%w: failed to read stdin: %w", ErrInvalidCommand, err)
+		}
+	}
+
+	concurrency := f.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultFanOutConcurrency
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	results := make([]HostResult, len(f.Targets))
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex // serializes OnResult
+		sem      = make(chan struct{}, concurrency)
+		finished = func(i int) {
+			if f.OnResult != nil {
+				mu.Lock()
+				f.OnResult(results[i])
+				mu.Unlock()
+			}
+		}
+	)
+
+	logger.Debug("fan-out started", "concurrency", concurrency, "failFast", f.FailFast)
+
+	for i, target := range f.Targets {
+		results[i] = HostResult{Name: target.Name, Status: -1}
+		if target.Executor != nil {
+			results[i].Target = TargetOf(target.Executor)
+		}
+
+		acquired := false
+		select {
+		case sem <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			// canceled or failed fast: skip the target.
+			if acquired {
+				<-sem
+			}
+			results[i].Err = fmt.Errorf("%w: %w", ErrFanOutSkipped, context.Cause(ctx))
+			finished(i)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, target FanOutTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = f.runTarget(ctx, target, tmpl, stdin)
+			if !results[i].OK() {
+				logger.Warn("fan-out target failed", "target", target.Name, "status", results[i].Status, "err", results[i].Err)
+				if f.FailFast {
+					cancel(fmt.Errorf("%w: target %s failed", ErrFanOutAborted, target.Name))
+				}
+			}
+			finished(i)
+		}(i, target)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, r := range results {
+		if !r.OK() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		logger.Warn("fan-out finished with failures", "failed", failed)
+		return results, fmt.Errorf("%w: %d of %d targets", ErrFanOutFailed, failed, len(results))
+	}
+
+	logger.Info("fan-out succeeded")
+	return results, nil
+}
+
+// runTarget runs a clone of the tmpl on the target.
+func (f *FanOut) runTarget(ctx context.Context, target FanOutTarget, tmpl *Command, stdin []byte) HostResult {
+	result := HostResult{Name: target.Name, Status: -1}
+
+	if target.Executor == nil {
+		result.Err = fmt.Errorf("%w: nil executor of target %s", ErrExecutorNotSet, target.Name)
+		return result
+	}
+	result.Target = TargetOf(target.Executor)
+
+	if f.Template {
+		rendered, err := tmpl.Render(target.Vars)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		tmpl = rendered
+	}
+
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	cmd := tmpl.Clone()
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	result.Start = time.Now()
+	result.Err = target.Executor.Execute(ctx, cmd)
+	result.Duration = time.Since(result.Start)
+
+	result.Status = cmd.Status
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+	return result
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use: when the ctx is
+// done, Execute returns while the output of the killed process may still
+// be being copied to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of what has been written so far.
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+// FanOut errors
+var (
+	ErrFanOutFailed  = errors.New("fan-out failed on some targets")
+	ErrFanOutSkipped = errors.New("target skipped")
+	ErrFanOutAborted = errors.New("fan-out aborted")
+)
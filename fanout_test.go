@@ -0,0 +1,189 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOut_Run(t *testing.T) {
+	targets := []FanOutTarget{
+		{Name: "a", Executor: &LocalExecutor{}},
+		{Name: "b", Executor: &ShellExecutor{ShellPath: "sh", ShellArgs: []string{"-c"}}},
+		{Name: "c", Executor: &LocalExecutor{}},
+	}
+	fanOut := &FanOut{Targets: targets, Concurrency: 2}
+
+	results, err := fanOut.Run(context.Background(), &Command{
+		Command: "cat",
+		Stdin:   strings.NewReader("hello"),
+	})
+	if err != nil {
+		t.Fatalf("❌ Run() error = %v", err)
+	}
+	for i, r := range results {
+		switch {
+		case r.Name != targets[i].Name:
+			t.Errorf("❌ results[%d].Name = %q, want %q", i, r.Name, targets[i].Name)
+		case !r.OK() || string(r.Stdout) != "hello":
+			t.Errorf("❌ results[%d] = %+v, want hello", i, r)
+		case r.Duration <= 0 || r.Start.IsZero():
+			t.Errorf("❌ results[%d] has no timing: %+v", i, r)
+		default:
+			t.Logf("✅ %s (%s): %q in %v", r.Name, r.Target, r.Stdout, r.Duration)
+		}
+	}
+}
+
+func TestFanOut_Run_concurrency(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	executor := ExecutorFunc(func(ctx context.Context, cmd *Command) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		cmd.Status = 0
+		return nil
+	})
+
+	targets := make([]FanOutTarget, 20)
+	for i := range targets {
+		targets[i] = FanOutTarget{Name: "t", Executor: executor}
+	}
+
+	var results int
+	fanOut := &FanOut{Targets: targets, Concurrency: 3, OnResult: func(HostResult) { results++ }}
+	if _, err := fanOut.Run(context.Background(), &Command{Command: "true"}); err != nil {
+		t.Fatalf("❌ Run() error = %v", err)
+	}
+
+	switch {
+	case maxRunning.Load() != 3:
+		t.Errorf("❌ max concurrent targets = %d, want 3", maxRunning.Load())
+	case results != len(targets):
+		t.Errorf("❌ OnResult called %d times, want %d", results, len(targets))
+	default:
+		t.Logf("✅ max concurrent targets: %d", maxRunning.Load())
+	}
+}
+
+func TestFanOut_Run_failures(t *testing.T) {
+	var calls atomic.Int32
+	fail := ExecutorFunc(func(ctx context.Context, cmd *Command) error {
+		calls.Add(1)
+		cmd.Status = 1
+		return errors.New("boom")
+	})
+	block := ExecutorFunc(func(ctx context.Context, cmd *Command) error {
+		calls.Add(1)
+		<-ctx.Done()
+		cmd.Status = -1
+		return ctx.Err()
+	})
+
+	t.Run("continueOnError", func(t *testing.T) {
+		calls.Store(0)
+		fanOut := &FanOut{
+			Targets: []FanOutTarget{
+				{Name: "fail", Executor: fail},
+				{Name: "ok", Executor: &LocalExecutor{}},
+			},
+			Concurrency: 1,
+		}
+		results, err := fanOut.Run(context.Background(), &Command{Command: "true"})
+		switch {
+		case !errors.Is(err, ErrFanOutFailed):
+			t.Errorf("❌ Run() error = %v, want ErrFanOutFailed", err)
+		case results[0].OK() || !results[1].OK():
+			t.Errorf("❌ results = %+v", results)
+		default:
+			t.Logf("✅ continued on error: %v", err)
+		}
+	})
+
+	t.Run("failFast", func(t *testing.T) {
+		calls.Store(0)
+		fanOut := &FanOut{
+			Targets: []FanOutTarget{
+				{Name: "block", Executor: block},
+				{Name: "fail", Executor: fail},
+				{Name: "skipped1", Executor: fail},
+				{Name: "skipped2", Executor: fail},
+			},
+			Concurrency: 2,
+			FailFast:    true,
+		}
+		results, err := fanOut.Run(context.Background(), &Command{Command: "true"})
+		switch {
+		case !errors.Is(err, ErrFanOutFailed):
+			t.Errorf("❌ Run() error = %v, want ErrFanOutFailed", err)
+		case !errors.Is(results[0].Err, context.Canceled):
+			t.Errorf("❌ running target not canceled: %v", results[0].Err)
+		case !errors.Is(results[3].Err, ErrFanOutSkipped) || !errors.Is(results[3].Err, ErrFanOutAborted):
+			t.Errorf("❌ remaining target not skipped: %v", results[3].Err)
+		case calls.Load() > 3:
+			t.Errorf("❌ %d targets executed after failing fast", calls.Load())
+		default:
+			t.Logf("✅ failed fast: %v", results[3].Err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var once sync.Once
+		first := ExecutorFunc(func(ctx context.Context, cmd *Command) error {
+			once.Do(cancel)
+			return block(ctx, cmd)
+		})
+		fanOut := &FanOut{
+			Targets: []FanOutTarget{
+				{Name: "first", Executor: first},
+				{Name: "second", Executor: &LocalExecutor{}},
+			},
+			Concurrency: 1,
+		}
+		results, err := fanOut.Run(ctx, &Command{Command: "true"})
+		switch {
+		case !errors.Is(err, ErrFanOutFailed):
+			t.Errorf("❌ Run() error = %v, want ErrFanOutFailed", err)
+		case !errors.Is(results[1].Err, ErrFanOutSkipped) || !errors.Is(results[1].Err, context.Canceled):
+			t.Errorf("❌ remaining target not skipped: %v", results[1].Err)
+		default:
+			t.Logf("✅ canceled: %v", results[1].Err)
+		}
+	})
+}
+
+func TestSshTargets(t *testing.T) {
+	config := func() *SshClientConfig {
+		return &SshClientConfig{
+			Addr:           "localhost:24622",
+			User:           "root",
+			Auth:           []SshAuth{{Password: "root"}},
+			TimeoutSeconds: 5,
+			HostKeyCheck:   ignoreHostKeyCheck,
+		}
+	}
+	fanOut := &FanOut{Targets: SshTargets(config(), config())}
+
+	results, err := fanOut.Run(context.Background(), &Command{Command: "echo hello"})
+	if err != nil {
+		t.Fatalf("❌ Run() error = %v", err)
+	}
+	for _, r := range results {
+		if r.Name != "localhost:24622" || r.Target.Kind != "ImmediateSsh" || string(r.Stdout) != "hello\n" {
+			t.Errorf("❌ result = %+v", r)
+		} else {
+			t.Logf("✅ %s: %q", r.Name, r.Stdout)
+		}
+	}
+}
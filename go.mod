@@ -3,8 +3,10 @@ module github.com/cdfmlr/rexec/v2
 go 1.22.2
 
 require (
+	github.com/creack/pty v1.1.24
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	golang.org/x/crypto v0.31.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/sys v0.28.0 // indirect
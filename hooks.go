@@ -0,0 +1,171 @@
+package rexec
+
+import (
+	"context"
+	"io"
+)
+
+// This file provides lifecycle hooks to run side effects around executions,
+// e.g. updating a job table when a command starts, pushing the output to a
+// websocket, or recording the exit.
+
+// Hooks are callbacks at the points of an execution (see Executor):
+//
+//	OnValidated -> OnStart -> OnOutput... -> OnExit
+//
+// Every field is optional. The hooks receive the ctx of the execution, the
+// Command and the TargetInfo of the executor running it.
+//
+// Hooks are called synchronously by the executor, so they should be fast and
+// must not block. They must not modify the Command, except as documented.
+type Hooks struct {
+	// OnValidated is called after the command has been validated,
+	// before it is prepared to run.
+	OnValidated func(ctx context.Context, cmd *Command, target TargetInfo)
+
+	// OnStart is called after the command (process or SSH session)
+	// has been started.
+	OnStart func(ctx context.Context, cmd *Command, target TargetInfo)
+
+	// OnOutput is called with each chunk written by the command to its
+	// stdout or stderr, after it has been written to cmd.Stdout or
+	// cmd.Stderr. It may be called concurrently for stdout and stderr.
+	// The chunk must not be retained after OnOutput returns.
+	OnOutput func(ctx context.Context, cmd *Command, target TargetInfo, stream OutputStream, chunk []byte)
+
+	// OnExit is called when the execution finishes, with the final
+	// cmd.Status and the error returned by Execute. It is called for every
+	// execution that has reached the validation, even if the command is
+	// invalid or fails to start.
+	OnExit func(ctx context.Context, cmd *Command, target TargetInfo, err error)
+}
+
+// OutputStream identifies the output stream of a command.
+type OutputStream string
+
+const (
+	StreamStdout OutputStream = "stdout"
+	StreamStderr OutputStream = "stderr"
+)
+
+// context-carried hooks
+
+type hooksCtxKey struct{}
+
+// WithHooks returns a copy of ctx that carries the hooks, in addition to the
+// ones already in ctx. Executions with the returned context call them after
+// the Hooks of the executor (if any).
+func WithHooks(ctx context.Context, hooks *Hooks) context.Context {
+	prev, _ := ctx.Value(hooksCtxKey{}).([]*Hooks)
+	all := make([]*Hooks, 0, len(prev)+1)
+	all = append(all, prev...)
+	all = append(all, hooks)
+	return context.WithValue(ctx, hooksCtxKey{}, all)
+}
+
+// hookSet is the hooks to call for an execution: the ones of the executor,
+// and then the ones in the ctx.
+//
+// The methods of a nil *hookSet are no-ops.
+type hookSet struct {
+	ctx    context.Context
+	cmd    *Command
+	target TargetInfo
+	hooks  []*Hooks
+}
+
+// hooksFor returns the hookSet of the execution of cmd by an executor with
+// the hooks and target, or nil if there are no hooks.
+func hooksFor(ctx context.Context, hooks *Hooks, cmd *Command, target TargetInfo) *hookSet {
+	var all []*Hooks
+	if hooks != nil {
+		all = append(all, hooks)
+	}
+	if ctxHooks, ok := ctx.Value(hooksCtxKey{}).([]*Hooks); ok {
+		for _, h := range ctxHooks {
+			if h != nil {
+				all = append(all, h)
+			}
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	return &hookSet{ctx: ctx, cmd: cmd, target: target, hooks: all}
+}
+
+func (s *hookSet) validated() {
+	if s == nil {
+		return
+	}
+	for _, h := range s.hooks {
+		if h.OnValidated != nil {
+			h.OnValidated(s.ctx, s.cmd, s.target)
+		}
+	}
+}
+
+func (s *hookSet) started() {
+	if s == nil {
+		return
+	}
+	for _, h := range s.hooks {
+		if h.OnStart != nil {
+			h.OnStart(s.ctx, s.cmd, s.target)
+		}
+	}
+}
+
+func (s *hookSet) exited(err error) {
+	if s == nil {
+		return
+	}
+	for _, h := range s.hooks {
+		if h.OnExit != nil {
+			h.OnExit(s.ctx, s.cmd, s.target, err)
+		}
+	}
+}
+
+// wrapOutput makes the cmd.Stdout and cmd.Stderr call the OnOutput hooks,
+// if any. The returned function restores them.
+// The cmd must have been validated (with the default stdio set).
+func (s *hookSet) wrapOutput() (restore func()) {
+	if s == nil || !s.hasOnOutput() {
+		return func() {}
+	}
+	stdout, stderr := s.cmd.Stdout, s.cmd.Stderr
+	s.cmd.Stdout = &hookWriter{w: stdout, stream: StreamStdout, hooks: s}
+	s.cmd.Stderr = &hookWriter{w: stderr, stream: StreamStderr, hooks: s}
+	return func() {
+		s.cmd.Stdout, s.cmd.Stderr = stdout, stderr
+	}
+}
+
+func (s *hookSet) hasOnOutput() bool {
+	for _, h := range s.hooks {
+		if h.OnOutput != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hookWriter calls the OnOutput hooks with the chunks written through it.
+type hookWriter struct {
+	w      io.Writer
+	stream OutputStream
+	hooks  *hookSet
+}
+
+func (w *hookWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		for _, h := range w.hooks.hooks {
+			if h.OnOutput != nil {
+				h.OnOutput(w.hooks.ctx, w.hooks.cmd, w.hooks.target, w.stream, p[:n])
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,150 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// hookRecorder records the calls of its Hooks.
+type hookRecorder struct {
+	mu     sync.Mutex
+	events []string
+	output map[OutputStream]*bytes.Buffer
+}
+
+func (r *hookRecorder) hooks(name string) *Hooks {
+	r.output = map[OutputStream]*bytes.Buffer{StreamStdout: {}, StreamStderr: {}}
+	record := func(event string) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, name+"."+event)
+	}
+	return &Hooks{
+		OnValidated: func(ctx context.Context, cmd *Command, target TargetInfo) {
+			record("validated:" + target.Kind)
+		},
+		OnStart: func(ctx context.Context, cmd *Command, target TargetInfo) {
+			record("started")
+		},
+		OnOutput: func(ctx context.Context, cmd *Command, target TargetInfo, stream OutputStream, chunk []byte) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.output[stream].Write(chunk)
+		},
+		OnExit: func(ctx context.Context, cmd *Command, target TargetInfo, err error) {
+			record(fmt.Sprintf("exited:%d:%v", cmd.Status, err != nil))
+		},
+	}
+}
+
+func TestHooks(t *testing.T) {
+	sshConfig := &SshClientConfig{
+		Addr:           "localhost:24622",
+		User:           "root",
+		Auth:           []SshAuth{{Password: "root"}},
+		TimeoutSeconds: 5,
+		HostKeyCheck:   ignoreHostKeyCheck,
+	}
+
+	tests := []struct {
+		name     string
+		executor func(hooks *Hooks) ExecuteCloser
+		command  string
+		want     []string
+		stdout   string
+	}{
+		{
+			name:     "local",
+			executor: func(hooks *Hooks) ExecuteCloser { return &LocalExecutor{Hooks: hooks} },
+			command:  "echo hello",
+			want:     []string{"e.validated:Local", "e.started", "e.exited:0:false"},
+			stdout:   "hello\n",
+		},
+		{
+			name:     "localInvalid",
+			executor: func(hooks *Hooks) ExecuteCloser { return &LocalExecutor{Hooks: hooks} },
+			command:  "",
+			want:     []string{"e.exited:-1:true"},
+		},
+		{
+			name:     "localNotFound",
+			executor: func(hooks *Hooks) ExecuteCloser { return &LocalExecutor{Hooks: hooks} },
+			command:  "/not/found/program",
+			want:     []string{"e.validated:Local", "e.exited:-1:true"},
+		},
+		{
+			name:     "shell",
+			executor: func(hooks *Hooks) ExecuteCloser { return &ShellExecutor{ShellPath: "sh", ShellArgs: []string{"-c"}, Hooks: hooks} },
+			command:  "echo hello; exit 2",
+			want:     []string{"e.validated:Shell", "e.started", "e.exited:2:true"},
+			stdout:   "hello\n",
+		},
+		{
+			name:     "immediateSsh",
+			executor: func(hooks *Hooks) ExecuteCloser { return &ImmediateSshExecutor{Config: sshConfig, Hooks: hooks} },
+			command:  "echo hello",
+			want:     []string{"e.validated:ImmediateSsh", "e.started", "e.exited:0:false"},
+			stdout:   "hello\n",
+		},
+		{
+			name:     "keepAliveSsh",
+			executor: func(hooks *Hooks) ExecuteCloser { return &KeepAliveSshExecutor{Config: sshConfig, Hooks: hooks} },
+			command:  "echo hello",
+			want:     []string{"e.validated:KeepAliveSsh", "e.started", "e.exited:0:false"},
+			stdout:   "hello\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &hookRecorder{}
+			executor := tt.executor(r.hooks("e"))
+			defer executor.Close()
+
+			stdout := &bytes.Buffer{}
+			_ = executor.Execute(context.Background(), &Command{Command: tt.command, Stdout: stdout})
+
+			got := strings.Join(r.events, " ")
+			want := strings.Join(tt.want, " ")
+			switch {
+			case got != want:
+				t.Errorf("❌ hook events = %q, want %q", got, want)
+			case r.output[StreamStdout].String() != tt.stdout:
+				t.Errorf("❌ OnOutput stdout = %q, want %q", r.output[StreamStdout].String(), tt.stdout)
+			case stdout.String() != tt.stdout:
+				t.Errorf("❌ cmd stdout = %q, want %q", stdout.String(), tt.stdout)
+			default:
+				t.Logf("✅ hook events: %s", got)
+			}
+		})
+	}
+}
+
+func TestWithHooks(t *testing.T) {
+	r := &hookRecorder{}
+	executorHooks := r.hooks("executor")
+	ctxHooks := r.hooks("ctx")
+
+	stdout := &bytes.Buffer{}
+	cmd := &Command{Command: "echo hello", Stdout: stdout}
+
+	ctx := WithHooks(context.Background(), ctxHooks)
+	executor := &LocalExecutor{Hooks: executorHooks}
+	if err := executor.Execute(ctx, cmd); err != nil {
+		t.Fatalf("❌ Execute() error = %v", err)
+	}
+
+	want := "executor.validated:Local ctx.validated:Local executor.started ctx.started executor.exited:0:false ctx.exited:0:false"
+	if got := strings.Join(r.events, " "); got != want {
+		t.Errorf("❌ hook events = %q, want %q", got, want)
+	}
+	if cmd.Stdout != stdout {
+		t.Errorf("❌ cmd.Stdout is not restored after execution")
+	}
+	if !t.Failed() {
+		t.Logf("✅ executor hooks are called before ctx hooks")
+	}
+}
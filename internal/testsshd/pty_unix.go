@@ -0,0 +1,17 @@
+//go:build !windows
+
+package testsshd
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setCtty makes the cmd run in a new session, with its stdin (the tty) as
+// the controlling terminal.
+func setCtty(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid, cmd.SysProcAttr.Setctty = true, true
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package testsshd
+
+import "os/exec"
+
+// setCtty does nothing: there are no pseudo-terminals on windows (pty.Open
+// fails, and "pty-req" is rejected).
+func setCtty(cmd *exec.Cmd) {}
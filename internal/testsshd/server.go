@@ -7,17 +7,21 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
 
+	"github.com/creack/pty"
 	"golang.org/x/crypto/ssh"
 )
 
 // Server is a simple SSH server for testing purposes.
 //
 // It supports password and public key authentication.
-// Executed commands are run locally on the host machine.
+// Executed commands are run locally on the host machine, in a
+// pseudo-terminal if requested ("pty-req", resized by "window-change").
 //
 // The zero value or literal is not usable. Use New to create a decent server.
 type Server struct {
@@ -224,36 +228,167 @@ func stderrModifier(p []byte) []byte {
 	return p
 }
 
+// session is a "session" channel, running one command.
+type session struct {
+	ch ssh.Channel
+
+	// the pseudo-terminal requested by "pty-req", if any.
+	// The mutex guards its resizing (by window-change) against its closing
+	// (when the command exits).
+	ptyMu     sync.Mutex
+	ptmx, tty *os.File
+	term      string
+
+	started bool
+}
+
 func handleSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
-	defer ch.Close()
+	s := &session{ch: ch}
+	defer func() {
+		if !s.started { // or the command closes them when it exits
+			s.closePty()
+			ch.Close()
+		}
+	}()
+
 	for req := range reqs {
-		if req.Type == "exec" {
+		switch req.Type {
+		case "pty-req":
+			req.Reply(!s.started && s.openPty(req.Payload), nil)
+		case "window-change":
+			s.resizePty(req.Payload)
+		case "exec":
+			if s.started {
+				req.Reply(false, nil)
+				continue
+			}
 			var payload struct{ Cmd string }
 			ssh.Unmarshal(req.Payload, &payload)
 			req.Reply(true, nil)
-			cmd := exec.Command("sh", "-c", payload.Cmd)
+			s.started = true
+			// keep handling the requests (e.g. window-change) while the
+			// command runs: the channel is closed when it exits.
+			go s.exec(payload.Cmd)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
 
-			cmd.Stdin = ch
-			cmd.Stdout = ch
-			cmd.Stderr = &hijackWriter{ // hijack and tweak stderr output
-				underlying: ch.Stderr(),
-				mapper:     stderrModifier,
-			}
+// openPty opens the pseudo-terminal of a "pty-req" (RFC 4254, 6.2).
+func (s *session) openPty(payload []byte) bool {
+	var req struct {
+		Term          string
+		Columns, Rows uint32
+		Width, Height uint32
+		Modes         string // ignored: the terminal has the default modes
+	}
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return false
+	}
+	s.closePty()
 
-			status := struct{ Status uint32 }{Status: 0}
-			if err := cmd.Run(); err != nil {
-				if exitErr, ok := err.(*exec.ExitError); ok {
-					status.Status = uint32(exitErr.ExitCode())
-				} else {
-					status.Status = 1
-				}
-			}
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		slog.Warn("failed to open pty", "err", err)
+		return false
+	}
+	s.ptyMu.Lock()
+	s.ptmx, s.tty, s.term = ptmx, tty, req.Term
+	s.ptyMu.Unlock()
+	s.setPtySize(req.Columns, req.Rows)
+	return true
+}
 
-			ch.SendRequest("exit-status", false, ssh.Marshal(status))
-			return
+// resizePty handles a "window-change" (RFC 4254, 6.7).
+func (s *session) resizePty(payload []byte) {
+	var req struct {
+		Columns, Rows uint32
+		Width, Height uint32
+	}
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return
+	}
+	s.setPtySize(req.Columns, req.Rows)
+}
+
+func (s *session) setPtySize(cols, rows uint32) {
+	s.ptyMu.Lock()
+	defer s.ptyMu.Unlock()
+	if s.ptmx == nil {
+		return
+	}
+	if err := pty.Setsize(s.ptmx, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)}); err != nil {
+		slog.Warn("failed to resize pty", "err", err)
+	}
+}
+
+func (s *session) closePty() {
+	s.ptyMu.Lock()
+	defer s.ptyMu.Unlock()
+	if s.ptmx != nil {
+		s.tty.Close()
+		s.ptmx.Close()
+		s.ptmx, s.tty = nil, nil
+	}
+}
+
+// exec runs the command, on the pseudo-terminal if any, and closes the
+// channel with its exit status.
+func (s *session) exec(command string) {
+	defer s.ch.Close()
+
+	cmd := exec.Command("sh", "-c", command)
+
+	var err error
+	if s.ptmx != nil {
+		err = s.runOnPty(cmd)
+	} else {
+		cmd.Stdin = s.ch
+		cmd.Stdout = s.ch
+		cmd.Stderr = &hijackWriter{ // hijack and tweak stderr output
+			underlying: s.ch.Stderr(),
+			mapper:     stderrModifier,
+		}
+		err = cmd.Run()
+	}
+
+	status := struct{ Status uint32 }{Status: 0}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status.Status = uint32(exitErr.ExitCode())
+		} else {
+			status.Status = 1
 		}
-		req.Reply(false, nil)
 	}
+
+	s.ch.SendRequest("exit-status", false, ssh.Marshal(status))
+}
+
+// runOnPty runs the cmd with the pseudo-terminal as its controlling
+// terminal and stdio, copying the channel to and from it.
+func (s *session) runOnPty(cmd *exec.Cmd) error {
+	defer s.closePty()
+	ptmx, tty := s.ptmx, s.tty
+
+	cmd.Env = append(os.Environ(), "TERM="+s.term)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	setCtty(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	tty.Close()
+
+	go io.Copy(ptmx, s.ch)
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		io.Copy(s.ch, ptmx) // until the terminal is closed by the command
+	}()
+
+	err := cmd.Wait()
+	<-copied
+	return err
 }
 
 // GenerateHostKey generate an ephemeral RSA key for the SSH server host key
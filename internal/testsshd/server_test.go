@@ -368,6 +368,53 @@ func TestServer_bothPasswordAndKey(t *testing.T) {
 	t.Logf("✅ Connected with password")
 }
 
+func TestServer_pty(t *testing.T) {
+	srv, err := New(nil)
+	if err != nil {
+		t.Fatalf("failed to create test server: %v", err)
+	}
+	defer srv.Close()
+
+	client, err := ssh.Dial("tcp", srv.Addr(), &ssh.ClientConfig{
+		User: "testuser",
+		Auth: []ssh.AuthMethod{
+			ssh.Password("test"),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	if err := session.RequestPty("vt100", 30, 100, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("failed to request pty: %v", err)
+	}
+	// resized before the command starts: the window-change is handled in
+	// order with the exec.
+	if err := session.WindowChange(40, 120); err != nil {
+		t.Fatalf("failed to change window: %v", err)
+	}
+
+	output, err := session.Output("tty -s && stty size && echo $TERM")
+	if err != nil {
+		t.Fatalf("failed to execute command: %v", err)
+	}
+
+	expected := "40 120\r\nvt100\r\n"
+	if string(output) != expected {
+		t.Errorf("expected output %q, got %q", expected, string(output))
+	}
+
+	t.Logf("✅ Command executed on a pty: %q", string(output))
+}
+
 func Test_hijackWriter_Write(t *testing.T) {
 	t.Run("nilMapper", func(t *testing.T) {
 		var buf bytes.Buffer
@@ -0,0 +1,547 @@
+package rexec
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// This file provides an inventory of hosts: hosts and (nested) groups of
+// them, with the settings and variables they inherit, loaded from JSON or
+// YAML, and selected by patterns like "web:&prod" to executors.
+
+// Inventory is a set of hosts and groups of hosts.
+//
+// A host inherits the settings (User, Auth, ...) and Vars of the Defaults,
+// then of its groups (parent groups before their children, groups at the
+// same depth by name), then its own, with the later ones taking precedence.
+// A setting is inherited if it is not set (zero); the Vars are merged key
+// by key.
+//
+// Inventory is designed to be loaded from JSON or YAML (see ParseInventory):
+//
+//	Defaults:
+//	  User: deploy
+//	  Auth: [{PrivateKeyPath: /home/deploy/.ssh/id_ed25519}]
+//	  HostKeyCheck: {KnownHostsPath: [/home/deploy/.ssh/known_hosts]}
+//	Hosts:
+//	  web1: {Addr: "10.0.0.1:22", Labels: {env: prod}}
+//	  web2: {Addr: "10.0.0.2", Labels: {env: staging}}
+//	  db1:  {Addr: "10.0.1.1", User: postgres}
+//	Groups:
+//	  web: {Hosts: [web1, web2], Vars: {port: "8080"}}
+//	  db:  {Hosts: [db1]}
+//	  prod: {Hosts: [web1, db1], Executor: KeepAliveSsh}
+//	  backend: {Children: [web, db]}
+//
+// The paths (PrivateKeyPath, KnownHostsPath) are used as is: "~" is not
+// expanded. The group "all" implicitly contains all the hosts.
+type Inventory struct {
+	// Defaults are the settings inherited by all the hosts.
+	Defaults InventorySettings
+	// Hosts by name.
+	Hosts map[string]InventoryHost
+	// Groups by name.
+	Groups map[string]InventoryGroup
+}
+
+// InventorySettings are the settings of a host, that it may inherit from
+// its groups and the Defaults of the Inventory.
+type InventorySettings struct {
+	// User to connect as.
+	User string
+	// Auth methods to authenticate with.
+	Auth []SshAuth
+	// TimeoutSeconds is the timeout of the TCP connection (see SshClientConfig).
+	TimeoutSeconds int
+	// KeepAlive configures the connection of KeepAliveSsh executors.
+	KeepAlive *SshKeepAliveConfig
+	// HostKeyCheck configures the host key checking (see SshClientConfig).
+	HostKeyCheck *SshHostKeyCheckConfig
+	// Executor is the kind of the executor of the host: "ImmediateSsh"
+	// (by default), "KeepAliveSsh" or "Local" (the field names of
+	// ExecutorFactory).
+	Executor string
+
+	// Vars are free-form variables of the host, e.g. for command templates.
+	Vars map[string]string
+}
+
+// InventoryHost is a host in the Inventory.
+type InventoryHost struct {
+	InventorySettings
+
+	// Addr is the "host:port" to connect to. If empty, the name of the host
+	// is used. If the port is omitted, it is 22.
+	Addr string
+	// Labels are key-value pairs to select the host, e.g. {"env": "prod"}.
+	Labels map[string]string
+}
+
+// InventoryGroup is a group of hosts in the Inventory.
+type InventoryGroup struct {
+	InventorySettings
+
+	// Hosts are the names of the hosts in the group.
+	Hosts []string
+	// Children are the names of the groups nested in the group: their hosts
+	// are in the group too.
+	Children []string
+}
+
+// InventoryAll is the name of the implicit group of all the hosts.
+const InventoryAll = "all"
+
+// ParseInventory decodes an Inventory from JSON or YAML, and validates it.
+func ParseInventory(data []byte) (*Inventory, error) {
+	inv := new(Inventory)
+	if err := decodeConfig(data, inv); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadInventory, err)
+	}
+	if err := inv.Validate(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// LoadInventoryFile reads and parses a JSON or YAML inventory file.
+func LoadInventoryFile(name string) (*Inventory, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadInventory, err)
+	}
+	return ParseInventory(data)
+}
+
+// inventoryNameReserved are the characters with meanings in selection
+// patterns, which are not allowed in the names of hosts and groups.
+const inventoryNameReserved = ":,&!="
+
+// Validate checks that the names are well-formed, the groups refer to
+// existing hosts and groups without cycles, and the executor kinds are known.
+func (inv *Inventory) Validate() error {
+	if inv == nil {
+		return fmt.Errorf("%w: nil inventory", ErrBadInventory)
+	}
+	if err := inv.Defaults.validate(); err != nil {
+		return fmt.Errorf("%w: Defaults: %w", ErrBadInventory, err)
+	}
+	for name, host := range inv.Hosts {
+		if err := validateInventoryName(name); err != nil {
+			return fmt.Errorf("%w: Hosts[%q]: %w", ErrBadInventory, name, err)
+		}
+		if err := host.validate(); err != nil {
+			return fmt.Errorf("%w: Hosts[%q]: %w", ErrBadInventory, name, err)
+		}
+	}
+	for name, group := range inv.Groups {
+		if err := validateInventoryName(name); err != nil {
+			return fmt.Errorf("%w: Groups[%q]: %w", ErrBadInventory, name, err)
+		}
+		if name == InventoryAll {
+			return fmt.Errorf("%w: Groups[%q]: reserved group name", ErrBadInventory, name)
+		}
+		if err := group.validate(); err != nil {
+			return fmt.Errorf("%w: Groups[%q]: %w", ErrBadInventory, name, err)
+		}
+		for _, host := range group.Hosts {
+			if _, ok := inv.Hosts[host]; !ok {
+				return fmt.Errorf("%w: Groups[%q]: unknown host %q", ErrBadInventory, name, host)
+			}
+		}
+		for _, child := range group.Children {
+			if _, ok := inv.Groups[child]; !ok {
+				return fmt.Errorf("%w: Groups[%q]: unknown child group %q", ErrBadInventory, name, child)
+			}
+		}
+	}
+	for _, name := range sortedKeys(inv.Groups) {
+		if cycle := inv.findCycle(name, nil); cycle != nil {
+			return fmt.Errorf("%w: group cycle: %s", ErrBadInventory, strings.Join(cycle, " -> "))
+		}
+	}
+	return nil
+}
+
+func validateInventoryName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if strings.ContainsAny(name, inventoryNameReserved) {
+		return fmt.Errorf("name contains any of %q", inventoryNameReserved)
+	}
+	return nil
+}
+
+func (s InventorySettings) validate() error {
+	switch s.Executor {
+	case "", "ImmediateSsh", "KeepAliveSsh", "Local":
+		return nil
+	default:
+		return fmt.Errorf("unknown executor %q", s.Executor)
+	}
+}
+
+// findCycle returns the path of a cycle of children from the group, if any.
+func (inv *Inventory) findCycle(group string, visiting []string) []string {
+	for i, g := range visiting {
+		if g == group {
+			return append(visiting[i:], group)
+		}
+	}
+	visiting = append(visiting, group)
+	for _, child := range inv.Groups[group].Children {
+		if cycle := inv.findCycle(child, visiting); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// groupHosts returns the set of the hosts in the group and its children.
+func (inv *Inventory) groupHosts(group string) map[string]bool {
+	hosts := make(map[string]bool)
+	if group == InventoryAll {
+		for name := range inv.Hosts {
+			hosts[name] = true
+		}
+		return hosts
+	}
+
+	var walk func(group string)
+	walk = func(group string) {
+		g := inv.Groups[group]
+		for _, h := range g.Hosts {
+			hosts[h] = true
+		}
+		for _, child := range g.Children {
+			walk(child)
+		}
+	}
+	walk(group)
+	return hosts
+}
+
+// groupsOf returns the groups the host is in (directly or through the
+// children of the groups), parents before children, by name at the same
+// depth.
+func (inv *Inventory) groupsOf(host string) []string {
+	depths := make(map[string]int) // group -> depth from the roots
+	var depth func(group string) int
+	depth = func(group string) int {
+		if d, ok := depths[group]; ok {
+			return d
+		}
+		d := 0
+		for name, g := range inv.Groups {
+			for _, child := range g.Children {
+				if child == group {
+					d = max(d, depth(name)+1)
+				}
+			}
+		}
+		depths[group] = d
+		return d
+	}
+
+	var groups []string
+	for name := range inv.Groups {
+		if inv.groupHosts(name)[host] {
+			groups = append(groups, name)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		di, dj := depth(groups[i]), depth(groups[j])
+		if di != dj {
+			return di < dj
+		}
+		return groups[i] < groups[j]
+	})
+	return groups
+}
+
+// InventoryTarget is a host of an Inventory resolved with the settings it
+// inherits.
+type InventoryTarget struct {
+	// Name of the host.
+	Name string
+	// Groups the host is in, in the order of inheritance.
+	Groups []string
+	// Labels of the host.
+	Labels map[string]string
+	// Vars of the host, merged with the inherited ones.
+	Vars map[string]string
+
+	// Executor is the kind of the executor of the host.
+	Executor string
+	// Config is the SSH client config of the host.
+	Config *SshClientConfig
+}
+
+// Resolve returns the host with the settings it inherits.
+func (inv *Inventory) Resolve(name string) (*InventoryTarget, error) {
+	host, ok := inv.Hosts[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownHost, name)
+	}
+
+	groups := inv.groupsOf(name)
+	settings := InventorySettings{}
+	settings.merge(inv.Defaults)
+	for _, g := range groups {
+		settings.merge(inv.Groups[g].InventorySettings)
+	}
+	settings.merge(host.InventorySettings)
+
+	if settings.Executor == "" {
+		settings.Executor = "ImmediateSsh"
+	}
+
+	addr := host.Addr
+	if addr == "" {
+		addr = name
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	config := &SshClientConfig{
+		Addr:           addr,
+		User:           settings.User,
+		Auth:           settings.Auth,
+		TimeoutSeconds: settings.TimeoutSeconds,
+		HostKeyCheck:   settings.HostKeyCheck,
+	}
+	if settings.KeepAlive != nil {
+		config.KeepAlive = *settings.KeepAlive
+	}
+
+	return &InventoryTarget{
+		Name:     name,
+		Groups:   groups,
+		Labels:   host.Labels,
+		Vars:     settings.Vars,
+		Executor: settings.Executor,
+		Config:   config,
+	}, nil
+}
+
+// merge overrides the settings with the set ones of o, and merges the Vars.
+func (s *InventorySettings) merge(o InventorySettings) {
+	if o.User != "" {
+		s.User = o.User
+	}
+	if len(o.Auth) > 0 {
+		s.Auth = append([]SshAuth(nil), o.Auth...)
+	}
+	if o.TimeoutSeconds != 0 {
+		s.TimeoutSeconds = o.TimeoutSeconds
+	}
+	if o.KeepAlive != nil {
+		keepAlive := *o.KeepAlive
+		s.KeepAlive = &keepAlive
+	}
+	if o.HostKeyCheck != nil {
+		hostKeyCheck := *o.HostKeyCheck
+		s.HostKeyCheck = &hostKeyCheck
+	}
+	if o.Executor != "" {
+		s.Executor = o.Executor
+	}
+	for k, v := range o.Vars {
+		if s.Vars == nil {
+			s.Vars = make(map[string]string)
+		}
+		s.Vars[k] = v
+	}
+}
+
+// Factory returns the ExecutorFactory to create the executor of the target.
+func (t *InventoryTarget) Factory() ExecutorFactory {
+	switch t.Executor {
+	case "KeepAliveSsh":
+		return ExecutorFactory{KeepAliveSsh: &KeepAliveSshExecutor{Config: t.Config}}
+	case "Local":
+		return ExecutorFactory{Local: &LocalExecutor{}}
+	default:
+		return ExecutorFactory{ImmediateSsh: &ImmediateSshExecutor{Config: t.Config}}
+	}
+}
+
+// Select returns the names of the hosts matching the pattern, sorted.
+//
+// A pattern is a list of terms separated by ":" or ",". A term is:
+//
+//   - a group name: the hosts in the group (and its children),
+//     e.g. "web", or "all" for all the hosts;
+//   - a host name, e.g. "web1";
+//   - a glob (path.Match) of group or host names, e.g. "web*" (the
+//     implicit group "all" is only selected by its exact name);
+//   - a label selector "key=value", where the value may be a glob,
+//     e.g. "env=prod".
+//
+// The hosts matching any plain term are selected (all the hosts if there is
+// no plain term), then narrowed to the ones also matching every "&term",
+// then the ones matching any "!term" are excluded:
+//
+//	web:db        // web or db
+//	web:&prod     // web and prod
+//	all:!db1      // all but db1
+//	env=prod:!db  // labeled env=prod, but not in db
+//
+// It returns an error wrapping ErrNoHostSelected if no host matches.
+func (inv *Inventory) Select(pattern string) ([]string, error) {
+	var union, intersect, exclude []string
+	for _, term := range strings.FieldsFunc(pattern, func(r rune) bool { return r == ':' || r == ',' }) {
+		term = strings.TrimSpace(term)
+		switch {
+		case term == "":
+		case strings.HasPrefix(term, "&"):
+			intersect = append(intersect, term[1:])
+		case strings.HasPrefix(term, "!"):
+			exclude = append(exclude, term[1:])
+		default:
+			union = append(union, term)
+		}
+	}
+	if len(union) == 0 {
+		union = []string{InventoryAll}
+	}
+
+	selected := make(map[string]bool)
+	for _, term := range union {
+		hosts, err := inv.match(term)
+		if err != nil {
+			return nil, err
+		}
+		for h := range hosts {
+			selected[h] = true
+		}
+	}
+	for _, term := range intersect {
+		hosts, err := inv.match(term)
+		if err != nil {
+			return nil, err
+		}
+		for h := range selected {
+			if !hosts[h] {
+				delete(selected, h)
+			}
+		}
+	}
+	for _, term := range exclude {
+		hosts, err := inv.match(term)
+		if err != nil {
+			return nil, err
+		}
+		for h := range hosts {
+			delete(selected, h)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNoHostSelected, pattern)
+	}
+	return sortedKeys(selected), nil
+}
+
+// match returns the set of the hosts matching the term of a pattern.
+func (inv *Inventory) match(term string) (map[string]bool, error) {
+	hosts := make(map[string]bool)
+
+	if key, value, ok := strings.Cut(term, "="); ok {
+		if _, err := path.Match(value, ""); err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrBadSelector, term, err)
+		}
+		for name, host := range inv.Hosts {
+			if v, ok := host.Labels[key]; ok {
+				if matched, _ := path.Match(value, v); matched {
+					hosts[name] = true
+				}
+			}
+		}
+		return hosts, nil
+	}
+
+	if _, err := path.Match(term, ""); err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrBadSelector, term, err)
+	}
+	// only the exact name: a glob like "a*" must not select all the hosts.
+	if term == InventoryAll {
+		return inv.groupHosts(InventoryAll), nil
+	}
+	for name := range inv.Groups {
+		if matched, _ := path.Match(term, name); matched {
+			for h := range inv.groupHosts(name) {
+				hosts[h] = true
+			}
+		}
+	}
+	for name := range inv.Hosts {
+		if matched, _ := path.Match(term, name); matched {
+			hosts[name] = true
+		}
+	}
+	return hosts, nil
+}
+
+// Targets returns the FanOutTargets of the hosts matching the pattern (see
+// Select), with their Vars, and the executors created by their factories
+// (see InventoryTarget.Factory) decorated with the middlewares.
+//
+// The executors are ExecuteClosers: close them after use, e.g. the
+// connections of KeepAliveSsh ones.
+func (inv *Inventory) Targets(pattern string, middlewares ...Middleware) ([]FanOutTarget, error) {
+	names, err := inv.Select(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]FanOutTarget, 0, len(names))
+	for _, name := range names {
+		target, err := inv.Resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		factory := target.Factory()
+		factory.Middlewares = middlewares
+		executor, err := factory.Executor()
+		if err != nil {
+			closeTargets(targets)
+			return nil, fmt.Errorf("host %q: %w", name, err)
+		}
+		targets = append(targets, FanOutTarget{Name: name, Executor: executor, Vars: target.Vars})
+	}
+	return targets, nil
+}
+
+// closeTargets closes the executors of the targets that are ExecuteClosers.
+func closeTargets(targets []FanOutTarget) {
+	for _, t := range targets {
+		if c, ok := t.Executor.(ExecuteCloser); ok {
+			_ = c.Close()
+		}
+	}
+}
+
+// sortedKeys returns the keys of the map, sorted.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Inventory errors
+var (
+	ErrBadInventory   = errors.New("bad inventory")
+	ErrUnknownHost    = errors.New("unknown host")
+	ErrBadSelector    = errors.New("bad host selector")
+	ErrNoHostSelected = errors.New("no host selected")
+)
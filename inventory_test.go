@@ -0,0 +1,214 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testInventory = `
+Defaults:
+  User: root
+  Auth: [{Password: root}]
+  TimeoutSeconds: 5
+  HostKeyCheck: {InsecureIgnore: true}
+  Vars: {env: dev, port: "80"}
+Hosts:
+  web1: {Addr: "localhost:24622", Labels: {env: prod}}
+  web2: {Addr: "localhost:24622", Labels: {env: staging}, Vars: {port: "8081"}}
+  db1:  {Addr: "db.example.com", User: postgres, Labels: {env: prod}}
+  local: {Executor: Local}
+Groups:
+  web: {Hosts: [web1, web2], Vars: {port: "8080", role: web}}
+  db:  {Hosts: [db1], TimeoutSeconds: 10}
+  prod: {Hosts: [web1, db1], Executor: KeepAliveSsh, Vars: {env: prod}}
+  backend: {Children: [web, db], Vars: {role: backend, tier: back}}
+`
+
+func TestParseInventory(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"ok", testInventory, ""},
+		{"json", `{"Hosts": {"h1": {}}, "Groups": {"g": {"Hosts": ["h1"]}}}`, ""},
+		{"badFormat", "Hosts: [", "bad config format"},
+		{"unknownHost", "Groups: {g: {Hosts: [nope]}}", `unknown host "nope"`},
+		{"unknownChild", "Groups: {g: {Children: [nope]}}", `unknown child group "nope"`},
+		{"cycle", "Groups: {a: {Children: [b]}, b: {Children: [a]}}", "group cycle: a -> b -> a"},
+		{"reservedName", "Hosts: {'a:b': {}}", "name contains"},
+		{"reservedGroup", "Groups: {all: {}}", "reserved group name"},
+		{"badExecutor", "Hosts: {h: {Executor: Telnet}}", `unknown executor "Telnet"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseInventory([]byte(tt.data))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("❌ ParseInventory() error = %v", err)
+			case tt.wantErr != "" && (!errors.Is(err, ErrBadInventory) || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("❌ ParseInventory() error = %v, want %q", err, tt.wantErr)
+			default:
+				t.Logf("✅ ParseInventory() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestInventory_Select(t *testing.T) {
+	inv, err := ParseInventory([]byte(testInventory))
+	if err != nil {
+		t.Fatalf("❌ ParseInventory() error = %v", err)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+		wantErr error
+	}{
+		{"all", []string{"db1", "local", "web1", "web2"}, nil},
+		{"*", []string{"db1", "local", "web1", "web2"}, nil},
+		{"web", []string{"web1", "web2"}, nil},
+		{"web:db", []string{"db1", "web1", "web2"}, nil},
+		{"web,db", []string{"db1", "web1", "web2"}, nil},
+		{"backend", []string{"db1", "web1", "web2"}, nil},
+		{"web:&prod", []string{"web1"}, nil},
+		{"backend:!web2", []string{"db1", "web1"}, nil},
+		{"!backend", []string{"local"}, nil},
+		{"web*", []string{"web1", "web2"}, nil},
+		{"env=prod", []string{"db1", "web1"}, nil},
+		{"env=*:!db", []string{"web1", "web2"}, nil},
+		{"local", []string{"local"}, nil},
+		{"*l", []string{"local"}, nil}, // not all: the globs match the names only
+		{"a*", nil, ErrNoHostSelected},
+		{"?ll", nil, ErrNoHostSelected},
+		{"web:&db", nil, ErrNoHostSelected},
+		{"nope", nil, ErrNoHostSelected},
+		{"[", nil, ErrBadSelector},
+	}
+	for _, tt := range tests {
+		got, err := inv.Select(tt.pattern)
+		switch {
+		case !errors.Is(err, tt.wantErr):
+			t.Errorf("❌ Select(%q) error = %v, want %v", tt.pattern, err, tt.wantErr)
+		case !reflect.DeepEqual(got, tt.want):
+			t.Errorf("❌ Select(%q) = %v, want %v", tt.pattern, got, tt.want)
+		default:
+			t.Logf("✅ Select(%q) = %v, %v", tt.pattern, got, err)
+		}
+	}
+}
+
+func TestInventory_Resolve(t *testing.T) {
+	inv, err := ParseInventory([]byte(testInventory))
+	if err != nil {
+		t.Fatalf("❌ ParseInventory() error = %v", err)
+	}
+
+	tests := []struct {
+		host     string
+		groups   []string
+		executor string
+		addr     string
+		user     string
+		timeout  int
+		vars     map[string]string
+	}{
+		{
+			host:     "web1",
+			groups:   []string{"backend", "prod", "web"}, // parents first
+			executor: "KeepAliveSsh",
+			addr:     "localhost:24622",
+			user:     "root",
+			timeout:  5,
+			vars:     map[string]string{"env": "prod", "port": "8080", "role": "web", "tier": "back"},
+		},
+		{
+			host:     "web2",
+			groups:   []string{"backend", "web"},
+			executor: "ImmediateSsh",
+			addr:     "localhost:24622",
+			user:     "root",
+			timeout:  5,
+			vars:     map[string]string{"env": "dev", "port": "8081", "role": "web", "tier": "back"},
+		},
+		{
+			host:     "db1",
+			groups:   []string{"backend", "prod", "db"}, // db is a child of backend
+			executor: "KeepAliveSsh",
+			addr:     "db.example.com:22",
+			user:     "postgres",
+			timeout:  10,
+			vars:     map[string]string{"env": "prod", "port": "80", "role": "backend", "tier": "back"},
+		},
+		{
+			host:     "local",
+			executor: "Local",
+			addr:     "local:22",
+			user:     "root",
+			timeout:  5,
+			vars:     map[string]string{"env": "dev", "port": "80"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := inv.Resolve(tt.host)
+		switch {
+		case err != nil:
+			t.Errorf("❌ Resolve(%q) error = %v", tt.host, err)
+		case !reflect.DeepEqual(got.Groups, tt.groups):
+			t.Errorf("❌ Resolve(%q).Groups = %v, want %v", tt.host, got.Groups, tt.groups)
+		case got.Executor != tt.executor:
+			t.Errorf("❌ Resolve(%q).Executor = %v, want %v", tt.host, got.Executor, tt.executor)
+		case got.Config.Addr != tt.addr || got.Config.User != tt.user || got.Config.TimeoutSeconds != tt.timeout:
+			t.Errorf("❌ Resolve(%q).Config = %v", tt.host, got.Config)
+		case got.Config.HostKeyCheck == nil || !got.Config.HostKeyCheck.InsecureIgnore || len(got.Config.Auth) != 1:
+			t.Errorf("❌ Resolve(%q).Config does not inherit the defaults: %v", tt.host, got.Config)
+		case !reflect.DeepEqual(got.Vars, tt.vars):
+			t.Errorf("❌ Resolve(%q).Vars = %v, want %v", tt.host, got.Vars, tt.vars)
+		default:
+			t.Logf("✅ Resolve(%q) = %+v", tt.host, got)
+		}
+	}
+
+	if _, err := inv.Resolve("nope"); !errors.Is(err, ErrUnknownHost) {
+		t.Errorf("❌ Resolve(nope) error = %v, want ErrUnknownHost", err)
+	}
+	if inv.Defaults.Vars["env"] != "dev" || inv.Groups["web"].Vars["env"] != "" {
+		t.Errorf("❌ Resolve() modified the inventory")
+	}
+}
+
+func TestInventory_Targets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "inventory.yaml")
+	if err := os.WriteFile(file, []byte(testInventory), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	inv, err := LoadInventoryFile(file)
+	if err != nil {
+		t.Fatalf("❌ LoadInventoryFile() error = %v", err)
+	}
+
+	targets, err := inv.Targets("web:local")
+	if err != nil {
+		t.Fatalf("❌ Targets() error = %v", err)
+	}
+	defer closeTargets(targets)
+
+	results, err := (&FanOut{Targets: targets}).Run(context.Background(), &Command{Command: "echo hello"})
+	if err != nil {
+		t.Fatalf("❌ FanOut.Run() error = %v", err)
+	}
+	wantKinds := map[string]string{"local": "Local", "web1": "KeepAliveSsh", "web2": "ImmediateSsh"}
+	for _, r := range results {
+		if r.Target.Kind != wantKinds[r.Name] || string(r.Stdout) != "hello\n" {
+			t.Errorf("❌ result = %+v", r)
+		} else {
+			t.Logf("✅ %s (%s): %q", r.Name, r.Target, r.Stdout)
+		}
+	}
+}
@@ -0,0 +1,617 @@
+package rexec
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// This file provides JobManager, to run commands asynchronously as jobs:
+// submit a command, get a job ID, and check its state and outputs later,
+// even after a restart of the process, from the JobStore.
+//
+//	jobs := &rexec.JobManager{Workers: 8, Store: store}
+//	defer jobs.Close()
+//
+//	job, err := jobs.Submit(ctx, "web1", executor, cmd)
+//	...
+//	job, err = jobs.Get(job.ID)
+//	stdout, err := jobs.Output(job.ID, rexec.JobStdout)
+
+// DefaultJobWorkers is the number of jobs a JobManager runs concurrently if
+// its Workers is not set.
+const DefaultJobWorkers = 4
+
+// DefaultJobMaxQueued is the number of jobs a JobManager queues if its
+// MaxQueued is not set.
+const DefaultJobMaxQueued = 1024
+
+// DefaultJobMaxOutputBytes is the size cap of the outputs of the jobs of a
+// JobManager that does not set its MaxOutputBytes.
+const DefaultJobMaxOutputBytes = 1 << 20
+
+// JobState is the state of a Job.
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"    // waiting for a worker
+	JobRunning   JobState = "running"   // being executed
+	JobSucceeded JobState = "succeeded" // exited with status 0
+	JobFailed    JobState = "failed"    // exited with another status, or failed to run
+	JobCancelled JobState = "cancelled" // cancelled by JobManager.Cancel or Close
+)
+
+// Done reports whether the state is final.
+func (s JobState) Done() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCancelled
+}
+
+// names of the outputs of a job (see JobStore)
+const (
+	JobStdout = "stdout"
+	JobStderr = "stderr"
+)
+
+// Job is the record of a command run asynchronously by a JobManager.
+type Job struct {
+	ID string
+	// Executor is the name of the executor given to Submit.
+	Executor string
+	// Target is the TargetInfo of the executor.
+	Target TargetInfo
+	// Principal is the principal of the ctx given to Submit
+	// (see WithPrincipal).
+	Principal string `json:",omitempty"`
+	// Command is the command line, with the secrets masked.
+	Command string
+
+	State JobState
+	// Status is the exit status of the command (see Command.Status).
+	// It is -1 until the job is done.
+	Status int
+	// Error is the error of the executor, if any.
+	Error string `json:",omitempty"`
+
+	// StdoutBytes and StderrBytes are the sizes of the outputs, including
+	// the bytes discarded beyond the MaxOutputBytes of the JobManager.
+	StdoutBytes int64
+	StderrBytes int64
+	// StdoutTruncated and StderrTruncated report if the outputs exceeded
+	// the MaxOutputBytes of the JobManager.
+	StdoutTruncated bool `json:",omitempty"`
+	StderrTruncated bool `json:",omitempty"`
+
+	Submitted time.Time
+	// Started and Ended are zero until the job starts and ends.
+	Started time.Time
+	Ended   time.Time
+}
+
+// JobStore persists the jobs of a JobManager and their outputs.
+//
+// Implementations must be safe for concurrent use. The JobManager saves a
+// job each time its state changes.
+type JobStore interface {
+	// SaveJob creates or replaces the record of the job.
+	SaveJob(job *Job) error
+	// LoadJob returns the job of the id, or an error wrapping
+	// ErrJobNotFound.
+	LoadJob(id string) (*Job, error)
+	// ListJobs returns all the jobs, in the order they were submitted.
+	// The records that cannot be read should be skipped (and reported),
+	// rather than failing the listing: the JobManager cannot start
+	// otherwise.
+	ListJobs() ([]*Job, error)
+
+	// CreateOutput returns a writer storing the output (JobStdout or
+	// JobStderr) of the job. The JobManager closes it when the job ends.
+	CreateOutput(id, name string) (io.WriteCloser, error)
+	// ReadOutput returns the output of the job stored so far: it is empty
+	// if the job has not started. It returns an error wrapping
+	// ErrJobNotFound for unknown jobs.
+	ReadOutput(id, name string) ([]byte, error)
+}
+
+// JobManager runs commands asynchronously, as jobs, with a bounded pool of
+// Workers. The jobs and their outputs are recorded to the Store, where they
+// can be read while running and after they end.
+//
+// A job that was queued or running when the previous JobManager on the
+// Store exited (e.g. the process crashed) is marked failed with
+// ErrJobInterrupted when the JobManager starts: the jobs are not resumed.
+//
+// The zero value is ready to use, with a FileJobStore in DefaultJobDir.
+// The JobManager starts its workers on the first use: Close it to stop
+// them. It does not own the executors.
+type JobManager struct {
+	// Workers is the number of jobs running at the same time.
+	// If <= 0, DefaultJobWorkers is used.
+	Workers int
+	// MaxQueued is the number of jobs waiting for a worker, beyond which
+	// Submit fails with ErrJobQueueFull.
+	// If <= 0, DefaultJobMaxQueued is used.
+	MaxQueued int
+	// MaxOutputBytes caps the stdout and the stderr stored for a job (each).
+	// The rest is discarded, and the Job is marked truncated.
+	// If <= 0, DefaultJobMaxOutputBytes is used.
+	MaxOutputBytes int64
+
+	// Store records the jobs and their outputs.
+	// If nil, a FileJobStore in DefaultJobDir is used.
+	Store JobStore `json:"-"`
+
+	// Logger, if not nil, overrides the global Logger for this manager.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+
+	initOnce sync.Once
+	initErr  error
+
+	mu      sync.Mutex
+	active  map[string]*activeJob // the queued and running jobs
+	queue   chan *activeJob
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// activeJob is a queued or running job of a JobManager.
+// Its job is guarded by the lock of the JobManager.
+type activeJob struct {
+	job      Job
+	executor Executor
+	cmd      *Command
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+
+	stdout, stderr *jobOutput
+}
+
+// init sets the defaults, recovers the interrupted jobs, and starts the
+// workers.
+func (m *JobManager) init() {
+	logger := orGlobalLogger(m.Logger).With("field", "rexec.JobManager.init")
+
+	if m.Store == nil {
+		store, err := NewFileJobStore(DefaultJobDir())
+		if err != nil {
+			m.initErr = err
+			logger.Error("failed to create the default job store", "err", m.initErr)
+			return
+		}
+		store.Logger = m.Logger
+		m.Store = store
+	}
+
+	jobs, err := m.Store.ListJobs()
+	if err != nil {
+		logger.Error("failed to list the jobs", "err", err)
+		m.initErr = fmt.Errorf("%w: %w", ErrJobStore, err)
+		return
+	}
+	for _, job := range jobs {
+		if job.State.Done() {
+			continue
+		}
+		job.State = JobFailed
+		job.Error = ErrJobInterrupted.Error()
+		if err := m.Store.SaveJob(job); err != nil {
+			logger.Error("failed to save the interrupted job", "job", job.ID, "err", err)
+			continue
+		}
+		logger.Warn("job interrupted", "job", job.ID)
+	}
+
+	workers := m.Workers
+	if workers <= 0 {
+		workers = DefaultJobWorkers
+	}
+	maxQueued := m.MaxQueued
+	if maxQueued <= 0 {
+		maxQueued = DefaultJobMaxQueued
+	}
+
+	m.active = make(map[string]*activeJob)
+	m.queue = make(chan *activeJob, maxQueued)
+	m.workers.Add(workers)
+	for range workers {
+		go func() {
+			defer m.workers.Done()
+			for aj := range m.queue {
+				m.run(aj)
+			}
+		}()
+	}
+}
+
+func (m *JobManager) maxOutputBytes() int64 {
+	if m.MaxOutputBytes > 0 {
+		return m.MaxOutputBytes
+	}
+	return DefaultJobMaxOutputBytes
+}
+
+// Submit queues the command to be run on the executor as a new job, and
+// returns the job. The name of the executor is recorded in the Job.
+//
+// The job runs with the values of the ctx (e.g. the principal, the logger),
+// but outlives it: cancel the job with Cancel. The cmd must not be reused:
+// its Stdout and Stderr are replaced by the outputs in the Store.
+//
+// It returns an error wrapping ErrJobQueueFull if MaxQueued jobs are
+// already waiting, and ErrAlreadyClosed after Close.
+func (m *JobManager) Submit(ctx context.Context, name string, e Executor, cmd *Command) (*Job, error) {
+	m.initOnce.Do(m.init)
+	logger := loggerFor(ctx, m.Logger).With("field", "rexec.JobManager.Submit", "executor", name, "cmd", cmd)
+
+	if m.initErr != nil {
+		return nil, m.initErr
+	}
+	if e == nil {
+		logger.Warn("reject job: nil executor")
+		return nil, fmt.Errorf("%w: nil executor %q", ErrExecutorNotSet, name)
+	}
+	if err := cmd.Validate(); err != nil {
+		logger.Warn("reject job: invalid command", "err", err)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCommand, err)
+	}
+
+	jobCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	aj := &activeJob{
+		job: Job{
+			ID:        newJobID(),
+			Executor:  name,
+			Target:    TargetOf(e),
+			Principal: PrincipalFrom(ctx),
+			Command:   cmd.Redact(cmd.Command),
+			State:     JobQueued,
+			Status:    -1,
+			Submitted: time.Now().UTC(),
+		},
+		executor: e,
+		cmd:      cmd,
+		ctx:      jobCtx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		cancel(nil)
+		return nil, ErrAlreadyClosed
+	}
+	// checked before the job is saved, so that no record is left of a job
+	// whose ID the caller never gets. The queue is only fed here, with the
+	// lock held: the send below cannot block.
+	if len(m.queue) == cap(m.queue) {
+		cancel(nil)
+		logger.Warn("reject job: queue full")
+		return nil, fmt.Errorf("%w: %d jobs queued", ErrJobQueueFull, len(m.queue))
+	}
+	if err := m.Store.SaveJob(&aj.job); err != nil {
+		cancel(nil)
+		logger.Error("failed to save the job", "err", err)
+		return nil, fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+
+	m.queue <- aj
+	m.active[aj.job.ID] = aj
+
+	logger.Info("job queued", "job", aj.job.ID)
+	job := aj.job
+	return &job, nil
+}
+
+// run runs the job, unless it has been cancelled while queued.
+func (m *JobManager) run(aj *activeJob) {
+	logger := loggerFor(aj.ctx, m.Logger).With("field", "rexec.JobManager.run", "job", aj.job.ID)
+
+	m.mu.Lock()
+	if aj.job.State != JobQueued {
+		m.mu.Unlock()
+		return
+	}
+	aj.job.State = JobRunning
+	aj.job.Started = time.Now().UTC()
+	m.save(logger, &aj.job)
+	m.mu.Unlock()
+
+	logger.Debug("job started")
+
+	err := m.execute(aj)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := &aj.job
+	job.Ended = time.Now().UTC()
+	job.Status = aj.cmd.Status
+	if err != nil {
+		job.Error = err.Error()
+	}
+	job.StdoutBytes, job.StdoutTruncated = aj.stdout.size()
+	job.StderrBytes, job.StderrTruncated = aj.stderr.size()
+	switch {
+	case errors.Is(context.Cause(aj.ctx), ErrJobCancelled):
+		job.State = JobCancelled
+	case err == nil && aj.cmd.Status == 0:
+		job.State = JobSucceeded
+	default:
+		job.State = JobFailed
+	}
+	m.finish(logger, aj)
+
+	logger.Info("job finished", "state", job.State, "status", job.Status, "err", err)
+}
+
+// execute opens the outputs of the job, and executes its command.
+func (m *JobManager) execute(aj *activeJob) error {
+	defer aj.cancel(nil)
+
+	stdout, stderr, err := m.createOutputs(aj.job.ID)
+
+	m.mu.Lock()
+	aj.stdout = newJobOutput(stdout, m.maxOutputBytes())
+	aj.stderr = newJobOutput(stderr, m.maxOutputBytes())
+	m.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+	aj.cmd.Stdout, aj.cmd.Stderr = aj.stdout, aj.stderr
+
+	err = aj.executor.Execute(aj.ctx, aj.cmd)
+
+	return errors.Join(err, aj.stdout.close(), aj.stderr.close())
+}
+
+// createOutputs creates both outputs of the job in the Store, or none.
+func (m *JobManager) createOutputs(id string) (stdout, stderr io.WriteCloser, err error) {
+	stdout, err = m.Store.CreateOutput(id, JobStdout)
+	if err != nil {
+		return nil, nil, err
+	}
+	stderr, err = m.Store.CreateOutput(id, JobStderr)
+	if err != nil {
+		_ = stdout.Close()
+		return nil, nil, err
+	}
+	return stdout, stderr, nil
+}
+
+// finish saves the final state of the job, and removes it from the active
+// ones. It must be called with the lock held.
+func (m *JobManager) finish(logger *slog.Logger, aj *activeJob) {
+	m.save(logger, &aj.job)
+	delete(m.active, aj.job.ID)
+	close(aj.done)
+}
+
+// save saves the job to the Store, logging the errors: a job is not failed
+// because of its record.
+func (m *JobManager) save(logger *slog.Logger, job *Job) {
+	if err := m.Store.SaveJob(job); err != nil {
+		logger.Error("failed to save the job", "state", job.State, "err", err)
+	}
+}
+
+// Get returns the job of the id, or an error wrapping ErrJobNotFound.
+func (m *JobManager) Get(id string) (*Job, error) {
+	m.initOnce.Do(m.init)
+	if m.initErr != nil {
+		return nil, m.initErr
+	}
+
+	m.mu.Lock()
+	if aj, ok := m.active[id]; ok {
+		job := aj.job
+		if aj.stdout != nil {
+			job.StdoutBytes, job.StdoutTruncated = aj.stdout.size()
+			job.StderrBytes, job.StderrTruncated = aj.stderr.size()
+		}
+		m.mu.Unlock()
+		return &job, nil
+	}
+	m.mu.Unlock()
+
+	return m.Store.LoadJob(id)
+}
+
+// List returns all the jobs in the Store, in the order they were
+// submitted.
+func (m *JobManager) List() ([]*Job, error) {
+	m.initOnce.Do(m.init)
+	if m.initErr != nil {
+		return nil, m.initErr
+	}
+	return m.Store.ListJobs()
+}
+
+// Output returns the output (JobStdout or JobStderr) of the job of the id
+// stored so far.
+func (m *JobManager) Output(id, name string) ([]byte, error) {
+	m.initOnce.Do(m.init)
+	if m.initErr != nil {
+		return nil, m.initErr
+	}
+	return m.Store.ReadOutput(id, name)
+}
+
+// Cancel cancels the job of the id: a queued job is not run, and the ctx of
+// a running one is cancelled, which stops its command. Cancel does not wait
+// for the job to end, see Wait.
+//
+// Cancelling a done job does nothing. It returns an error wrapping
+// ErrJobNotFound for unknown jobs.
+func (m *JobManager) Cancel(id string) error {
+	m.initOnce.Do(m.init)
+	if m.initErr != nil {
+		return m.initErr
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	aj, ok := m.active[id]
+	if !ok {
+		_, err := m.Store.LoadJob(id)
+		return err
+	}
+	m.cancel(aj)
+	return nil
+}
+
+// cancel cancels the active job. It must be called with the lock held.
+func (m *JobManager) cancel(aj *activeJob) {
+	logger := loggerFor(aj.ctx, m.Logger).With("field", "rexec.JobManager.cancel", "job", aj.job.ID)
+
+	aj.cancel(ErrJobCancelled)
+	if aj.job.State == JobQueued {
+		aj.job.State = JobCancelled
+		aj.job.Ended = time.Now().UTC()
+		m.finish(logger, aj)
+	}
+	logger.Info("job cancelled")
+}
+
+// Wait waits for the job of the id to be done, and returns it.
+// It returns the error of the ctx if it is done first.
+func (m *JobManager) Wait(ctx context.Context, id string) (*Job, error) {
+	m.initOnce.Do(m.init)
+	if m.initErr != nil {
+		return nil, m.initErr
+	}
+
+	m.mu.Lock()
+	aj, ok := m.active[id]
+	m.mu.Unlock()
+
+	if ok {
+		select {
+		case <-aj.done:
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+	return m.Store.LoadJob(id)
+}
+
+// Close cancels the queued and running jobs, and waits for the workers to
+// stop. The Store is not closed.
+func (m *JobManager) Close() error {
+	m.initOnce.Do(m.init)
+	if m.initErr != nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrAlreadyClosed
+	}
+	m.closed = true
+	for _, aj := range m.active {
+		m.cancel(aj)
+	}
+	close(m.queue)
+	m.mu.Unlock()
+
+	m.workers.Wait()
+	return nil
+}
+
+// newJobID returns a random job ID.
+func newJobID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobOutput writes the first max bytes written to it to w, and discards
+// the rest. Its Write never fails, so that the command is not broken by the
+// cap or the store: the error of w is returned by close.
+type jobOutput struct {
+	mu        sync.Mutex
+	w         io.WriteCloser
+	max       int64
+	n         int64
+	truncated bool
+	err       error
+}
+
+func newJobOutput(w io.WriteCloser, max int64) *jobOutput {
+	return &jobOutput{w: w, max: max}
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	kept := p
+	if room := o.max - o.n; int64(len(p)) > room {
+		kept = p[:max(room, 0)]
+		o.truncated = true
+	}
+	o.n += int64(len(p))
+
+	if len(kept) > 0 && o.err == nil {
+		_, o.err = o.w.Write(kept)
+	}
+	return len(p), nil
+}
+
+// size returns the number of bytes written, and if some were discarded.
+func (o *jobOutput) size() (int64, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.n, o.truncated
+}
+
+// close closes w, and returns the first error of w.
+func (o *jobOutput) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.w == nil {
+		return nil
+	}
+	if err := o.w.Close(); o.err == nil {
+		o.err = err
+	}
+	if o.err != nil {
+		return fmt.Errorf("%w: %w", ErrJobStore, o.err)
+	}
+	return nil
+}
+
+// DefaultJobDir returns the directory of the FileJobStore of a JobManager
+// without Store: "rexec/jobs" in the user cache directory (see
+// os.UserCacheDir), or in the temporary directory if there is none.
+func DefaultJobDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "rexec", "jobs")
+}
+
+// job errors
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrJobQueueFull   = errors.New("job queue is full")
+	ErrJobCancelled   = errors.New("job cancelled")
+	ErrJobInterrupted = errors.New("job interrupted") // by the exit of the process running it
+	ErrJobStore       = errors.New("job store failed")
+	ErrBadJobRecord   = errors.New("bad job record") // that cannot be decoded
+)
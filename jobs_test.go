@@ -0,0 +1,261 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestJobStores(t *testing.T) map[string]JobStore {
+	t.Helper()
+	files, err := NewFileJobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("❌ NewFileJobStore() error = %v", err)
+	}
+	return map[string]JobStore{"file": files, "memory": &MemoryJobStore{}}
+}
+
+func TestJobManager_Submit(t *testing.T) {
+	sh := &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}}
+
+	tests := []struct {
+		name            string
+		command         string
+		maxOutputBytes  int64
+		wantState       JobState
+		wantStatus      int
+		wantStdout      string
+		wantStderr      string
+		wantTruncated   bool
+		wantStdoutBytes int64
+	}{
+		{name: "succeeded", command: "echo hello; echo oops >&2",
+			wantState: JobSucceeded, wantStdout: "hello\n", wantStderr: "oops\n", wantStdoutBytes: 6},
+		{name: "failed", command: "exit 3",
+			wantState: JobFailed, wantStatus: 3},
+		{name: "truncated", command: "echo 0123456789abcdef", maxOutputBytes: 8,
+			wantState: JobSucceeded, wantStdout: "01234567", wantTruncated: true, wantStdoutBytes: 17},
+	}
+	for storeName, store := range newTestJobStores(t) {
+		for _, tt := range tests {
+			t.Run(storeName+"/"+tt.name, func(t *testing.T) {
+				m := &JobManager{Store: store, MaxOutputBytes: tt.maxOutputBytes}
+				defer m.Close()
+
+				ctx := WithPrincipal(context.Background(), "alice")
+				job, err := m.Submit(ctx, "sh", sh, &Command{Command: tt.command})
+				if err != nil {
+					t.Fatalf("❌ Submit() error = %v", err)
+				}
+				if job.State != JobQueued || job.Status != -1 || job.Executor != "sh" || job.Principal != "alice" {
+					t.Errorf("❌ Submit() = %+v, want a queued job of alice on sh", job)
+				}
+
+				job, err = m.Wait(context.Background(), job.ID)
+				if err != nil {
+					t.Fatalf("❌ Wait() error = %v", err)
+				}
+				if job.State != tt.wantState || job.Status != tt.wantStatus {
+					t.Errorf("❌ Wait() = %v (status %d), want %v (status %d)", job.State, job.Status, tt.wantState, tt.wantStatus)
+				}
+				if job.StdoutTruncated != tt.wantTruncated || job.StdoutBytes != tt.wantStdoutBytes {
+					t.Errorf("❌ Wait() stdout bytes = %d (truncated %v), want %d (truncated %v)",
+						job.StdoutBytes, job.StdoutTruncated, tt.wantStdoutBytes, tt.wantTruncated)
+				}
+				if job.Started.IsZero() || job.Ended.Before(job.Started) {
+					t.Errorf("❌ Wait() started = %v, ended = %v", job.Started, job.Ended)
+				}
+
+				for name, want := range map[string]string{JobStdout: tt.wantStdout, JobStderr: tt.wantStderr} {
+					got, err := m.Output(job.ID, name)
+					if err != nil || string(got) != want {
+						t.Errorf("❌ Output(%s) = (%q, %v), want %q", name, got, err, want)
+					}
+				}
+				t.Logf("✅ job %s", job.State)
+			})
+		}
+	}
+}
+
+func TestJobManager_Submit_reject(t *testing.T) {
+	m := &JobManager{Store: &MemoryJobStore{}}
+	ctx := context.Background()
+
+	if _, err := m.Submit(ctx, "none", nil, &Command{Command: "true"}); !errors.Is(err, ErrExecutorNotSet) {
+		t.Errorf("❌ Submit(nil executor) error = %v, want %v", err, ErrExecutorNotSet)
+	}
+	if _, err := m.Submit(ctx, "sh", &ShellExecutor{}, &Command{}); !errors.Is(err, ErrInvalidCommand) {
+		t.Errorf("❌ Submit(empty command) error = %v, want %v", err, ErrInvalidCommand)
+	}
+
+	_ = m.Close()
+	if _, err := m.Submit(ctx, "sh", &ShellExecutor{}, &Command{Command: "true"}); !errors.Is(err, ErrAlreadyClosed) {
+		t.Errorf("❌ Submit() after Close() error = %v, want %v", err, ErrAlreadyClosed)
+	}
+	t.Logf("✅ Submit() rejects bad jobs")
+}
+
+func TestMemoryJobStore_MaxDoneJobs(t *testing.T) {
+	store := &MemoryJobStore{MaxDoneJobs: 2}
+	m := &JobManager{Store: store, Workers: 1}
+	defer m.Close()
+	sh := &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}}
+
+	var ids []string
+	for i := range 4 {
+		job, err := m.Submit(context.Background(), "sh", sh, &Command{Command: "echo " + strconv.Itoa(i)})
+		if err != nil {
+			t.Fatalf("❌ Submit() error = %v", err)
+		}
+		if _, err := m.Wait(context.Background(), job.ID); err != nil {
+			t.Fatalf("❌ Wait() error = %v", err)
+		}
+		ids = append(ids, job.ID)
+	}
+
+	jobs, err := m.List()
+	if err != nil || len(jobs) != 2 || jobs[0].ID != ids[2] || jobs[1].ID != ids[3] {
+		t.Fatalf("❌ List() = (%v, %v), want the last 2 jobs", jobs, err)
+	}
+	if _, err := m.Output(ids[0], JobStdout); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("❌ Output() of a removed job error = %v, want %v", err, ErrJobNotFound)
+	}
+	if len(store.outputs) != 4 {
+		t.Errorf("❌ %d outputs kept, want 4", len(store.outputs))
+	}
+	if out, err := m.Output(ids[3], JobStdout); err != nil || string(out) != "3\n" {
+		t.Errorf("❌ Output() = (%q, %v), want %q", out, err, "3\n")
+	}
+	t.Logf("✅ %d done jobs kept", len(jobs))
+}
+
+func TestJobManager_Cancel(t *testing.T) {
+	e := newBlockingExecutor()
+	m := &JobManager{Store: &MemoryJobStore{}, Workers: 1, MaxQueued: 1}
+	defer m.Close()
+	ctx := context.Background()
+
+	running, err := m.Submit(ctx, "blocking", e, &Command{Command: "sleep"})
+	if err != nil {
+		t.Fatalf("❌ Submit() error = %v", err)
+	}
+	<-e.started
+	queued, err := m.Submit(ctx, "blocking", e, &Command{Command: "sleep"})
+	if err != nil {
+		t.Fatalf("❌ Submit() error = %v", err)
+	}
+	if _, err := m.Submit(ctx, "blocking", e, &Command{Command: "sleep"}); !errors.Is(err, ErrJobQueueFull) {
+		t.Errorf("❌ Submit() to a full queue error = %v, want %v", err, ErrJobQueueFull)
+	}
+	if jobs, _ := m.List(); len(jobs) != 2 {
+		t.Errorf("❌ List() = %v, want the rejected job not recorded", jobs)
+	}
+
+	if job, _ := m.Get(running.ID); job.State != JobRunning {
+		t.Errorf("❌ Get() state = %v, want %v", job.State, JobRunning)
+	}
+	if job, _ := m.Get(queued.ID); job.State != JobQueued {
+		t.Errorf("❌ Get() state = %v, want %v", job.State, JobQueued)
+	}
+
+	for _, id := range []string{queued.ID, running.ID} {
+		if err := m.Cancel(id); err != nil {
+			t.Fatalf("❌ Cancel() error = %v", err)
+		}
+		job, err := m.Wait(ctx, id)
+		if err != nil {
+			t.Fatalf("❌ Wait() error = %v", err)
+		}
+		if job.State != JobCancelled {
+			t.Errorf("❌ Wait() state = %v, want %v", job.State, JobCancelled)
+		}
+	}
+
+	// the queued one never ran
+	select {
+	case <-e.started:
+		t.Errorf("❌ the cancelled queued job ran")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// done jobs
+	if err := m.Cancel(running.ID); err != nil {
+		t.Errorf("❌ Cancel(done) error = %v, want nil", err)
+	}
+	if err := m.Cancel("unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("❌ Cancel(unknown) error = %v, want %v", err, ErrJobNotFound)
+	}
+	if _, err := m.Get("../unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("❌ Get(unknown) error = %v, want %v", err, ErrJobNotFound)
+	}
+	t.Logf("✅ Cancel() stops queued and running jobs")
+}
+
+func TestJobManager_restart(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *JobManager {
+		store, err := NewFileJobStore(dir)
+		if err != nil {
+			t.Fatalf("❌ NewFileJobStore() error = %v", err)
+		}
+		return &JobManager{Store: store}
+	}
+	ctx := context.Background()
+
+	m := open()
+	done, err := m.Submit(ctx, "sh", &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}}, &Command{Command: "echo hello"})
+	if err != nil {
+		t.Fatalf("❌ Submit() error = %v", err)
+	}
+	if _, err := m.Wait(ctx, done.ID); err != nil {
+		t.Fatalf("❌ Wait() error = %v", err)
+	}
+	_ = m.Close()
+
+	// a job left running by a crashed process
+	store, _ := NewFileJobStore(dir)
+	crashed := &Job{ID: "crashed", State: JobRunning, Status: -1, Submitted: time.Now().UTC()}
+	if err := store.SaveJob(crashed); err != nil {
+		t.Fatalf("❌ SaveJob() error = %v", err)
+	}
+	// and a corrupted record: skipped, not failing the manager
+	if err := os.WriteFile(filepath.Join(dir, "corrupted.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// and a record that cannot be read (a symlink loop): skipped, and kept
+	if err := os.Symlink("unreadable.json", filepath.Join(dir, "unreadable.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	m = open()
+	defer m.Close()
+	jobs, err := m.List()
+	if err != nil {
+		t.Fatalf("❌ List() error = %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != done.ID || jobs[1].ID != crashed.ID {
+		t.Fatalf("❌ List() = %v, want [%s %s]", jobs, done.ID, crashed.ID)
+	}
+	if jobs[0].State != JobSucceeded {
+		t.Errorf("❌ List()[0] state = %v, want %v", jobs[0].State, JobSucceeded)
+	}
+	if jobs[1].State != JobFailed || !strings.Contains(jobs[1].Error, ErrJobInterrupted.Error()) {
+		t.Errorf("❌ List()[1] = %v (%q), want %v (%v)", jobs[1].State, jobs[1].Error, JobFailed, ErrJobInterrupted)
+	}
+	if out, err := m.Output(done.ID, JobStdout); err != nil || string(out) != "hello\n" {
+		t.Errorf("❌ Output() = (%q, %v), want %q", out, err, "hello\n")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "corrupted.json.bad")); err != nil {
+		t.Errorf("❌ the corrupted record is not moved aside: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "unreadable.json")); err != nil {
+		t.Errorf("❌ the unreadable record is moved: %v", err)
+	}
+	t.Logf("✅ the history survives a restart, and interrupted jobs are failed")
+}
@@ -0,0 +1,329 @@
+package rexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// This file implements the JobStores: FileJobStore (the default one of
+// JobManager) and MemoryJobStore.
+
+// sortJobs sorts the jobs in the order they were submitted.
+func sortJobs(jobs []*Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].Submitted.Equal(jobs[j].Submitted) {
+			return jobs[i].Submitted.Before(jobs[j].Submitted)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+}
+
+// checkJobOutputName returns an error if name is not JobStdout or JobStderr.
+func checkJobOutputName(name string) error {
+	if name != JobStdout && name != JobStderr {
+		return fmt.Errorf("%w: unknown output %q", ErrJobStore, name)
+	}
+	return nil
+}
+
+// FileJobStore stores each job in a directory as 3 files: <id>.json for
+// the Job, and <id>.stdout and <id>.stderr for its outputs.
+//
+// The records are replaced atomically (by renaming), so that a crash does
+// not leave a partial one. A FileJobStore must not be shared by JobManagers
+// of different processes at the same time.
+type FileJobStore struct {
+	// Logger, if not nil, overrides the global Logger for this store. The
+	// JobManager sets it to its own Logger for the store it creates.
+	Logger *slog.Logger `json:"-"`
+
+	dir string
+}
+
+var _ JobStore = (*FileJobStore)(nil)
+
+// NewFileJobStore creates a FileJobStore in the dir, creating it if needed.
+func NewFileJobStore(dir string) (*FileJobStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+	return &FileJobStore{dir: dir}, nil
+}
+
+// Dir returns the directory of the store.
+func (s *FileJobStore) Dir() string {
+	return s.dir
+}
+
+// path returns the path of the file of the job with the ext, or an error
+// wrapping ErrJobNotFound if the id is not a plain file name (the IDs of
+// JobManager always are).
+func (s *FileJobStore) path(id, ext string) (string, error) {
+	if id == "" || !filepath.IsLocal(id) || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("%w: %q", ErrJobNotFound, id)
+	}
+	return filepath.Join(s.dir, id+ext), nil
+}
+
+// SaveJob writes the job to <id>.json.
+func (s *FileJobStore) SaveJob(job *Job) error {
+	name, err := s.path(job.ID, ".json")
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+
+	f, err := os.CreateTemp(s.dir, job.ID+".json.*")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+	return nil
+}
+
+// LoadJob reads the job from <id>.json.
+func (s *FileJobStore) LoadJob(id string) (*Job, error) {
+	name, err := s.path(id, ".json")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrJobNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+	job := &Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, fmt.Errorf("%w: %w: job %q: %w", ErrJobStore, ErrBadJobRecord, id, err)
+	}
+	return job, nil
+}
+
+// ListJobs reads all the <id>.json files.
+//
+// A record that cannot be decoded (ErrBadJobRecord, e.g. corrupted) is
+// logged and moved aside to <id>.json.bad, so that it can be inspected, and
+// is no more listed. A record that cannot be read (e.g. a transient I/O
+// error) is logged and skipped, and listed again once it can be.
+func (s *FileJobStore) ListJobs() ([]*Job, error) {
+	logger := orGlobalLogger(s.Logger).With("field", "rexec.FileJobStore.ListJobs")
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+
+	var jobs []*Job
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		job, err := s.LoadJob(id)
+		if errors.Is(err, ErrBadJobRecord) {
+			s.moveAside(logger, entry.Name(), err)
+			continue
+		}
+		if err != nil {
+			logger.Error("skipping a job record that cannot be read", "file", entry.Name(), "err", err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+// moveAside renames the bad record file name to name.bad.
+func (s *FileJobStore) moveAside(logger *slog.Logger, name string, loadErr error) {
+	logger = logger.With("file", name)
+	path := filepath.Join(s.dir, name)
+	if err := os.Rename(path, path+".bad"); err != nil {
+		logger.Error("skipping a bad job record, failed to move it aside", "loadErr", loadErr, "err", err)
+		return
+	}
+	logger.Warn("bad job record moved aside", "to", name+".bad", "loadErr", loadErr)
+}
+
+// CreateOutput creates (or truncates) the <id>.<name> file.
+func (s *FileJobStore) CreateOutput(id, name string) (io.WriteCloser, error) {
+	if err := checkJobOutputName(name); err != nil {
+		return nil, err
+	}
+	path, err := s.path(id, "."+name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+	return f, nil
+}
+
+// ReadOutput reads the <id>.<name> file.
+func (s *FileJobStore) ReadOutput(id, name string) ([]byte, error) {
+	if err := checkJobOutputName(name); err != nil {
+		return nil, err
+	}
+	path, err := s.path(id, "."+name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// not started yet, or unknown
+		if _, err := s.LoadJob(id); err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJobStore, err)
+	}
+	return data, nil
+}
+
+// MemoryJobStore keeps the jobs and their outputs in memory: the history
+// does not survive the process. The zero value is ready to use.
+type MemoryJobStore struct {
+	// MaxDoneJobs is the number of done jobs kept, with their outputs: when
+	// a job is done, the done jobs submitted first are removed beyond it.
+	// If <= 0, all the jobs are kept, for the life of the process.
+	MaxDoneJobs int
+
+	mu      sync.Mutex
+	jobs    map[string]Job
+	outputs map[string]*bytes.Buffer // by id and name: "<id>.<name>"
+}
+
+var _ JobStore = (*MemoryJobStore)(nil)
+
+// SaveJob keeps a copy of the job, and removes the done jobs beyond
+// MaxDoneJobs if the job is done.
+func (s *MemoryJobStore) SaveJob(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.jobs == nil {
+		s.jobs = make(map[string]Job)
+	}
+	s.jobs[job.ID] = *job
+	if job.State.Done() && s.MaxDoneJobs > 0 {
+		s.prune()
+	}
+	return nil
+}
+
+// prune removes the done jobs submitted first, and their outputs, beyond
+// MaxDoneJobs. It must be called with the lock held.
+func (s *MemoryJobStore) prune() {
+	var done []*Job
+	for _, job := range s.jobs {
+		if job.State.Done() {
+			done = append(done, &job)
+		}
+	}
+	if len(done) <= s.MaxDoneJobs {
+		return
+	}
+	sortJobs(done)
+	for _, job := range done[:len(done)-s.MaxDoneJobs] {
+		delete(s.jobs, job.ID)
+		delete(s.outputs, job.ID+"."+JobStdout)
+		delete(s.outputs, job.ID+"."+JobStderr)
+	}
+}
+
+// LoadJob returns a copy of the job.
+func (s *MemoryJobStore) LoadJob(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrJobNotFound, id)
+	}
+	return &job, nil
+}
+
+// ListJobs returns copies of all the jobs.
+func (s *MemoryJobStore) ListJobs() ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, &job)
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+// CreateOutput returns a writer to a new buffer of the output.
+func (s *MemoryJobStore) CreateOutput(id, name string) (io.WriteCloser, error) {
+	if err := checkJobOutputName(name); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outputs == nil {
+		s.outputs = make(map[string]*bytes.Buffer)
+	}
+	buf := &bytes.Buffer{}
+	s.outputs[id+"."+name] = buf
+	return &memoryJobOutput{s: s, buf: buf}, nil
+}
+
+// ReadOutput returns a copy of the buffer of the output.
+func (s *MemoryJobStore) ReadOutput(id, name string) ([]byte, error) {
+	if err := checkJobOutputName(name); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[id]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrJobNotFound, id)
+	}
+	buf, ok := s.outputs[id+"."+name]
+	if !ok {
+		return []byte{}, nil
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+// memoryJobOutput writes to a buffer of a MemoryJobStore, under its lock.
+type memoryJobOutput struct {
+	s   *MemoryJobStore
+	buf *bytes.Buffer
+}
+
+func (o *memoryJobOutput) Write(p []byte) (int, error) {
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *memoryJobOutput) Close() error { return nil }
@@ -1,7 +1,9 @@
 package rexec
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -15,124 +17,231 @@ type keepAliveSshClient struct {
 	// SshClientConfig is the configuration for the SSH client.
 	SshClientConfig *SshClientConfig
 
-	client *ssh.Client // the underlying SSH client.
-	mu     sync.Mutex
-	closed bool
-	wg     sync.WaitGroup
-	stopCh chan struct{}
+	logger  *slog.Logger // the logger of the executor, nil for the global Logger.
+	metrics *Metrics     // the metrics of the executor, may be nil.
+	tracer  Tracer       // the tracer of the executor, nil for no tracing.
+
+	client  *ssh.Client // the underlying SSH client.
+	mu      sync.Mutex  // guards client, closed and looping. Never held during network round trips to the server.
+	closed  bool
+	looping bool // the keepAlive loop is running
+	wg      sync.WaitGroup
+	stopCh  chan struct{}
+
+	// failures counts consecutive failed keep-alive probes on the current
+	// client. Only accessed by the keepAlive goroutine.
+	failures int
+}
+
+// current returns the current SSH client, or nil if there is none.
+func (c *keepAliveSshClient) current() *ssh.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
 }
 
 // redial the SSH client.
-func (c *keepAliveSshClient) redial() {
-	logger := Logger.With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User)
+// It returns true if there is a living client after redialing.
+func (c *keepAliveSshClient) redial() bool {
+	logger := orGlobalLogger(c.logger).With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User)
 	logger.Debug("keepAliveSshClient redialing ssh client")
 
-	client, err := dialSsh(c.SshClientConfig)
+	// dial without holding the lock: it may take as long as the dial timeout.
+	dialStart := time.Now()
+	ctx := context.Background() // the keep-alive loop is not bound to any execution
+	client, err := dialSsh(ctx, logger, tracerFor(ctx, c.tracer), c.SshClientConfig)
+	c.metrics.observeSshDial(c.SshClientConfig.Addr, dialStart, err)
+	c.metrics.incSshRedials(c.SshClientConfig.Addr, err)
 	if err != nil {
 		logger.Warn("keepAliveSshClient redial ssh client failed", "err", err)
-		return
+		return false
 	}
 
 	c.mu.Lock()
+	if c.closed || c.client != nil {
+		// closed meanwhile, or Client() has dialed a new one concurrently.
+		c.mu.Unlock()
+		logger.Debug("keepAliveSshClient redial ssh client succeeded but not needed anymore. discard it.", "client", sshClientString(client))
+		_ = client.Close()
+		return c.current() != nil
+	}
 	c.client = client
 	c.mu.Unlock()
 
 	// redialing is a thing, report it.
 	logger.Info("keepAliveSshClient redial ssh client succeeded.", "client", sshClientString(client))
+	return true
 }
 
 // tryKeepAlive sends a keep-alive message to the SSH server.
-// It will close the client if the keep-alive fails, which
-// will cause redial in keepAlive loop or Client() call.
-func (c *keepAliveSshClient) tryKeepAlive() {
-	logger := Logger.With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User, "client", sshClientString(c.client))
+//
+// It will close the client if MaxFailures consecutive keep-alive probes fail,
+// which will cause redial in keepAlive loop or Client() call.
+//
+// The lock is not held while waiting for the reply, so that a stuck probe
+// (e.g. on a black-holed TCP connection) never blocks Client().
+func (c *keepAliveSshClient) tryKeepAlive(stopCh <-chan struct{}) {
+	client := c.current()
+
+	logger := orGlobalLogger(c.logger).With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User, "client", sshClientString(client))
+
+	if client == nil {
+		logger.Debug("keepAliveSshClient tryKeepAlive skipped, client is nil")
+		return
+	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	err := c.probe(client, c.SshClientConfig.KeepAlive.timeout(), stopCh)
+	if err == nil {
+		logger.Debug("keep-alive succeeded")
+		c.failures = 0
+		return
+	}
 
-	if c.client == nil {
-		logger.Error("keepAliveSshClient tryKeepAlive failed, client is nil", "tip", "i think this should not happen, please check the code logic.")
+	c.metrics.incKeepAliveFailures(c.SshClientConfig.Addr)
+
+	c.failures++
+	if c.failures < c.SshClientConfig.KeepAlive.maxFailures() {
+		logger.Warn("keep-alive failed, will try again", "err", err, "failures", c.failures)
 		return
 	}
 
-	_, _, err := c.client.SendRequest("keepalive@openssh.com", true, nil)
-	if err != nil {
-		logger.Warn("keep-alive failed, closing client", "err", err)
-		_ = c.client.Close()
+	logger.Warn("keep-alive failed too many times, closing client", "err", err, "failures", c.failures)
+	c.failures = 0
+
+	c.mu.Lock()
+	if c.client == client { // not replaced by someone else meanwhile
 		c.client = nil
-	} else {
-		logger.Debug("keep-alive succeeded")
 	}
+	c.mu.Unlock()
+
+	// closing the client also unblocks any probe still waiting for its reply.
+	_ = client.Close()
 }
 
-// keepAlive loops forever to keep the SSH connection alive until closed.
-func (c *keepAliveSshClient) keepAlive() {
-	logger := Logger.With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User, "client", sshClientString(c.client))
+// probe sends a single keep-alive request and waits for the reply for at most
+// timeout, or until stopCh is closed.
+func (c *keepAliveSshClient) probe(client *ssh.Client, timeout time.Duration, stopCh <-chan struct{}) error {
+	done := make(chan error, 1) // buffered: the sender may outlive us
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w after %v", ErrKeepAliveTimeout, timeout)
+	case <-stopCh:
+		return ErrAlreadyClosed
+	}
+}
+
+// keepAlive loops forever to keep the SSH connection alive until stopCh is
+// closed.
+func (c *keepAliveSshClient) keepAlive(stopCh <-chan struct{}) {
+	logger := orGlobalLogger(c.logger).With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User)
 
 	defer c.wg.Done()
 
+	config := c.SshClientConfig.KeepAlive
+
 	retries := 0
-	ticker := time.NewTicker(c.SshClientConfig.KeepAlive.interval(0))
-	defer ticker.Stop()
+	timer := time.NewTimer(config.interval(0))
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-timer.C:
 			logger.Debug("keepAliveSshClient keepAlive at tick")
 
-			if c.client == nil {
+			if c.current() == nil {
 				logger.Debug("keepAliveSshClient redialing...")
 				c.redial()
 			}
 
-			c.tryKeepAlive()
+			c.tryKeepAlive(stopCh)
 
-			if c.client == nil {
+			var interval time.Duration
+			if c.current() == nil {
 				retries++
-				interval := c.SshClientConfig.KeepAlive.interval(retries)
+				interval = config.backoff(retries)
 				logger.Debug("keepAliveSshClient keepAlive failed, will retry", "retries", retries, "interval", interval)
-				ticker.Reset(interval)
-			} else if retries != 0 {
-				interval := c.SshClientConfig.KeepAlive.interval(0)
-				logger.Debug("keepAliveSshClient keepAlive succeeded after retries. Reset retries & interval", "retries", retries, "interval", interval)
+			} else {
+				if retries != 0 {
+					logger.Debug("keepAliveSshClient keepAlive succeeded after retries. Reset retries & interval", "retries", retries)
+				}
 				retries = 0
-				ticker.Reset(interval)
+				interval = config.interval(0)
 			}
-			// else: keep-alive succeeded, no need to modify the retry or ticker interval.
-		case <-c.stopCh:
+			timer.Reset(interval)
+		case <-stopCh:
 			logger.Debug("keepAliveSshClient keepAlive stopped")
 			return
 		}
 	}
 }
 
-// stopKeepAlive signals the keep-alive routine to stop.
+// startKeepAlive starts the keep-alive loop if it is not running.
+// It must be called with c.mu held.
+func (c *keepAliveSshClient) startKeepAlive() {
+	if c.looping {
+		return
+	}
+	c.stopCh = make(chan struct{})
+	c.looping = true
+	c.wg.Add(1)
+	go c.keepAlive(c.stopCh)
+}
+
+// stopKeepAlive signals the keep-alive routine to stop and waits for it.
+//
+// It must NOT be called with c.mu held: the keep-alive routine takes the lock
+// briefly, and waiting for it while holding the lock would deadlock.
 func (c *keepAliveSshClient) stopKeepAlive() {
-	logger := Logger.With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User)
+	logger := orGlobalLogger(c.logger).With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User)
 
-	if c.stopCh == nil {
-		c.stopCh = make(chan struct{})
+	c.mu.Lock()
+	if !c.looping {
+		c.mu.Unlock()
+		logger.Debug("keepAliveSshClient keep-alive routine is not running")
+		return
 	}
+	c.looping = false
+	stopCh := c.stopCh
+	c.stopCh = nil
+	c.mu.Unlock()
 
-	select {
-	case <-c.stopCh:
-		// Already closed
-		logger.Warn("keepAliveSshClient stop already closed")
-	default:
-		logger.Debug("keepAliveSshClient signals the keep-alive routine to stop")
-		close(c.stopCh)
-		c.wg.Wait()
-	}
-	c.stopCh = make(chan struct{})
+	logger.Debug("keepAliveSshClient signals the keep-alive routine to stop")
+	close(stopCh)
+	c.wg.Wait()
 }
 
 // Client tries to get a living SSH client. It will redial if needed.
+//
+// It never waits for an in-flight keep-alive probe.
 func (c *keepAliveSshClient) Client() (*ssh.Client, error) {
-	logger := Logger.With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User)
+	ctx := context.Background()
+	return c.ClientContext(ctx, tracerFor(ctx, c.tracer))
+}
+
+// ClientContext is Client with the ctx and tracer for dialing (if needed):
+// the dial is traced as a child span of the one in ctx, and canceled if ctx
+// is done.
+func (c *keepAliveSshClient) ClientContext(ctx context.Context, tracer Tracer) (*ssh.Client, error) {
+	logger := orGlobalLogger(c.logger).With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User)
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.closed {
+		logger.Warn("keepAliveSshClient already closed")
+		return nil, ErrAlreadyClosed
+	}
+
 	if c.client != nil {
 		logger.Debug("keepAliveSshClient client already exists. return it.", "client", sshClientString(c.client))
 		return c.client, nil
@@ -140,7 +249,9 @@ func (c *keepAliveSshClient) Client() (*ssh.Client, error) {
 
 	logger.Debug("keepAliveSshClient dialing ssh client...")
 
-	client, err := dialSsh(c.SshClientConfig)
+	dialStart := time.Now()
+	client, err := dialSsh(ctx, logger, tracer, c.SshClientConfig)
+	c.metrics.observeSshDial(c.SshClientConfig.Addr, dialStart, err)
 	if err != nil {
 		logger.Error("keepAliveSshClient dial ssh client failed", "err", err)
 		return nil, err
@@ -149,9 +260,7 @@ func (c *keepAliveSshClient) Client() (*ssh.Client, error) {
 	logger.Info("keepAliveSshClient dial ssh client succeeded", "client", sshClientString(client))
 
 	c.client = client
-	c.stopKeepAlive()
-	c.wg.Add(1)
-	go c.keepAlive()
+	c.startKeepAlive()
 
 	logger.Debug("keepAliveSshClient keepAlive started", "client", sshClientString(client))
 
@@ -160,25 +269,29 @@ func (c *keepAliveSshClient) Client() (*ssh.Client, error) {
 
 // Close the SSH client and stop the keep-alive loop.
 func (c *keepAliveSshClient) Close() error {
-	logger := Logger.With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User)
+	logger := orGlobalLogger(c.logger).With("addr", c.SshClientConfig.Addr, "user", c.SshClientConfig.User)
 	logger.Debug("keepAliveSshClient closing...")
 
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if c.closed {
+		c.mu.Unlock()
 		logger.Warn("keepAliveSshClient already closed")
 		return ErrAlreadyClosed
 	}
 	c.closed = true
+	c.mu.Unlock()
 
 	c.stopKeepAlive()
 
+	c.mu.Lock()
+	client := c.client
+	c.client = nil
+	c.mu.Unlock()
+
 	var err error
-	if c.client != nil {
-		err = c.client.Close()
+	if client != nil {
+		err = client.Close()
 	}
-	c.client = nil
 
 	logger.Info("keepAliveSshClient closed", "err", err)
 
@@ -189,4 +302,7 @@ func (c *keepAliveSshClient) Close() error {
 var (
 	// ErrAlreadyClosed is returned when calling Close() on an already closed client.
 	ErrAlreadyClosed = fmt.Errorf("already closed")
+	// ErrKeepAliveTimeout is the failure of a keep-alive probe that is not
+	// answered in time.
+	ErrKeepAliveTimeout = fmt.Errorf("keep-alive timed out")
 )
@@ -1,14 +1,17 @@
 package rexec
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"runtime"
 	"testing"
 	"time"
 
+	"github.com/cdfmlr/rexec/v2/internal/testsshd"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -94,7 +97,7 @@ func Test_dialSsh(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := dialSsh(tt.args.config)
+			got, err := dialSsh(context.Background(), Logger, noopTracer{}, tt.args.config)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("❌ dialSsh() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -379,8 +382,10 @@ func Test_keepAliveSshClient(t *testing.T) {
 
 func Test_keepAlive_interval(t *testing.T) {
 	type fields struct {
-		IntervalSeconds  int
-		IncrementSeconds int
+		IntervalSeconds    int
+		IncrementSeconds   int
+		Multiplier         float64
+		MaxIntervalSeconds int
 	}
 	type args struct {
 		retries int
@@ -454,12 +459,71 @@ func Test_keepAlive_interval(t *testing.T) {
 			args: args{retries: 8},
 			want: MinSshKeepAliveInterval,
 		},
+		{
+			name: "linearCapped",
+			fields: fields{
+				IntervalSeconds:    3,
+				IncrementSeconds:   5,
+				MaxIntervalSeconds: 10,
+			},
+			args: args{retries: 4},
+			want: 10 * time.Second,
+		},
+		{
+			name: "exponential",
+			fields: fields{
+				IntervalSeconds: 2,
+				Multiplier:      2,
+			},
+			args: args{retries: 3},
+			want: 16 * time.Second,
+		},
+		{
+			name: "exponentialNoRetry",
+			fields: fields{
+				IntervalSeconds:  2,
+				IncrementSeconds: 100, // ignored
+				Multiplier:       2,
+			},
+			args: args{retries: 0},
+			want: 2 * time.Second,
+		},
+		{
+			name: "exponentialZeroInterval",
+			fields: fields{
+				IntervalSeconds: 0,
+				Multiplier:      3,
+			},
+			args: args{retries: 2},
+			want: 9 * MinSshKeepAliveInterval,
+		},
+		{
+			name: "exponentialCapped",
+			fields: fields{
+				IntervalSeconds:    2,
+				Multiplier:         2,
+				MaxIntervalSeconds: 60,
+			},
+			args: args{retries: 100},
+			want: 60 * time.Second,
+		},
+		{
+			name: "exponentialOverflow",
+			fields: fields{
+				IntervalSeconds: 2,
+				Multiplier:      10,
+			},
+			args: args{retries: 1000},
+			want: maxSshKeepAliveInterval,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := SshKeepAliveConfig{
-				IntervalSeconds:  tt.fields.IntervalSeconds,
-				IncrementSeconds: tt.fields.IncrementSeconds,
+				IntervalSeconds:    tt.fields.IntervalSeconds,
+				IncrementSeconds:   tt.fields.IncrementSeconds,
+				Multiplier:         tt.fields.Multiplier,
+				MaxIntervalSeconds: tt.fields.MaxIntervalSeconds,
 			}
 			if got := c.interval(tt.args.retries); got != tt.want {
 				t.Errorf("❌ SshKeepAliveConfig.interval() = %v, want %v", got, tt.want)
@@ -512,3 +576,124 @@ func Fuzz_keepAlive_interval(f *testing.F) {
 		}
 	})
 }
+
+func Test_keepAlive_backoff(t *testing.T) {
+	c := SshKeepAliveConfig{
+		IntervalSeconds:    4,
+		Multiplier:         2,
+		MaxIntervalSeconds: 30,
+		Jitter:             0.5,
+	}
+
+	if got := c.backoff(0); got != 4*time.Second {
+		t.Errorf("❌ SshKeepAliveConfig.backoff(0) = %v, want no jitter: %v", got, 4*time.Second)
+	}
+
+	for retries := 1; retries < 10; retries++ {
+		base := c.interval(retries)
+		lo := max(time.Duration(float64(base)*0.5), MinSshKeepAliveInterval)
+		hi := min(time.Duration(float64(base)*1.5), 30*time.Second)
+
+		for i := 0; i < 100; i++ {
+			got := c.backoff(retries)
+			if got < lo || got > hi {
+				t.Fatalf("❌ SshKeepAliveConfig.backoff(%d) = %v, want in [%v, %v]", retries, got, lo, hi)
+			}
+		}
+	}
+	t.Logf("✅ SshKeepAliveConfig.backoff() is jittered within bounds")
+}
+
+// blackHoleSshd is an SSH server that accepts connections and sessions but
+// never answers global requests, like a keep-alive sent over a black-holed
+// TCP connection.
+func blackHoleSshd(t *testing.T) string {
+	t.Helper()
+
+	hostKey, err := testsshd.GenerateHostKey()
+	if err != nil {
+		t.Fatalf("❌ GenerateHostKey() error = %v", err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("❌ net.Listen() error = %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sshConn, chans, _, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				defer sshConn.Close()
+				// never reply to the global requests (the 3rd return value).
+				for newChan := range chans {
+					_ = newChan.Reject(ssh.Prohibited, "black hole")
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func Test_keepAliveSshClient_stuckProbe(t *testing.T) {
+	addr := blackHoleSshd(t)
+
+	ka := keepAliveSshClient{
+		SshClientConfig: &SshClientConfig{
+			Addr:           addr,
+			User:           "root",
+			TimeoutSeconds: 5,
+			HostKeyCheck:   ignoreHostKeyCheck,
+			KeepAlive: SshKeepAliveConfig{
+				IntervalSeconds: 1,
+				TimeoutSeconds:  1,
+				MaxFailures:     2,
+			},
+		},
+	}
+	defer ka.Close()
+
+	first, err := ka.Client()
+	if err != nil {
+		t.Fatalf("❌ keepAliveSshClient.Client() error = %v", err)
+	}
+
+	// the first probe is sent at 1s and gets stuck until 2s.
+	time.Sleep(1500 * time.Millisecond)
+
+	start := time.Now()
+	client, err := ka.Client()
+	if err != nil {
+		t.Fatalf("❌ keepAliveSshClient.Client() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("❌ keepAliveSshClient.Client() waited %v behind a stuck probe", elapsed)
+	} else {
+		t.Logf("✅ keepAliveSshClient.Client() returned in %v while a probe is stuck", elapsed)
+	}
+	if client != first {
+		t.Errorf("❌ keepAliveSshClient.Client() replaced the client after 1 failure, want after MaxFailures=2")
+	}
+
+	// 2 timed out probes (at ~2s and ~4s) declare the client dead.
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if current := ka.current(); current != first {
+			t.Logf("✅ keepAliveSshClient dropped the black-holed client: %v", sshClientString(current))
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("❌ keepAliveSshClient kept the black-holed client after MaxFailures probes timed out")
+}
@@ -1,9 +1,11 @@
 package rexec
 
 import (
+	"context"
 	"io"
 	"log/slog"
 	"os"
+	"reflect"
 )
 
 // useDebugLogger enables the debug logger for rexec package.
@@ -20,6 +22,15 @@ const useDebugLogger = false
 // Callers can assign a different logger to this variable to enable logging:
 //
 //	rexec.Logger = slog.Default().With("pkg", "rexec")
+//
+// Logger is the fallback of the more specific loggers.
+// The logger used by an execution is resolved in the order:
+//
+//  1. the logger in the context (see WithLogger);
+//  2. the Logger field of the executor;
+//  3. this global Logger.
+//
+// The attributes in the context (see WithLogAttrs) are added to it.
 var Logger *slog.Logger
 
 func init() {
@@ -54,3 +65,81 @@ func debugLogger() *slog.Logger {
 
 	return slog.New(handler)
 }
+
+// context-scoped loggers
+
+type (
+	loggerCtxKey   struct{}
+	logAttrsCtxKey struct{}
+)
+
+// WithLogger returns a copy of ctx that carries the logger.
+// Executions with the returned context log to it, overriding the Logger
+// fields of the executors and the global Logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, logger)
+}
+
+// WithLogAttrs returns a copy of ctx that carries the attributes
+// (alternating key-value pairs or slog.Attr, as in slog.Logger.With),
+// in addition to the ones already in ctx.
+// Executions with the returned context add them to their logs:
+//
+//	ctx = rexec.WithLogAttrs(ctx, "requestID", id)
+func WithLogAttrs(ctx context.Context, args ...any) context.Context {
+	prev, _ := ctx.Value(logAttrsCtxKey{}).([]any)
+	attrs := make([]any, 0, len(prev)+len(args))
+	attrs = append(attrs, prev...)
+	attrs = append(attrs, args...)
+	return context.WithValue(ctx, logAttrsCtxKey{}, attrs)
+}
+
+// LoggerFrom returns the logger in ctx, or the global Logger if there is
+// none, with the attributes in ctx added.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	return loggerFor(ctx, nil)
+}
+
+// loggerFor resolves the logger for an execution with ctx by an executor
+// with the logger field: ctx logger > executor logger > global Logger.
+// The attributes in ctx are added to the resolved logger.
+// ctx and logger can be nil.
+func loggerFor(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerCtxKey{}).(*slog.Logger); ok && l != nil {
+			logger = l
+		}
+	}
+	logger = orGlobalLogger(logger)
+	if ctx != nil {
+		if attrs, ok := ctx.Value(logAttrsCtxKey{}).([]any); ok && len(attrs) > 0 {
+			logger = logger.With(attrs...)
+		}
+	}
+	return logger
+}
+
+// orGlobalLogger returns the logger, or the global Logger if it is nil.
+func orGlobalLogger(logger *slog.Logger) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	return Logger
+}
+
+// setDefaultLogger sets the logger to the nil Logger field of the executor
+// (a pointer to a struct), if it has one.
+func setDefaultLogger(executor any, logger *slog.Logger) {
+	if logger == nil {
+		return
+	}
+	v := reflect.ValueOf(executor)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	field := v.Elem().FieldByName("Logger")
+	if !field.IsValid() || !field.CanSet() || field.Type() != reflect.TypeOf(logger) || !field.IsNil() {
+		return
+	}
+	field.Set(reflect.ValueOf(logger))
+}
@@ -0,0 +1,131 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// bufferLogger returns a debug-level JSON logger writing to the returned buffer.
+func bufferLogger() (*slog.Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})), buf
+}
+
+func Test_loggerFor(t *testing.T) {
+	ctxLogger, ctxBuf := bufferLogger()
+	executorLogger, executorBuf := bufferLogger()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		logger *slog.Logger
+		want   *bytes.Buffer // nil for the global Logger
+	}{
+		{"global", context.Background(), nil, nil},
+		{"executor", context.Background(), executorLogger, executorBuf},
+		{"ctx", WithLogger(context.Background(), ctxLogger), nil, ctxBuf},
+		{"ctx over executor", WithLogger(context.Background(), ctxLogger), executorLogger, ctxBuf},
+		{"nil ctx logger", WithLogger(context.Background(), nil), executorLogger, executorBuf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctxBuf.Reset()
+			executorBuf.Reset()
+
+			loggerFor(tt.ctx, tt.logger).Info(tt.name)
+
+			for _, buf := range []*bytes.Buffer{ctxBuf, executorBuf} {
+				logged := strings.Contains(buf.String(), tt.name)
+				if logged != (buf == tt.want) {
+					t.Errorf("❌ logged to the wrong logger: ctx=%q executor=%q", ctxBuf.String(), executorBuf.String())
+					return
+				}
+			}
+			t.Logf("✅ logged to the expected logger")
+		})
+	}
+}
+
+func TestWithLogAttrs(t *testing.T) {
+	logger, buf := bufferLogger()
+
+	ctx := WithLogger(context.Background(), logger)
+	ctx = WithLogAttrs(ctx, "requestID", "req-1")
+	ctx = WithLogAttrs(ctx, slog.String("user", "alice"))
+
+	LoggerFrom(ctx).Info("hello")
+
+	out := buf.String()
+	if !strings.Contains(out, `"requestID":"req-1"`) || !strings.Contains(out, `"user":"alice"`) {
+		t.Errorf("❌ log attrs missing: %s", out)
+	} else {
+		t.Logf("✅ log: %s", out)
+	}
+}
+
+func TestLocalExecutor_Logger(t *testing.T) {
+	executorLogger, executorBuf := bufferLogger()
+	ctxLogger, ctxBuf := bufferLogger()
+
+	executor := &LocalExecutor{Logger: executorLogger}
+
+	if err := executor.Execute(context.Background(), &Command{Command: "true"}); err != nil {
+		t.Fatalf("❌ Execute() error = %v", err)
+	}
+	if !strings.Contains(executorBuf.String(), "rexec.LocalExecutor.Execute") {
+		t.Errorf("❌ executor logger got no logs: %q", executorBuf.String())
+	}
+	if !strings.Contains(executorBuf.String(), "rexec.runProc") {
+		t.Errorf("❌ executor logger is not threaded into runProc: %q", executorBuf.String())
+	}
+
+	executorBuf.Reset()
+	ctx := WithLogAttrs(WithLogger(context.Background(), ctxLogger), "requestID", "req-2")
+	if err := executor.Execute(ctx, &Command{Command: "true"}); err != nil {
+		t.Fatalf("❌ Execute() error = %v", err)
+	}
+	switch {
+	case executorBuf.Len() != 0:
+		t.Errorf("❌ executor logger used over the ctx logger: %q", executorBuf.String())
+	case !strings.Contains(ctxBuf.String(), `"requestID":"req-2"`):
+		t.Errorf("❌ ctx logger or attrs not used: %q", ctxBuf.String())
+	default:
+		t.Logf("✅ executor and ctx loggers are used")
+	}
+}
+
+func TestExecutorFactory_Logger(t *testing.T) {
+	logger, _ := bufferLogger()
+	own, _ := bufferLogger()
+
+	local := &LocalExecutor{}
+	policy := &PolicyExecutor{Logger: own}
+	f := ExecutorFactory{
+		Local: local,
+		Middlewares: []Middleware{
+			WithRetry(RetryPolicy{}),
+			func(next Executor) Executor { policy.Executor = next; return policy },
+		},
+		Logger: logger,
+	}
+	executor, err := f.Executor()
+	if err != nil {
+		t.Fatalf("❌ Executor() error = %v", err)
+	}
+	defer executor.Close()
+
+	retry, _ := executor.(*chainedExecutor).Executor.(*RetryExecutor)
+	switch {
+	case local.Logger != logger:
+		t.Errorf("❌ factory Logger is not set to the executor")
+	case retry == nil || retry.Logger != logger:
+		t.Errorf("❌ factory Logger is not set to the middleware: %#v", executor)
+	case policy.Logger != own:
+		t.Errorf("❌ factory Logger overrides the middleware's own Logger")
+	default:
+		t.Logf("✅ factory Logger is the default of executors and middlewares")
+	}
+}
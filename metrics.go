@@ -0,0 +1,461 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This file provides a dependency-free metrics collector for executions and
+// SSH connections, which renders in the Prometheus text exposition format.
+//
+// Typical usage:
+//
+//	metrics := rexec.NewMetrics()
+//	http.Handle("/metrics", metrics)
+//
+//	executor := rexec.Chain(
+//		&rexec.KeepAliveSshExecutor{Config: config, Metrics: metrics},
+//		rexec.WithMetrics(metrics),
+//	)
+
+// DefaultDurationBuckets are the upper bounds (in seconds) of the buckets of
+// the duration histograms of a Metrics created by NewMetrics.
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Execution outcomes, the "outcome" label of the execution metrics.
+const (
+	OutcomeSuccess = "success" // the command exited with status 0
+	OutcomeFailure = "failure" // the command exited with a non-zero status
+	OutcomeError   = "error"   // the command could not be run to the end
+)
+
+// Metrics collects metrics of executions and SSH connections:
+//
+//	rexec_executions_total{kind,target,outcome}             counter
+//	rexec_execution_duration_seconds{kind,target,outcome}   histogram
+//	rexec_executions_in_flight{kind,target}                 gauge
+//	rexec_output_bytes_total{kind,target,stream}            counter
+//	rexec_ssh_dial_duration_seconds{addr,outcome}           histogram
+//	rexec_ssh_keepalive_failures_total{addr}                counter
+//	rexec_ssh_redials_total{addr,outcome}                   counter
+//
+// Executions are collected by MetricsExecutor (see WithMetrics), and the SSH
+// connections by the SSH executors with the Metrics field set.
+//
+// Metrics is an http.Handler that serves the metrics in the Prometheus text
+// exposition format. It is safe for concurrent use.
+// The methods of a nil *Metrics are no-ops.
+type Metrics struct {
+	mu sync.Mutex
+
+	executions     *metricVec
+	durations      *metricVec
+	inFlight       *metricVec
+	outputBytes    *metricVec
+	sshDials       *metricVec
+	keepAliveFails *metricVec
+	sshRedials     *metricVec
+}
+
+var _ http.Handler = (*Metrics)(nil)
+
+// NewMetrics returns an empty Metrics with the DefaultDurationBuckets.
+func NewMetrics() *Metrics {
+	return NewMetricsWithBuckets(DefaultDurationBuckets)
+}
+
+// NewMetricsWithBuckets returns an empty Metrics with the given upper bounds
+// (in seconds) of the buckets of the duration histograms.
+func NewMetricsWithBuckets(buckets []float64) *Metrics {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Metrics{
+		executions: newMetricVec("rexec_executions_total", "counter",
+			"Number of executed commands.", "kind", "target", "outcome"),
+		durations: newHistogramVec("rexec_execution_duration_seconds", buckets,
+			"Duration of command executions in seconds.", "kind", "target", "outcome"),
+		inFlight: newMetricVec("rexec_executions_in_flight", "gauge",
+			"Number of commands being executed.", "kind", "target"),
+		outputBytes: newMetricVec("rexec_output_bytes_total", "counter",
+			"Bytes written by commands to stdout and stderr.", "kind", "target", "stream"),
+		sshDials: newHistogramVec("rexec_ssh_dial_duration_seconds", buckets,
+			"Duration of SSH dials in seconds.", "addr", "outcome"),
+		keepAliveFails: newMetricVec("rexec_ssh_keepalive_failures_total", "counter",
+			"Number of failed SSH keep-alive probes.", "addr"),
+		sshRedials: newMetricVec("rexec_ssh_redials_total", "counter",
+			"Number of SSH redials of keep-alive connections.", "addr", "outcome"),
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format to w.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+
+	m.mu.Lock()
+	for _, vec := range []*metricVec{
+		m.executions, m.durations, m.inFlight, m.outputBytes,
+		m.sshDials, m.keepAliveFails, m.sshRedials,
+	} {
+		vec.write(&buf)
+	}
+	m.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// observeExecution records a finished execution.
+func (m *Metrics) observeExecution(target TargetInfo, outcome string, duration time.Duration, stdout, stderr int64) {
+	if m == nil {
+		return
+	}
+	kind, name := target.Kind, target.Name()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.executions.get(kind, name, outcome).value++
+	m.durations.observe(duration.Seconds(), kind, name, outcome)
+	m.outputBytes.get(kind, name, "stdout").value += float64(stdout)
+	m.outputBytes.get(kind, name, "stderr").value += float64(stderr)
+}
+
+// addInFlight adds delta to the in-flight executions on the target.
+func (m *Metrics) addInFlight(target TargetInfo, delta float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight.get(target.Kind, target.Name()).value += delta
+}
+
+// observeSshDial records an SSH dial to addr started at start.
+func (m *Metrics) observeSshDial(addr string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sshDials.observe(time.Since(start).Seconds(), addr, errorOutcome(err))
+}
+
+// incKeepAliveFailures counts a failed keep-alive probe to addr.
+func (m *Metrics) incKeepAliveFailures(addr string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keepAliveFails.get(addr).value++
+}
+
+// incSshRedials counts a redial of a keep-alive connection to addr.
+func (m *Metrics) incSshRedials(addr string, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sshRedials.get(addr, errorOutcome(err)).value++
+}
+
+// errorOutcome is OutcomeSuccess if err is nil, or OutcomeError otherwise.
+func errorOutcome(err error) string {
+	if err == nil {
+		return OutcomeSuccess
+	}
+	return OutcomeError
+}
+
+// executionOutcome classifies the result of an execution.
+func executionOutcome(cmd *Command, err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case cmd != nil && cmd.Status > 0: // executors set the exit status on exit errors
+		return OutcomeFailure
+	default:
+		return OutcomeError
+	}
+}
+
+// MetricsExecutor is an Executor decorator that collects the metrics of the
+// executions of the underlying Executor: the count by outcome, the duration,
+// the in-flight executions, and the bytes of the stdout and stderr.
+// The kind and target labels are from TargetOf(Executor).
+type MetricsExecutor struct {
+	// Executor is the underlying executor.
+	Executor Executor
+	// Metrics receives the metrics.
+	Metrics *Metrics
+
+	// Logger, if not nil, overrides the global Logger for this executor.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+}
+
+var (
+	_ Executor      = (*MetricsExecutor)(nil)
+	_ ExecuteCloser = (*MetricsExecutor)(nil)
+	_ Targeter      = (*MetricsExecutor)(nil)
+)
+
+// Execute the command with the underlying executor and collect the metrics.
+func (e *MetricsExecutor) Execute(ctx context.Context, cmd *Command) error {
+	logger := loggerFor(ctx, e.Logger).With("field", "rexec.MetricsExecutor.Execute", "cmd", cmd)
+
+	if e.Executor == nil {
+		logger.Warn("reject execution: nil underlying executor")
+		return fmt.Errorf("%w: nil underlying executor of MetricsExecutor", ErrInternalError)
+	}
+
+	if cmd == nil {
+		logger.Warn("reject execution: nil command")
+		return ErrNilCommand
+	}
+
+	// the stdio of a running command must not be replaced: the executor
+	// would reject it anyway.
+	if cmd.started.Load() {
+		logger.Warn("reject execution: command already started")
+		return ErrStartedCommand
+	}
+
+	target := TargetOf(e.Executor)
+
+	// count the outputs.
+	cmd.setDefaultStdio()
+	stdout := &countingWriter{w: cmd.Stdout}
+	stderr := &countingWriter{w: cmd.Stderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	e.Metrics.addInFlight(target, 1)
+	start := time.Now()
+
+	err := e.Executor.Execute(ctx, cmd)
+
+	duration := time.Since(start)
+	e.Metrics.addInFlight(target, -1)
+
+	cmd.Stdout, cmd.Stderr = stdout.w, stderr.w
+
+	outcome := executionOutcome(cmd, err)
+	e.Metrics.observeExecution(target, outcome, duration, stdout.n.Load(), stderr.n.Load())
+
+	logger.Debug("execution metrics collected", "outcome", outcome, "duration", duration)
+	return err
+}
+
+func (e *MetricsExecutor) TargetInfo() TargetInfo { return TargetOf(e.Executor) }
+
+// Close closes the underlying Executor if it is an ExecuteCloser.
+func (e *MetricsExecutor) Close() error {
+	if closer, ok := e.Executor.(ExecuteCloser); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (e *MetricsExecutor) Validate() error {
+	if e == nil {
+		return ErrNilExecutor
+	}
+	if e.Executor == nil {
+		return fmt.Errorf("%w: underlying executor is nil", ErrExecutorBadConfig)
+	}
+	if v, ok := e.Executor.(ExecuteCloser); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// WithMetrics returns a Middleware that collects the metrics of every
+// execution to m. See MetricsExecutor.
+func WithMetrics(m *Metrics) Middleware {
+	return func(next Executor) Executor {
+		return &MetricsExecutor{Executor: next, Metrics: m}
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// metricVec is a metric family: the samples of a metric with different
+// label values.
+type metricVec struct {
+	name   string
+	typ    string // "counter", "gauge" or "histogram"
+	help   string
+	labels []string
+
+	buckets []float64 // upper bounds of the buckets of a histogram, sorted
+
+	series map[string]*metricSeries // key: label values joined by "\xff"
+}
+
+// metricSeries is a sample of a counter or gauge (value),
+// or of a histogram (counts, sum and count).
+type metricSeries struct {
+	labelValues []string
+
+	value float64
+
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newMetricVec(name, typ, help string, labels ...string) *metricVec {
+	return &metricVec{
+		name:   name,
+		typ:    typ,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*metricSeries),
+	}
+}
+
+func newHistogramVec(name string, buckets []float64, help string, labels ...string) *metricVec {
+	v := newMetricVec(name, "histogram", help, labels...)
+	v.buckets = buckets
+	return v
+}
+
+// get returns the series with the label values, creating it if needed.
+func (v *metricVec) get(labelValues ...string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		v.series[key] = s
+	}
+	return s
+}
+
+// observe adds the value to the histogram series with the label values.
+func (v *metricVec) observe(value float64, labelValues ...string) {
+	s := v.get(labelValues...)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(v.buckets))
+	}
+	for i, le := range v.buckets {
+		if value <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// write writes the metric family in the Prometheus text exposition format.
+// Nothing is written if there are no samples.
+func (v *metricVec) write(w *bytes.Buffer) {
+	if len(v.series) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := v.series[k]
+		labels := v.formatLabels(s.labelValues)
+		if v.typ != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", v.name, labels, formatFloat(s.value))
+			continue
+		}
+		v.writeHistogram(w, s)
+	}
+}
+
+// writeHistogram writes the cumulative buckets, sum and count of a
+// histogram series.
+func (v *metricVec) writeHistogram(w *bytes.Buffer, s *metricSeries) {
+	cumulative := uint64(0)
+	for i, le := range v.buckets {
+		cumulative += s.counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name,
+			v.formatLabels(s.labelValues, "le", formatFloat(le)), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", v.name,
+		v.formatLabels(s.labelValues, "le", "+Inf"), s.count)
+
+	labels := v.formatLabels(s.labelValues)
+	fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labels, formatFloat(s.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", v.name, labels, s.count)
+}
+
+// formatLabels formats the label pairs: {name="value",...}.
+// extra are additional name-value pairs (e.g. "le", "0.5").
+func (v *metricVec) formatLabels(values []string, extra ...string) string {
+	if len(v.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(v.labels)+len(extra)/2)
+	for i, name := range v.labels {
+		pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabelValue escapes the backslashes, double-quotes and line feeds.
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat formats the float as a Prometheus sample value.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,149 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExecutor(t *testing.T) {
+	metrics := NewMetricsWithBuckets([]float64{60, 0.001})
+	executor := Chain(&LocalExecutor{}, WithMetrics(metrics))
+	defer executor.Close()
+
+	for _, cmd := range []*Command{
+		{Command: "echo hello"}, // success, 6 bytes
+		{Command: "echo hi"},    // success, 3 bytes
+		{Command: "false"},      // failure
+		{Command: ""},           // error
+	} {
+		_ = executor.Execute(context.Background(), cmd)
+	}
+
+	var sb strings.Builder
+	if _, err := metrics.WriteTo(&sb); err != nil {
+		t.Fatalf("❌ WriteTo() error = %v", err)
+	}
+	out := sb.String()
+
+	wants := []string{
+		"# TYPE rexec_executions_total counter",
+		`rexec_executions_total{kind="Local",target="Local",outcome="success"} 2`,
+		`rexec_executions_total{kind="Local",target="Local",outcome="failure"} 1`,
+		`rexec_executions_total{kind="Local",target="Local",outcome="error"} 1`,
+		"# TYPE rexec_execution_duration_seconds histogram",
+		`rexec_execution_duration_seconds_bucket{kind="Local",target="Local",outcome="success",le="60"} 2`,
+		`rexec_execution_duration_seconds_bucket{kind="Local",target="Local",outcome="success",le="+Inf"} 2`,
+		`rexec_execution_duration_seconds_count{kind="Local",target="Local",outcome="success"} 2`,
+		`rexec_executions_in_flight{kind="Local",target="Local"} 0`,
+		`rexec_output_bytes_total{kind="Local",target="Local",stream="stdout"} 9`,
+		`rexec_output_bytes_total{kind="Local",target="Local",stream="stderr"} 0`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("❌ metrics missing %q", want)
+		}
+	}
+	if strings.Index(out, `le="0.001"`) > strings.Index(out, `le="60"`) {
+		t.Errorf("❌ buckets are not sorted")
+	}
+	if strings.Contains(out, "rexec_ssh_") {
+		t.Errorf("❌ empty metric families should not be rendered")
+	}
+	if !t.Failed() {
+		t.Logf("✅ metrics:\n%s", out)
+	}
+}
+
+func TestMetricsExecutor_started(t *testing.T) {
+	metrics := NewMetrics()
+	executor := &MetricsExecutor{Executor: &LocalExecutor{}, Metrics: metrics}
+
+	stdout := &bytes.Buffer{}
+	cmd := &Command{Command: "echo hello", Stdout: stdout}
+	cmd.started.Store(true) // running elsewhere
+	if err := executor.Execute(context.Background(), cmd); !errors.Is(err, ErrStartedCommand) {
+		t.Errorf("❌ Execute() error = %v, want %v", err, ErrStartedCommand)
+	}
+	if cmd.Stdout != stdout || cmd.Stderr != nil {
+		t.Errorf("❌ Execute() replaced the stdio of the started command")
+	}
+	t.Logf("✅ started command rejected untouched")
+}
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.incSshRedials("a\"b\\c\n:22", nil)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	switch {
+	case !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"):
+		t.Errorf("❌ Content-Type = %q", rec.Header().Get("Content-Type"))
+	case !strings.Contains(body, `rexec_ssh_redials_total{addr="a\"b\\c\n:22",outcome="success"} 1`):
+		t.Errorf("❌ label value not escaped: %s", body)
+	default:
+		t.Logf("✅ body:\n%s", body)
+	}
+}
+
+func TestMetrics_nil(t *testing.T) {
+	var metrics *Metrics
+	executor := &MetricsExecutor{Executor: &LocalExecutor{}}
+	if err := executor.Execute(context.Background(), &Command{Command: "true"}); err != nil {
+		t.Errorf("❌ Execute() with nil Metrics error = %v", err)
+	}
+	metrics.incKeepAliveFailures("x")
+	if n, err := metrics.WriteTo(&strings.Builder{}); n != 0 || err != nil {
+		t.Errorf("❌ nil Metrics WriteTo() = %d, %v", n, err)
+	}
+}
+
+func TestSshExecutors_Metrics(t *testing.T) {
+	metrics := NewMetrics()
+	config := &SshClientConfig{
+		Addr:           "localhost:24622",
+		User:           "root",
+		Auth:           []SshAuth{{Password: "root"}},
+		TimeoutSeconds: 5,
+		HostKeyCheck:   ignoreHostKeyCheck,
+	}
+	bad := &SshClientConfig{
+		Addr:           "localhost:24622",
+		User:           "root",
+		Auth:           []SshAuth{{Password: "wrong"}},
+		TimeoutSeconds: 5,
+		HostKeyCheck:   ignoreHostKeyCheck,
+	}
+
+	executors := []ExecuteCloser{
+		&ImmediateSshExecutor{Config: config, Metrics: metrics},
+		&ImmediateSshExecutor{Config: bad, Metrics: metrics},
+		&KeepAliveSshExecutor{Config: config, Metrics: metrics},
+	}
+	for _, executor := range executors {
+		_ = executor.Execute(context.Background(), &Command{Command: "true"})
+		_ = executor.Close()
+	}
+
+	var sb strings.Builder
+	_, _ = metrics.WriteTo(&sb)
+	out := sb.String()
+
+	for _, want := range []string{
+		`rexec_ssh_dial_duration_seconds_count{addr="localhost:24622",outcome="success"} 2`,
+		`rexec_ssh_dial_duration_seconds_count{addr="localhost:24622",outcome="error"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("❌ metrics missing %q:\n%s", want, out)
+		}
+	}
+	if !t.Failed() {
+		t.Logf("✅ SSH dials are measured")
+	}
+}
@@ -0,0 +1,111 @@
+package rexec
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// This file provides the building blocks to decorate an Executor with
+// cross-cutting behaviours (logging, timeouts, retries, auditing, ...):
+// ExecutorFunc, Middleware and Chain.
+
+// ExecutorFunc is an adapter to allow the use of ordinary functions as
+// Executors. If f is a function with the appropriate signature,
+// ExecutorFunc(f) is an Executor that calls f.
+type ExecutorFunc func(ctx context.Context, cmd *Command) error
+
+var _ Executor = (ExecutorFunc)(nil)
+
+// Execute calls f(ctx, cmd).
+func (f ExecutorFunc) Execute(ctx context.Context, cmd *Command) error {
+	return f(ctx, cmd)
+}
+
+// Middleware decorates an Executor: it returns a new Executor that does
+// something before and/or after calling next.Execute.
+//
+// A typical middleware looks like:
+//
+//	func Timeout(d time.Duration) rexec.Middleware {
+//		return func(next rexec.Executor) rexec.Executor {
+//			return rexec.ExecutorFunc(func(ctx context.Context, cmd *rexec.Command) error {
+//				ctx, cancel := context.WithTimeout(ctx, d)
+//				defer cancel()
+//				return next.Execute(ctx, cmd)
+//			})
+//		}
+//	}
+type Middleware func(next Executor) Executor
+
+// Chain decorates the executor with the middlewares.
+//
+// The first middleware is the outermost one:
+//
+//	Chain(e, m1, m2, m3).Execute(ctx, cmd)
+//
+// is equivalent to
+//
+//	m1(m2(m3(e))).Execute(ctx, cmd)
+//
+// The returned ExecuteCloser closes the given executor (if it is an
+// ExecuteCloser) on Close, and validates it on Validate.
+// Nil middlewares are skipped.
+func Chain(executor Executor, middlewares ...Middleware) ExecuteCloser {
+	return chain(executor, nil, middlewares...)
+}
+
+// chain is Chain that also sets the logger as the default Logger of
+// each decorating executor (see setDefaultLogger).
+func chain(executor Executor, logger *slog.Logger, middlewares ...Middleware) ExecuteCloser {
+	chained := executor
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] == nil {
+			continue
+		}
+		chained = middlewares[i](chained)
+		setDefaultLogger(chained, logger)
+	}
+	return &chainedExecutor{
+		Executor: chained,
+		base:     executor,
+	}
+}
+
+// chainedExecutor is an Executor decorated by middlewares.
+type chainedExecutor struct {
+	Executor // the outermost decorated executor
+
+	base Executor // the executor being decorated
+}
+
+var _ ExecuteCloser = (*chainedExecutor)(nil)
+
+// Close closes the base executor.
+func (c *chainedExecutor) Close() error {
+	if closer, ok := c.base.(ExecuteCloser); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *chainedExecutor) Validate() error {
+	if c == nil {
+		return ErrNilExecutor
+	}
+	if c.Executor == nil || c.base == nil {
+		return fmt.Errorf("%w: chained executor is nil", ErrExecutorBadConfig)
+	}
+	if v, ok := c.base.(ExecuteCloser); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// WithRetry returns a Middleware that retries the execution according to the
+// policy. See RetryExecutor.
+func WithRetry(policy RetryPolicy) Middleware {
+	return func(next Executor) Executor {
+		return &RetryExecutor{Executor: next, Policy: policy}
+	}
+}
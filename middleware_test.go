@@ -0,0 +1,136 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closeRecorder is an ExecuteCloser that records whether it is closed.
+type closeRecorder struct {
+	LocalExecutor
+	closed int
+}
+
+func (e *closeRecorder) Close() error {
+	e.closed++
+	return nil
+}
+
+// tracingMiddleware appends "<name>:before" and "<name>:after" to trace
+// around the execution.
+func tracingMiddleware(name string, trace *[]string) Middleware {
+	return func(next Executor) Executor {
+		return ExecutorFunc(func(ctx context.Context, cmd *Command) error {
+			*trace = append(*trace, name+":before")
+			err := next.Execute(ctx, cmd)
+			*trace = append(*trace, name+":after")
+			return err
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+
+	base := &closeRecorder{}
+	chained := Chain(base,
+		tracingMiddleware("m1", &trace),
+		nil, // skipped
+		tracingMiddleware("m2", &trace),
+		func(next Executor) Executor {
+			return ExecutorFunc(func(ctx context.Context, cmd *Command) error {
+				trace = append(trace, "m3")
+				return next.Execute(ctx, cmd)
+			})
+		},
+	)
+
+	if err := chained.Validate(); err != nil {
+		t.Errorf("❌ Chain().Validate() error = %v", err)
+	}
+
+	cmd := &Command{Command: "echo hello"}
+	if err := chained.Execute(context.Background(), cmd); err != nil {
+		t.Errorf("❌ Chain().Execute() error = %v", err)
+	}
+
+	want := "m1:before m2:before m3 m2:after m1:after"
+	if got := strings.Join(trace, " "); got != want {
+		t.Errorf("❌ Chain().Execute() trace = %q, want %q", got, want)
+	}
+	if cmd.Status != 0 {
+		t.Errorf("❌ Chain().Execute() status = %d, want 0", cmd.Status)
+	}
+
+	if err := chained.Close(); err != nil || base.closed != 1 {
+		t.Errorf("❌ Chain().Close() error = %v, closed = %d, want closing the base executor once", err, base.closed)
+	} else {
+		t.Logf("✅ Chain() ok: %v", trace)
+	}
+}
+
+func TestChain_nonCloser(t *testing.T) {
+	called := false
+	chained := Chain(ExecutorFunc(func(ctx context.Context, cmd *Command) error {
+		called = true
+		return nil
+	}))
+
+	if err := chained.Execute(context.Background(), &Command{Command: "x"}); err != nil || !called {
+		t.Errorf("❌ Chain(ExecutorFunc).Execute() error = %v, called = %v", err, called)
+	}
+	if err := chained.Close(); err != nil {
+		t.Errorf("❌ Chain(ExecutorFunc).Close() error = %v", err)
+	}
+	if err := Chain(nil).Validate(); !errors.Is(err, ErrExecutorBadConfig) {
+		t.Errorf("❌ Chain(nil).Validate() error = %v, want %v", err, ErrExecutorBadConfig)
+	}
+}
+
+func TestExecutorFactory_Executor_middlewares(t *testing.T) {
+	var trace []string
+
+	f := ExecutorFactory{
+		Local:       &LocalExecutor{},
+		Middlewares: []Middleware{tracingMiddleware("m", &trace)},
+	}
+	executor, err := f.Executor()
+	if err != nil {
+		t.Fatalf("❌ ExecutorFactory.Executor() error = %v", err)
+	}
+	defer executor.Close()
+
+	if err := executor.Execute(context.Background(), &Command{Command: "true"}); err != nil {
+		t.Errorf("❌ Execute() error = %v", err)
+	}
+	if len(trace) != 2 {
+		t.Errorf("❌ ExecutorFactory.Executor() middlewares are not applied: %v", trace)
+	} else {
+		t.Logf("✅ ExecutorFactory.Executor() middlewares applied: %v", trace)
+	}
+}
+
+func ExampleChain() {
+	timeout := func(d time.Duration) Middleware {
+		return func(next Executor) Executor {
+			return ExecutorFunc(func(ctx context.Context, cmd *Command) error {
+				ctx, cancel := context.WithTimeout(ctx, d)
+				defer cancel()
+				return next.Execute(ctx, cmd)
+			})
+		}
+	}
+
+	executor := Chain(&LocalExecutor{}, timeout(100*time.Millisecond), WithRetry(RetryPolicy{MaxAttempts: 2}))
+	defer executor.Close()
+
+	err := executor.Execute(context.Background(), &Command{Command: "sleep 1"})
+	fmt.Println(errors.Is(err, context.DeadlineExceeded))
+
+	// Output:
+	// true
+}
@@ -0,0 +1,512 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// This file implements a command policy engine: allow/deny rules matched
+// against the program, arguments, workdir and env of a Command, and a
+// PolicyExecutor that enforces a Policy before executing commands.
+//
+// CommandDangerous and the other substring checks of Command.Validate() are
+// still applied by the executors; a Policy is an additional, explicit layer.
+
+// PolicyAction is what to do with a command matched by a PolicyRule.
+type PolicyAction string
+
+const (
+	PolicyAllow PolicyAction = "allow"
+	PolicyDeny  PolicyAction = "deny"
+)
+
+// PolicyRule allows or denies the commands it matches.
+//
+// A rule matches a command if ALL of its non-empty criteria match.
+// Each criterion matches if ANY of its patterns matches.
+// A rule without any criterion matches every command.
+type PolicyRule struct {
+	// Name identifies the rule in denial errors. Optional.
+	Name string
+	// Action to take if the rule matches: "allow" or "deny".
+	Action PolicyAction
+
+	// Programs are glob patterns (path.Match) matched against the program
+	// (the first word of the command), e.g. "ls", "/usr/bin/*", "systemctl".
+	// Notice that "*" does not match "/": "ls" matches "ls" looked up in the
+	// PATH, but not "/bin/ls" or "./ls", which need patterns like "/bin/ls"
+	// or "/*/ls".
+	Programs []string
+	// Args are glob patterns (path.Match) matched against each argument
+	// (the words after the program). The criterion matches if any argument
+	// matches any pattern, e.g. "-rf", "--force*".
+	Args []string
+	// ArgsRegex are regular expressions matched against all the arguments
+	// joined by a space, e.g. `^(status|log)( |$)`.
+	// Anchor them (^...$) to constrain the whole argument list.
+	ArgsRegex []string
+	// Workdirs are path prefixes matched against the (cleaned) Workdir,
+	// e.g. "/srv" matches "/srv" and "/srv/app", but not "/srvx" or "".
+	Workdirs []string
+	// EnvKeys are glob patterns (path.Match) matched against each key of the
+	// Env, e.g. "LD_*".
+	EnvKeys []string
+}
+
+// Policy is an ordered set of PolicyRules.
+//
+// To check a command on a target, the rules in Targets whose key matches the
+// target name are evaluated first, then the global Rules. The first matching
+// rule decides. If no rule matches, the Default action applies, which is
+// "deny" if empty.
+//
+// Since the executors may run commands through a shell (ShellExecutor, SSH),
+// a command containing shell operators (see ShellOperators) could chain
+// programs that are not checked by the rules. Such commands are denied
+// unless AllowShellOperators is true.
+//
+// Policy is designed to be loaded from JSON or YAML (see ParsePolicy):
+//
+//	{
+//	  "Default": "deny",
+//	  "Rules": [
+//	    {"Name": "no-rm", "Action": "deny", "Programs": ["rm"]},
+//	    {"Name": "read-only", "Action": "allow", "Programs": ["ls", "cat", "uptime"]}
+//	  ],
+//	  "Targets": {
+//	    "10.0.0.*:22": [{"Name": "web-restart", "Action": "allow", "Programs": ["systemctl"], "ArgsRegex": ["^restart nginx$"]}]
+//	  }
+//	}
+type Policy struct {
+	// Default is the action if no rule matches. Empty means "deny".
+	Default PolicyAction
+	// AllowShellOperators allows commands containing ShellOperators.
+	AllowShellOperators bool
+	// Rules are the global rules for all targets.
+	Rules []PolicyRule
+	// Targets are the rules for specific targets, keyed by glob patterns
+	// (path.Match) of the target name: the Addr of a remote target, or the
+	// Kind of a local one (see TargetInfo.Name).
+	Targets map[string][]PolicyRule
+}
+
+// ShellOperators are the substrings that make a shell run more than the
+// program of a command: ";", "&", "|", "`", "$(", ">", "<", "\n".
+var ShellOperators = []string{";", "&", "|", "`", "$(", ">", "<", "\n", "\r"}
+
+// programExpansions are the characters that make a shell expand the program
+// of a command into another one (e.g. "r${X}m", "r*m", "/bin/r[m]"): the
+// rules could not match the program that would run.
+const programExpansions = "$*?[{~"
+
+// ParsePolicy decodes a Policy from JSON or YAML, and validates it.
+func ParsePolicy(data []byte) (*Policy, error) {
+	p := new(Policy)
+	if err := decodeConfig(data, p); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPolicy, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadPolicyFile reads and parses a JSON or YAML policy file.
+func LoadPolicyFile(name string) (*Policy, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPolicy, err)
+	}
+	return ParsePolicy(data)
+}
+
+// Validate checks that the actions are known and the patterns are
+// well-formed.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return fmt.Errorf("%w: nil policy", ErrBadPolicy)
+	}
+	if err := validatePolicyAction(p.Default, true); err != nil {
+		return fmt.Errorf("%w: Default: %w", ErrBadPolicy, err)
+	}
+	for i, r := range p.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("%w: Rules[%d]: %w", ErrBadPolicy, i, err)
+		}
+	}
+	for target, rules := range p.Targets {
+		if _, err := path.Match(target, ""); err != nil {
+			return fmt.Errorf("%w: Targets[%q]: %w", ErrBadPolicy, target, err)
+		}
+		for i, r := range rules {
+			if err := r.validate(); err != nil {
+				return fmt.Errorf("%w: Targets[%q][%d]: %w", ErrBadPolicy, target, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validatePolicyAction(a PolicyAction, allowEmpty bool) error {
+	switch {
+	case a == PolicyAllow, a == PolicyDeny:
+		return nil
+	case a == "" && allowEmpty:
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q, want %q or %q", a, PolicyAllow, PolicyDeny)
+	}
+}
+
+// validate checks the action and the patterns of the rule.
+func (r PolicyRule) validate() error {
+	if err := validatePolicyAction(r.Action, false); err != nil {
+		return err
+	}
+	for _, globs := range [][]string{r.Programs, r.Args, r.EnvKeys} {
+		for _, g := range globs {
+			if _, err := path.Match(g, ""); err != nil {
+				return fmt.Errorf("bad pattern %q: %w", g, err)
+			}
+		}
+	}
+	for _, re := range r.ArgsRegex {
+		if _, err := compilePolicyRegex(re); err != nil {
+			return fmt.Errorf("bad regex %q: %w", re, err)
+		}
+	}
+	return nil
+}
+
+// Check checks the command against the policy on the given target
+// (see TargetInfo.Name).
+//
+// It returns nil if the command is allowed, or a *PolicyViolation
+// (errors.Is(err, ErrPolicyDenied)) naming the rule that denied it.
+func (p *Policy) Check(cmd *Command, target string) error {
+	if cmd == nil {
+		return ErrNilCommand
+	}
+	if p == nil {
+		return &PolicyViolation{Rule: "<nil policy>", Target: target, Command: cmd.Command, Reason: "no policy"}
+	}
+
+	deny := func(rule, reason string) error {
+		return &PolicyViolation{Rule: rule, Target: target, Command: cmd.Command, Reason: reason}
+	}
+
+	if !p.AllowShellOperators {
+		if d, op := containsDangerous(cmd.Command, ShellOperators); d {
+			return deny("<shell-operators>", fmt.Sprintf("contains shell operator %q", op))
+		}
+	}
+
+	argv, err := cmdSlice(cmd.Command)
+	if err != nil || len(argv) == 0 {
+		return deny("<unparsable>", fmt.Sprintf("cannot parse command: %v", err))
+	}
+	if i := strings.IndexAny(argv[0], programExpansions); i >= 0 {
+		return deny("<unparsable>", fmt.Sprintf("program %q contains shell expansion %q", argv[0], argv[0][i]))
+	}
+
+	for _, r := range p.rulesFor(target) {
+		matched, err := r.rule.match(cmd, argv)
+		if err != nil {
+			return deny(r.name, fmt.Sprintf("bad rule: %v", err))
+		}
+		if !matched {
+			continue
+		}
+		if r.rule.Action == PolicyAllow {
+			return nil
+		}
+		return deny(r.name, "matched deny rule")
+	}
+
+	if p.Default == PolicyAllow {
+		return nil
+	}
+	return deny("<default>", "no rule allows it")
+}
+
+// namedPolicyRule is a PolicyRule with the name to report.
+type namedPolicyRule struct {
+	name string
+	rule PolicyRule
+}
+
+// rulesFor returns the rules to evaluate for the target, in order:
+// rules of the exactly matching target key, rules of the glob matching
+// target keys (sorted by key), and then the global rules.
+func (p *Policy) rulesFor(target string) []namedPolicyRule {
+	var rules []namedPolicyRule
+
+	appendRules := func(prefix string, rs []PolicyRule) {
+		for i, r := range rs {
+			name := r.Name
+			if name == "" {
+				name = fmt.Sprintf("%s[%d]", prefix, i)
+			}
+			rules = append(rules, namedPolicyRule{name: name, rule: r})
+		}
+	}
+
+	if rs, ok := p.Targets[target]; ok {
+		appendRules(fmt.Sprintf("Targets[%q]", target), rs)
+	}
+
+	keys := make([]string, 0, len(p.Targets))
+	for k := range p.Targets {
+		if k == target {
+			continue
+		}
+		if ok, _ := path.Match(k, target); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		appendRules(fmt.Sprintf("Targets[%q]", k), p.Targets[k])
+	}
+
+	appendRules("Rules", p.Rules)
+
+	return rules
+}
+
+// match reports whether the rule matches the command with the parsed argv.
+func (r PolicyRule) match(cmd *Command, argv []string) (bool, error) {
+	program, args := argv[0], argv[1:]
+
+	if len(r.Programs) > 0 && !anyGlobMatch(r.Programs, program) {
+		return false, nil
+	}
+
+	if len(r.Args) > 0 {
+		matched := false
+		for _, arg := range args {
+			if anyGlobMatch(r.Args, arg) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	if len(r.ArgsRegex) > 0 {
+		joined := strings.Join(args, " ")
+		matched := false
+		for _, expr := range r.ArgsRegex {
+			re, err := compilePolicyRegex(expr)
+			if err != nil {
+				return false, err
+			}
+			if re.MatchString(joined) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	if len(r.Workdirs) > 0 {
+		if cmd.Workdir == "" {
+			return false, nil
+		}
+		workdir := path.Clean(cmd.Workdir)
+		matched := false
+		for _, prefix := range r.Workdirs {
+			if isPathPrefix(path.Clean(prefix), workdir) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	if len(r.EnvKeys) > 0 {
+		matched := false
+		for k := range cmd.Env {
+			if anyGlobMatch(r.EnvKeys, k) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// anyGlobMatch reports whether s matches any of the glob patterns.
+func anyGlobMatch(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isPathPrefix reports whether the cleaned path p is prefix itself or
+// under the directory prefix.
+func isPathPrefix(prefix, p string) bool {
+	if prefix == "/" || prefix == p {
+		return true
+	}
+	return strings.HasPrefix(p, prefix+"/")
+}
+
+// policyRegexCache caches the compiled regexps of PolicyRule.ArgsRegex.
+var policyRegexCache sync.Map // string -> *regexp.Regexp
+
+func compilePolicyRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := policyRegexCache.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	policyRegexCache.Store(expr, re)
+	return re, nil
+}
+
+// PolicyViolation is the error of a command denied by a Policy.
+type PolicyViolation struct {
+	Rule    string // name of the rule that denied the command
+	Target  string // the target name the command was checked against
+	Command string // the denied command
+	Reason  string // why the rule denied the command
+}
+
+func (e *PolicyViolation) Error() string {
+	return fmt.Sprintf("%v: command %q on target %q denied by rule %q: %s",
+		ErrPolicyDenied, e.Command, e.Target, e.Rule, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrPolicyDenied) true for a *PolicyViolation.
+func (e *PolicyViolation) Is(target error) bool {
+	return target == ErrPolicyDenied
+}
+
+// PolicyExecutor is an Executor decorator that checks each command against
+// the Policy, and executes only the allowed ones with the underlying
+// Executor.
+type PolicyExecutor struct {
+	// Executor is the underlying executor to run allowed commands.
+	Executor Executor
+	// Policy to enforce.
+	Policy *Policy
+	// Target is the target name to select the per-target rules of the
+	// Policy. If empty, TargetOf(Executor).Name() is used.
+	Target string
+
+	// Logger, if not nil, overrides the global Logger for this executor.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+}
+
+var (
+	_ Executor      = (*PolicyExecutor)(nil)
+	_ ExecuteCloser = (*PolicyExecutor)(nil)
+	_ Targeter      = (*PolicyExecutor)(nil)
+)
+
+// Execute the command if the policy allows it.
+// Otherwise, it returns a *PolicyViolation without executing the command.
+func (e *PolicyExecutor) Execute(ctx context.Context, cmd *Command) error {
+	logger := loggerFor(ctx, e.Logger).With("field", "rexec.PolicyExecutor.Execute", "cmd", cmd)
+
+	if err := ctx.Err(); err != nil {
+		logger.Info("skipping execution: context done", "ctxErr", err)
+		return err
+	}
+
+	if e.Executor == nil {
+		logger.Warn("reject execution: nil underlying executor")
+		return fmt.Errorf("%w: nil underlying executor of PolicyExecutor", ErrInternalError)
+	}
+
+	if cmd == nil {
+		logger.Warn("reject execution: nil command")
+		return ErrNilCommand
+	}
+
+	target := e.target()
+
+	if err := e.Policy.Check(cmd, target); err != nil {
+		logger.Warn("reject execution: denied by policy", "target", target, "err", err)
+		cmd.Status = -1
+		return err
+	}
+
+	logger.Debug("command allowed by policy", "target", target)
+
+	return e.Executor.Execute(ctx, cmd)
+}
+
+// target returns the target name to check the commands against.
+func (e *PolicyExecutor) target() string {
+	if e.Target != "" {
+		return e.Target
+	}
+	return TargetOf(e.Executor).Name()
+}
+
+func (e *PolicyExecutor) TargetInfo() TargetInfo { return TargetOf(e.Executor) }
+
+// Close closes the underlying Executor if it is an ExecuteCloser.
+func (e *PolicyExecutor) Close() error {
+	if closer, ok := e.Executor.(ExecuteCloser); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (e *PolicyExecutor) Validate() error {
+	if e == nil {
+		return ErrNilExecutor
+	}
+	if e.Executor == nil {
+		return fmt.Errorf("%w: underlying executor is nil", ErrExecutorBadConfig)
+	}
+	if err := e.Policy.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrExecutorBadConfig, err)
+	}
+	if v, ok := e.Executor.(ExecuteCloser); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// WithPolicy returns a Middleware that enforces the policy on the target.
+// An empty target means the target of the decorated executor.
+// See PolicyExecutor.
+func WithPolicy(policy *Policy, target string) Middleware {
+	return func(next Executor) Executor {
+		return &PolicyExecutor{Executor: next, Policy: policy, Target: target}
+	}
+}
+
+// policy errors
+var (
+	ErrPolicyDenied = errors.New("denied by policy")
+	ErrBadPolicy    = errors.New("bad policy")
+)
@@ -0,0 +1,203 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testPolicyYaml = `
+default: deny
+rules:
+  - name: no-rm
+    action: deny
+    programs: [rm, "/*/rm"]
+  - name: no-ld-preload
+    action: deny
+    envKeys: ["LD_*"]
+  - name: no-force
+    action: deny
+    args: ["-f", "--force*"]
+  - name: git-read-only
+    action: allow
+    programs: [git]
+    argsRegex: ["^(status|log)( |$)"]
+  - name: srv-only
+    action: allow
+    programs: [cat, ls]
+    workdirs: [/srv]
+  - name: echo
+    action: allow
+    programs: [echo]
+targets:
+  "10.0.0.*:22":
+    - name: web-restart
+      action: allow
+      programs: [systemctl]
+      argsRegex: ["^restart nginx$"]
+  "10.0.0.9:22":
+    - name: no-echo-on-9
+      action: deny
+      programs: [echo]
+`
+
+func TestPolicy_Check(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicyYaml))
+	if err != nil {
+		t.Fatalf("❌ ParsePolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		cmd      *Command
+		target   string
+		wantRule string // empty for allowed
+	}{
+		{"echo", &Command{Command: "echo hello"}, "Local", ""},
+		{"rm", &Command{Command: "rm -r /tmp/x"}, "Local", "no-rm"},
+		{"rmPath", &Command{Command: "/bin/rm x"}, "Local", "no-rm"},
+		{"ldPreload", &Command{Command: "echo", Env: map[string]string{"LD_PRELOAD": "x.so"}}, "Local", "no-ld-preload"},
+		{"force", &Command{Command: "git push --force-with-lease"}, "Local", "no-force"},
+		{"gitStatus", &Command{Command: "git status -s"}, "Local", ""},
+		{"gitLog", &Command{Command: "git log"}, "Local", ""},
+		{"gitPush", &Command{Command: "git push"}, "Local", "<default>"},
+		{"gitStatusLike", &Command{Command: "git statusx"}, "Local", "<default>"},
+		{"catSrv", &Command{Command: "cat a.txt", Workdir: "/srv/app"}, "Local", ""},
+		{"catSrvTraversal", &Command{Command: "cat a.txt", Workdir: "/srv/../etc"}, "Local", "<default>"},
+		{"catSrvx", &Command{Command: "cat a.txt", Workdir: "/srvx"}, "Local", "<default>"},
+		{"catNoWorkdir", &Command{Command: "cat /etc/shadow"}, "Local", "<default>"},
+		{"lsOtherPath", &Command{Command: "/tmp/evil/ls", Workdir: "/srv"}, "Local", "<default>"},
+		{"shellOperator", &Command{Command: "echo hi && rm -rf /"}, "Local", "<shell-operators>"},
+		{"shellSubst", &Command{Command: "echo $(id)"}, "Local", "<shell-operators>"},
+		{"unparsable", &Command{Command: "echo 'unclosed"}, "Local", "<unparsable>"},
+		{"programExpansion", &Command{Command: "r${U}m -r /tmp/x"}, "Local", "<unparsable>"},
+		{"programGlob", &Command{Command: "/bin/r[m] x"}, "Local", "<unparsable>"},
+		{"argExpansion", &Command{Command: "echo $HOME *"}, "Local", ""},
+		{"restartOnWeb", &Command{Command: "systemctl restart nginx"}, "10.0.0.5:22", ""},
+		{"restartOnOther", &Command{Command: "systemctl restart nginx"}, "10.1.0.5:22", "<default>"},
+		{"stopOnWeb", &Command{Command: "systemctl stop nginx"}, "10.0.0.5:22", "<default>"},
+		{"exactTargetFirst", &Command{Command: "echo hi"}, "10.0.0.9:22", "no-echo-on-9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.cmd, tt.target)
+			if tt.wantRule == "" {
+				if err != nil {
+					t.Errorf("❌ Policy.Check() error = %v, want allowed", err)
+				}
+				return
+			}
+
+			var violation *PolicyViolation
+			if !errors.As(err, &violation) || !errors.Is(err, ErrPolicyDenied) {
+				t.Fatalf("❌ Policy.Check() error = %v, want *PolicyViolation", err)
+			}
+			if violation.Rule != tt.wantRule {
+				t.Errorf("❌ Policy.Check() denied by rule %q, want %q", violation.Rule, tt.wantRule)
+			}
+			if !strings.Contains(err.Error(), tt.wantRule) {
+				t.Errorf("❌ Policy.Check() error %q does not name the rule %q", err, tt.wantRule)
+			}
+			t.Logf("✅ Policy.Check() error = %v", err)
+		})
+	}
+}
+
+func TestPolicy_Check_denyList(t *testing.T) {
+	policy := &Policy{
+		Default: PolicyAllow,
+		Rules:   []PolicyRule{{Name: "no-rm", Action: PolicyDeny, Programs: []string{"rm", "/bin/rm", "/usr/bin/rm"}}},
+	}
+
+	tests := []struct {
+		name     string
+		command  string
+		wantRule string // empty for allowed
+	}{
+		{"ls", "ls -l /x", ""},
+		{"rm", "rm -rf /x", "no-rm"},
+		{"variable", "r${U}m -rf /x", "<unparsable>"},
+		{"bareVariable", "$RM -rf /x", "<unparsable>"},
+		{"bracket", "/bin/r[m] -rf /x", "<unparsable>"},
+		{"star", "r*m -rf /x", "<unparsable>"},
+		{"question", "/bin/r? -rf /x", "<unparsable>"},
+		{"brace", "/bin/{rm,x} -rf /x", "<unparsable>"},
+		{"tilde", "~/rm -rf /x", "<unparsable>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(&Command{Command: tt.command}, "Local")
+			var violation *PolicyViolation
+			switch {
+			case tt.wantRule == "" && err != nil:
+				t.Errorf("❌ Policy.Check() error = %v, want allowed", err)
+			case tt.wantRule != "" && (!errors.As(err, &violation) || violation.Rule != tt.wantRule):
+				t.Errorf("❌ Policy.Check() error = %v, want denied by %q", err, tt.wantRule)
+			default:
+				t.Logf("✅ Policy.Check() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestParsePolicy_bad(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"badAction", `{"Rules": [{"Action": "maybe"}]}`},
+		{"badDefault", `{"Default": "sure"}`},
+		{"badGlob", `{"Rules": [{"Action": "deny", "Programs": ["[a-"]}]}`},
+		{"badRegex", `{"Rules": [{"Action": "deny", "ArgsRegex": ["(a"]}]}`},
+		{"badTargetGlob", `{"Targets": {"[": []}}`},
+		{"badYaml", "rules: [\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolicy([]byte(tt.data))
+			if !errors.Is(err, ErrBadPolicy) {
+				t.Errorf("❌ ParsePolicy() error = %v, want %v", err, ErrBadPolicy)
+			} else {
+				t.Logf("✅ ParsePolicy() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestPolicyExecutor_Execute(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`{"Default": "deny", "Rules": [{"Name": "echo", "Action": "allow", "Programs": ["echo"]}]}`))
+	if err != nil {
+		t.Fatalf("❌ ParsePolicy() error = %v", err)
+	}
+
+	executor := Chain(&LocalExecutor{}, WithPolicy(policy, ""))
+	if err := executor.Validate(); err != nil {
+		t.Errorf("❌ Validate() error = %v", err)
+	}
+
+	m := NewManagedIO()
+	allowed := &Command{Command: "echo hello"}
+	m.Hijack(allowed)
+	if err := executor.Execute(context.Background(), allowed); err != nil {
+		t.Errorf("❌ Execute() allowed error = %v", err)
+	}
+	if m.Stdout.String() != "hello\n" {
+		t.Errorf("❌ Execute() allowed stdout = %q", m.Stdout.String())
+	}
+
+	denied := &Command{Command: "touch /tmp/rexec-policy-test"}
+	err = executor.Execute(context.Background(), denied)
+	if !errors.Is(err, ErrPolicyDenied) {
+		t.Errorf("❌ Execute() denied error = %v, want %v", err, ErrPolicyDenied)
+	}
+	if denied.Status != -1 || denied.started.Load() {
+		t.Errorf("❌ Execute() denied status = %d, started = %v, want -1 and not started", denied.Status, denied.started.Load())
+	} else {
+		t.Logf("✅ Execute() denied: %v", err)
+	}
+
+	if err := (&PolicyExecutor{Executor: &LocalExecutor{}}).Validate(); !errors.Is(err, ErrBadPolicy) {
+		t.Errorf("❌ Validate() without policy error = %v, want %v", err, ErrBadPolicy)
+	}
+}
@@ -0,0 +1,142 @@
+package rexec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides the pseudo-terminals of the commands (Command.Pty),
+// for the executors: a local pty for LocalExecutor and ShellExecutor
+// (see pty_unix.go), and a "pty-req" for the SSH executors.
+
+// defaults of PtyConfig
+const (
+	DefaultPtyTerm = "xterm"
+	DefaultPtyRows = 24
+	DefaultPtyCols = 80
+)
+
+// PtyConfig is the pseudo-terminal to run a command in.
+//
+// On a terminal, the command writes both its outputs to the Stdout: the
+// Stderr gets nothing. The Stdin is written to the terminal as typed, and
+// its end is not forwarded: a program reading the terminal until the end of
+// its input waits until the ctx is done.
+//
+// The size of the terminal can be changed while the command is running,
+// see Command.Resize.
+type PtyConfig struct {
+	// Term is the TERM of the terminal.
+	// If empty, DefaultPtyTerm is used.
+	Term string
+	// Rows and Cols are the initial size of the terminal, in characters.
+	// If <= 0, DefaultPtyRows and DefaultPtyCols are used.
+	Rows int
+	Cols int
+	// Modes are the terminal modes to request (see ssh.TerminalModes), e.g.
+	// {ssh.ECHO: 0} to disable the echo of the Stdin. They are applied by
+	// the SSH servers only: the local terminals have the default modes.
+	Modes ssh.TerminalModes
+}
+
+func (p *PtyConfig) term() string {
+	if p.Term != "" {
+		return p.Term
+	}
+	return DefaultPtyTerm
+}
+
+// size returns the rows and cols, with the defaults.
+func (p *PtyConfig) size() (rows, cols int) {
+	rows, cols = p.Rows, p.Cols
+	if rows <= 0 {
+		rows = DefaultPtyRows
+	}
+	if cols <= 0 {
+		cols = DefaultPtyCols
+	}
+	return rows, cols
+}
+
+func (p *PtyConfig) clone() *PtyConfig {
+	c := *p
+	if p.Modes != nil {
+		c.Modes = make(ssh.TerminalModes, len(p.Modes))
+		for k, v := range p.Modes {
+			c.Modes[k] = v
+		}
+	}
+	return &c
+}
+
+// validate checks the TERM (which is set in the env of the local commands)
+// and the size.
+func (p *PtyConfig) validate() error {
+	if d, c := containsDangerous(p.Term, EnvDangerous); d {
+		return fmt.Errorf("pty term (%q) %w: %q", p.Term, ErrContainsDangerous, c)
+	}
+	if err := checkPtySize(p.Rows, p.Cols); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkPtySize returns an error wrapping ErrBadPtySize if the rows or the
+// cols do not fit a terminal size.
+func checkPtySize(rows, cols int) error {
+	if rows < 0 || rows > math.MaxUint16 || cols < 0 || cols > math.MaxUint16 {
+		return fmt.Errorf("%w: %dx%d", ErrBadPtySize, rows, cols)
+	}
+	return nil
+}
+
+// Resize changes the size of the terminal of the running command, in
+// characters, e.g. when the terminal of the user is resized. The program
+// is notified (SIGWINCH).
+//
+// It returns an error wrapping ErrNoPty if the command is not running in a
+// pseudo-terminal (see Command.Pty).
+func (e *Command) Resize(rows, cols int) error {
+	if e == nil {
+		return ErrNilCommand
+	}
+	if rows <= 0 || cols <= 0 {
+		return fmt.Errorf("%w: %dx%d", ErrBadPtySize, rows, cols)
+	}
+	if err := checkPtySize(rows, cols); err != nil {
+		return err
+	}
+	resize := e.resizer.Load()
+	if resize == nil {
+		return fmt.Errorf("%w: the command is not running in a pty", ErrNoPty)
+	}
+	return (*resize)(rows, cols)
+}
+
+// setResizer sets the function resizing the terminal of the running
+// command, for Resize. It returns a function unsetting it, to be called
+// when the command ends.
+func (e *Command) setResizer(resize func(rows, cols int) error) (unset func()) {
+	e.resizer.Store(&resize)
+	return func() { e.resizer.Store(nil) }
+}
+
+// requestSshPty requests the pty of the command on the session, and makes
+// the command resizable by window-change requests.
+// It returns a function to call when the command ends.
+func requestSshPty(session *ssh.Session, cmd *Command) (unset func(), err error) {
+	rows, cols := cmd.Pty.size()
+	if err := session.RequestPty(cmd.Pty.term(), rows, cols, cmd.Pty.Modes); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoPty, err)
+	}
+	return cmd.setResizer(session.WindowChange), nil
+}
+
+// pty errors
+var (
+	ErrNoPty      = errors.New("no pseudo-terminal")
+	ErrBadPtySize = errors.New("bad terminal size")
+)
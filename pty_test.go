@@ -0,0 +1,190 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cdfmlr/rexec/v2/internal/testsshd"
+)
+
+// ptyTestExecutors returns the executors supporting Command.Pty, the SSH
+// ones on a testsshd.
+func ptyTestExecutors(t *testing.T) map[string]Executor {
+	t.Helper()
+
+	sshd, err := testsshd.New(nil)
+	if err != nil {
+		t.Fatalf("❌ testsshd.New() error = %v", err)
+	}
+	t.Cleanup(func() { _ = sshd.Close() })
+
+	config := &SshClientConfig{
+		Addr:         sshd.Addr(),
+		User:         "testuser",
+		Auth:         []SshAuth{{Password: "test"}},
+		HostKeyCheck: &SshHostKeyCheckConfig{InsecureIgnore: true},
+	}
+	keepAlive := &KeepAliveSshExecutor{Config: config}
+	t.Cleanup(func() { _ = keepAlive.Close() })
+
+	return map[string]Executor{
+		"local":     &LocalExecutor{},
+		"shell":     &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}},
+		"immediate": &ImmediateSshExecutor{Config: config},
+		"keepAlive": keepAlive,
+	}
+}
+
+// ptyOutput is a Stdout for the commands on a terminal: it can be read
+// while the command writes it, and the "\r\n" of the terminal are read as
+// "\n".
+type ptyOutput struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	written chan struct{} // receives after each write, if not full
+}
+
+func newPtyOutput() *ptyOutput {
+	return &ptyOutput{written: make(chan struct{}, 1)}
+}
+
+func (o *ptyOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n, err := o.buf.Write(p)
+	select {
+	case o.written <- struct{}{}:
+	default:
+	}
+	return n, err
+}
+
+func (o *ptyOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return strings.ReplaceAll(o.buf.String(), "\r\n", "\n")
+}
+
+// waitFor waits until the output contains s.
+func (o *ptyOutput) waitFor(t *testing.T, s string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for !strings.Contains(o.String(), s) {
+		select {
+		case <-o.written:
+		case <-timeout:
+			t.Fatalf("❌ output = %q, want %q", o.String(), s)
+		}
+	}
+}
+
+func TestExecutor_Execute_pty(t *testing.T) {
+	tests := []struct {
+		name       string
+		pty        *PtyConfig
+		wantStdout string
+	}{
+		{name: "noPty", pty: nil, wantStdout: "not a tty\n"},
+		{name: "defaults", pty: &PtyConfig{}, wantStdout: "24 80\nxterm\n"},
+		{name: "config", pty: &PtyConfig{Term: "vt100", Rows: 30, Cols: 100}, wantStdout: "30 100\nvt100\n"},
+	}
+	for executorName, executor := range ptyTestExecutors(t) {
+		for _, tt := range tests {
+			t.Run(executorName+"/"+tt.name, func(t *testing.T) {
+				stdout, stderr := newPtyOutput(), &bytes.Buffer{}
+				cmd := &Command{
+					Command: `sh -c "tty -s && stty size && printenv TERM || echo not a tty"`,
+					Pty:     tt.pty,
+					Stdout:  stdout,
+					Stderr:  stderr,
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := executor.Execute(ctx, cmd); err != nil {
+					t.Fatalf("❌ Execute() error = %v", err)
+				}
+				if got := stdout.String(); got != tt.wantStdout || stderr.Len() != 0 {
+					t.Errorf("❌ stdout = %q, stderr = %q, want stdout %q", got, stderr.String(), tt.wantStdout)
+					return
+				}
+				t.Logf("✅ stdout = %q", stdout.String())
+			})
+		}
+	}
+}
+
+func TestCommand_Resize(t *testing.T) {
+	for executorName, executor := range ptyTestExecutors(t) {
+		t.Run(executorName, func(t *testing.T) {
+			stdin, input := io.Pipe()
+			stdout := newPtyOutput()
+			cmd := &Command{
+				Command: `sh -c "stty size; read line; stty size"`,
+				Pty:     &PtyConfig{},
+				Stdin:   stdin,
+				Stdout:  stdout,
+			}
+
+			if err := cmd.Resize(40, 120); !errors.Is(err, ErrNoPty) {
+				t.Errorf("❌ Resize() before Execute() error = %v, want %v", err, ErrNoPty)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- executor.Execute(ctx, cmd) }()
+
+			stdout.waitFor(t, "24 80\n")
+			if err := cmd.Resize(40, 120); err != nil {
+				t.Fatalf("❌ Resize() error = %v", err)
+			}
+			if err := cmd.Resize(0, 120); !errors.Is(err, ErrBadPtySize) {
+				t.Errorf("❌ Resize(0, 120) error = %v, want %v", err, ErrBadPtySize)
+			}
+			// the window-change of SSH is not acknowledged: let it arrive
+			// before the size is read again.
+			time.Sleep(100 * time.Millisecond)
+			_, _ = input.Write([]byte("resized\n"))
+
+			if err := <-done; err != nil {
+				t.Fatalf("❌ Execute() error = %v", err)
+			}
+			stdout.waitFor(t, "resized\n40 120\n")
+
+			if err := cmd.Resize(40, 120); !errors.Is(err, ErrNoPty) {
+				t.Errorf("❌ Resize() after Execute() error = %v, want %v", err, ErrNoPty)
+			}
+			t.Logf("✅ stdout = %q", stdout.String())
+		})
+	}
+}
+
+func TestCommand_Validate_pty(t *testing.T) {
+	tests := []struct {
+		name    string
+		pty     *PtyConfig
+		wantErr error
+	}{
+		{name: "ok", pty: &PtyConfig{Term: "xterm-256color", Rows: 50, Cols: 200}, wantErr: nil},
+		{name: "dangerousTerm", pty: &PtyConfig{Term: "xterm; rm -rf /"}, wantErr: ErrContainsDangerous},
+		{name: "negativeSize", pty: &PtyConfig{Rows: -1}, wantErr: ErrBadPtySize},
+		{name: "hugeSize", pty: &PtyConfig{Cols: 1 << 16}, wantErr: ErrBadPtySize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &Command{Command: "true", Pty: tt.pty}
+			if err := cmd.Validate(); !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("❌ Validate() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			t.Logf("✅ Validate() ok")
+		})
+	}
+}
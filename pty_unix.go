@@ -0,0 +1,121 @@
+//go:build !windows
+
+package rexec
+
+import (
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// ptyDrainTimeout is how long a localPty waits for the output of the
+// process to be copied, after the process has exited: the terminal may
+// still be held open by its children.
+const ptyDrainTimeout = time.Second
+
+// localPty is the pseudo-terminal of a local process.
+type localPty struct {
+	// mu guards the resizing of the ptmx against its closing.
+	mu        sync.Mutex
+	ptmx, tty *os.File
+	closed    bool
+	stdin     io.Reader
+	stdout    io.Writer
+	// copied is closed when the output has been copied to the stdout.
+	// It is nil if the process has not been started.
+	copied      chan struct{}
+	unsetResize func()
+}
+
+// openLocalPty opens the pty of the cmd (cmd.Pty must not be nil), and sets
+// it as the stdio, the controlling terminal and the TERM of the proc.
+//
+// The start method must be called after the proc has been started, and the
+// close method after it has exited.
+func openLocalPty(cmd *Command, proc *osexec.Cmd) (*localPty, error) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoPty, err)
+	}
+	rows, cols := cmd.Pty.size()
+	if err := setPtySize(ptmx, rows, cols); err != nil {
+		_ = tty.Close()
+		_ = ptmx.Close()
+		return nil, fmt.Errorf("%w: %w", ErrNoPty, err)
+	}
+
+	env := proc.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	if _, ok := cmd.Env["TERM"]; !ok {
+		env = append(env, "TERM="+cmd.Pty.term())
+	}
+	proc.Env = env
+
+	proc.Stdin, proc.Stdout, proc.Stderr = tty, tty, tty
+	if proc.SysProcAttr == nil {
+		proc.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	proc.SysProcAttr.Setsid, proc.SysProcAttr.Setctty = true, true
+
+	p := &localPty{ptmx: ptmx, tty: tty, stdin: cmd.Stdin, stdout: cmd.Stdout}
+	p.unsetResize = cmd.setResizer(p.resize)
+	return p, nil
+}
+
+func (p *localPty) resize(rows, cols int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return fmt.Errorf("%w: the command has exited", ErrNoPty)
+	}
+	return setPtySize(p.ptmx, rows, cols)
+}
+
+// start copies the stdin to the terminal, and the terminal to the stdout.
+func (p *localPty) start() {
+	// the process holds the tty now: the output ends when it exits.
+	_ = p.tty.Close()
+
+	p.copied = make(chan struct{})
+	go func() { _, _ = io.Copy(p.ptmx, p.stdin) }()
+	go func() {
+		defer close(p.copied)
+		_, _ = io.Copy(p.stdout, p.ptmx)
+	}()
+}
+
+// close waits for the output to be copied (for ptyDrainTimeout at most) and
+// closes the terminal.
+func (p *localPty) close() {
+	p.unsetResize()
+	if p.copied != nil {
+		select {
+		case <-p.copied:
+		case <-time.After(ptyDrainTimeout):
+		}
+	}
+
+	p.mu.Lock()
+	p.closed = true
+	if p.copied == nil {
+		_ = p.tty.Close()
+	}
+	_ = p.ptmx.Close()
+	p.mu.Unlock()
+
+	if p.copied != nil {
+		<-p.copied
+	}
+}
+
+func setPtySize(ptmx *os.File, rows, cols int) error {
+	return pty.Setsize(ptmx, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
+}
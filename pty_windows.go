@@ -0,0 +1,20 @@
+//go:build windows
+
+package rexec
+
+import (
+	"fmt"
+	osexec "os/exec"
+)
+
+// localPty is not supported on Windows: the commands with a Pty can only
+// run on the SSH executors.
+type localPty struct{}
+
+func openLocalPty(cmd *Command, proc *osexec.Cmd) (*localPty, error) {
+	return nil, fmt.Errorf("%w: local pseudo-terminals are not supported on windows", ErrNoPty)
+}
+
+func (p *localPty) start() {}
+
+func (p *localPty) close() {}
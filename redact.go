@@ -0,0 +1,129 @@
+package rexec
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// This file provides the redaction of secrets (tokens, passwords, ...) in
+// the logs and audit records of commands.
+//
+// The value of an env variable is a secret if its key matches any of the
+// SecretEnvKeyPatterns or SecretEnvKeys. Other values can be marked as
+// secrets by Command.Secrets. Secrets are replaced by a mask wherever they
+// appear in the logs:
+//
+//	cmd := &rexec.Command{
+//		Command: "curl -u admin:s3cr3t https://example.com",
+//		Env:     map[string]string{"API_TOKEN": "abc"},
+//		Secrets: []string{"s3cr3t"},
+//	}
+//	// logged as: command="curl -u admin:****** https://example.com" env=map[API_TOKEN:******]
+
+// SecretEnvKeyPatterns are the glob patterns (path.Match syntax, matched
+// case-insensitively) of the env keys whose values are secrets.
+var SecretEnvKeyPatterns = []string{
+	"*TOKEN*",
+	"*PASSWORD*",
+	"*PASSWD*",
+	"*SECRET*",
+	"*API_KEY*",
+	"*APIKEY*",
+	"*PRIVATE_KEY*",
+	"*CREDENTIAL*",
+}
+
+// SecretEnvKeys are the env keys (matched exactly) whose values are secrets,
+// in addition to the ones matching SecretEnvKeyPatterns.
+var SecretEnvKeys []string
+
+// redactedMask replaces redacted values.
+const redactedMask = "******"
+
+// IsSecretEnvKey reports whether the value of the env variable named key is
+// a secret, according to SecretEnvKeys and SecretEnvKeyPatterns.
+func IsSecretEnvKey(key string) bool {
+	for _, k := range SecretEnvKeys {
+		if key == k {
+			return true
+		}
+	}
+	upper := strings.ToUpper(key)
+	for _, pattern := range SecretEnvKeyPatterns {
+		if ok, _ := path.Match(strings.ToUpper(pattern), upper); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns s with all the secrets of the command masked:
+// the Secrets and the values of the secret env variables (IsSecretEnvKey).
+func (e *Command) Redact(s string) string {
+	if e == nil {
+		return s
+	}
+	secrets := e.secretValues()
+	if len(secrets) == 0 {
+		return s
+	}
+	oldnew := make([]string, 0, 2*len(secrets))
+	for _, secret := range secrets {
+		oldnew = append(oldnew, secret, redactedMask)
+	}
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
+
+// RedactedEnv returns a copy of the Env with the secrets masked.
+// The values of secret env variables are masked entirely.
+func (e *Command) RedactedEnv() map[string]string {
+	if e == nil || e.Env == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(e.Env))
+	for k, v := range e.Env {
+		redacted[k] = e.redactEnvValue(k, v)
+	}
+	return redacted
+}
+
+// RedactedShellString returns the ShellString with the secrets masked.
+// Unlike ShellString, it does not validate the command,
+// so it is safe to call for logging.
+func (e *Command) RedactedShellString() string {
+	if e == nil {
+		return ""
+	}
+	return e.Redact(e.cdWorkdirParts() + e.envVarsParts() + e.Command)
+}
+
+// redactEnvValue returns the value of the env variable k with the secrets
+// masked.
+func (e *Command) redactEnvValue(k, v string) string {
+	if v != "" && IsSecretEnvKey(k) {
+		return redactedMask
+	}
+	return e.Redact(v)
+}
+
+// secretValues returns the non-empty secrets of the command,
+// the longest first, so that a secret containing another one is masked
+// entirely.
+func (e *Command) secretValues() []string {
+	var secrets []string
+	for _, s := range e.Secrets {
+		if s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	for k, v := range e.Env {
+		if v != "" && IsSecretEnvKey(k) {
+			secrets = append(secrets, v)
+		}
+	}
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	return secrets
+}
@@ -0,0 +1,159 @@
+package rexec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// This file provides a registry of executor kinds, so that executors
+// implemented outside this package (e.g. running commands in containers)
+// can be created by ExecutorFactory, and chosen from configuration files:
+//
+//	func init() {
+//		rexec.RegisterExecutorKind("Container", func(config any) (rexec.ExecuteCloser, error) {
+//			return NewContainerExecutor(config.(*ContainerConfig))
+//		}, ContainerConfig{})
+//	}
+//
+//	// {"Kind": "Container", "Config": {"Image": "alpine:3"}}
+//	executor, err := factory.Executor()
+
+// ExecutorConstructor creates an executor of a kind from its config,
+// which is a pointer to a value of the config type of the kind
+// (see RegisterExecutorKind).
+type ExecutorConstructor func(config any) (ExecuteCloser, error)
+
+// executorKind is a registered kind of executors.
+type executorKind struct {
+	constructor ExecutorConstructor
+	configType  reflect.Type // the element type if registered with a pointer, nil for any
+}
+
+var executorKinds = struct {
+	sync.RWMutex
+	m map[string]executorKind
+}{m: make(map[string]executorKind)}
+
+// RegisterExecutorKind registers a kind of executors named name, created by
+// the constructor from a config of the type of configType.
+//
+// configType is a value of the config type T, or a pointer to it, e.g.
+// ContainerConfig{} or (*ContainerConfig)(nil). The constructor receives the
+// ExecutorFactory.Config as a *T: as is if it is already a *T, copied if it
+// is a T, or decoded from its JSON encoding otherwise (e.g. a map decoded
+// from a config file). If configType is nil, the constructor receives the
+// ExecutorFactory.Config as is.
+//
+// The executor fields of ExecutorFactory are registered as kinds named after
+// the fields, e.g. "Local" and "KeepAliveSsh".
+//
+// RegisterExecutorKind is intended to be called in init functions. It panics
+// if the name is empty or already registered, or the constructor is nil.
+func RegisterExecutorKind(name string, constructor ExecutorConstructor, configType any) {
+	if name == "" {
+		panic("rexec: RegisterExecutorKind: empty name")
+	}
+	if constructor == nil {
+		panic("rexec: RegisterExecutorKind: nil constructor of kind " + name)
+	}
+
+	kind := executorKind{constructor: constructor}
+	if configType != nil {
+		kind.configType = reflect.TypeOf(configType)
+		if kind.configType.Kind() == reflect.Pointer {
+			kind.configType = kind.configType.Elem()
+		}
+	}
+
+	executorKinds.Lock()
+	defer executorKinds.Unlock()
+	if _, dup := executorKinds.m[name]; dup {
+		panic("rexec: RegisterExecutorKind: kind registered twice: " + name)
+	}
+	executorKinds.m[name] = kind
+}
+
+// ExecutorKinds returns the names of the registered kinds of executors,
+// sorted.
+func ExecutorKinds() []string {
+	executorKinds.RLock()
+	defer executorKinds.RUnlock()
+	return sortedKeys(executorKinds.m)
+}
+
+// register the executor fields of ExecutorFactory as kinds:
+// the executors are their own configs.
+func init() {
+	for name, executor := range (ExecutorFactory{}).executorsMap() {
+		RegisterExecutorKind(name, func(config any) (ExecuteCloser, error) {
+			return config.(ExecuteCloser), nil
+		}, executor)
+	}
+}
+
+// lookupExecutorKind returns the registered kind named name.
+func lookupExecutorKind(name string) (executorKind, bool) {
+	executorKinds.RLock()
+	defer executorKinds.RUnlock()
+	kind, ok := executorKinds.m[name]
+	return kind, ok
+}
+
+// newExecutorOfKind creates and validates an executor of the registered
+// kind from the config.
+func newExecutorOfKind(name string, config any) (ExecuteCloser, error) {
+	kind, ok := lookupExecutorKind(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownExecutorKind, name)
+	}
+
+	config, err := kind.decodeConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: (%s) %w", ErrExecutorBadConfig, name, err)
+	}
+
+	executor, err := kind.constructor(config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: (%s) %w", ErrExecutorBadConfig, name, err)
+	}
+	if executor == nil {
+		return nil, fmt.Errorf("%w: (%s) %w", ErrExecutorBadConfig, name, ErrNilExecutor)
+	}
+	if err := executor.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: (%s) %w", ErrExecutorBadConfig, name, err)
+	}
+	return executor, nil
+}
+
+// decodeConfig converts the config to a pointer to the config type.
+func (k executorKind) decodeConfig(config any) (any, error) {
+	if k.configType == nil {
+		return config, nil
+	}
+
+	ptr := reflect.New(k.configType)
+	switch {
+	case config == nil:
+	case reflect.TypeOf(config) == ptr.Type():
+		return config, nil
+	case reflect.TypeOf(config) == k.configType:
+		ptr.Elem().Set(reflect.ValueOf(config))
+	default:
+		data, err := json.Marshal(config)
+		if err != nil {
+			return nil, fmt.Errorf("bad config: %w", err)
+		}
+		if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+			return nil, fmt.Errorf("bad config: %w", err)
+		}
+	}
+	return ptr.Interface(), nil
+}
+
+// executor kind errors
+var (
+	ErrUnknownExecutorKind = errors.New("unknown executor kind")
+)
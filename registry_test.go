@@ -0,0 +1,171 @@
+package rexec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// echoConfig is the config of the echoExecutor kind registered for tests.
+type echoConfig struct {
+	Prefix string
+}
+
+// echoExecutor writes the command with the prefix to the stdout,
+// instead of running it.
+type echoExecutor struct {
+	config echoConfig
+}
+
+func (e *echoExecutor) Execute(ctx context.Context, cmd *Command) error {
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprint(cmd.Stdout, e.config.Prefix+cmd.Command)
+	return err
+}
+
+func (e *echoExecutor) Close() error { return nil }
+
+func (e *echoExecutor) Validate() error {
+	if e == nil {
+		return ErrNilExecutor
+	}
+	if e.config.Prefix == "" {
+		return fmt.Errorf("%w: empty prefix", ErrExecutorBadConfig)
+	}
+	return nil
+}
+
+func init() {
+	RegisterExecutorKind("test.echo", func(config any) (ExecuteCloser, error) {
+		return &echoExecutor{config: *config.(*echoConfig)}, nil
+	}, echoConfig{})
+}
+
+func TestExecutorKinds(t *testing.T) {
+	kinds := ExecutorKinds()
+	for _, want := range []string{"ImmediateSsh", "KeepAliveSsh", "Local", "Shell", "test.echo"} {
+		if !slices.Contains(kinds, want) {
+			t.Errorf("❌ ExecutorKinds() = %v, missing %q", kinds, want)
+		}
+	}
+	if !slices.IsSorted(kinds) {
+		t.Errorf("❌ ExecutorKinds() = %v, not sorted", kinds)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("❌ registering a kind twice does not panic")
+		} else {
+			t.Logf("✅ registering a kind twice panics: %v", r)
+		}
+	}()
+	RegisterExecutorKind("Local", func(any) (ExecuteCloser, error) { return &LocalExecutor{}, nil }, nil)
+}
+
+func TestExecutorFactory_Executor_kind(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		f       *ExecutorFactory // instead of json
+		wantErr error
+		want    string // stdout of "echo hello"
+	}{
+		{
+			name: "registered",
+			json: `{"kind": "test.echo", "config": {"Prefix": "> "}}`,
+			want: "> echo hello",
+		},
+		{
+			name: "builtin",
+			json: `{"Kind": "Shell", "Config": {"ShellPath": "sh", "ShellArgs": ["-c"]}}`,
+			want: "hello\n",
+		},
+		{
+			name: "builtinPointer",
+			f:    &ExecutorFactory{Kind: "Local", Config: &LocalExecutor{}},
+			want: "hello\n",
+		},
+		{
+			name: "value",
+			f:    &ExecutorFactory{Kind: "test.echo", Config: echoConfig{Prefix: "$ "}},
+			want: "$ echo hello",
+		},
+		{
+			name: "pointer",
+			f:    &ExecutorFactory{Kind: "test.echo", Config: &echoConfig{Prefix: "# "}},
+			want: "# echo hello",
+		},
+		{
+			name:    "unknown",
+			json:    `{"Kind": "nope"}`,
+			wantErr: ErrUnknownExecutorKind,
+		},
+		{
+			name:    "badConfig",
+			json:    `{"Kind": "test.echo", "Config": {"Prefix": 1}}`,
+			wantErr: ErrExecutorBadConfig,
+		},
+		{
+			name:    "invalid",
+			json:    `{"Kind": "test.echo", "Config": {}}`,
+			wantErr: ErrExecutorBadConfig,
+		},
+		{
+			name:    "invalidBuiltin",
+			json:    `{"Kind": "Shell", "Config": {}}`,
+			wantErr: ErrExecutorBadConfig,
+		},
+		{
+			name:    "kindAndField",
+			json:    `{"Kind": "test.echo", "Config": {"Prefix": "> "}, "Local": {}}`,
+			wantErr: ErrMultipleExecutors,
+		},
+		{
+			name:    "configWithoutKind",
+			json:    `{"Config": {"Prefix": "> "}}`,
+			wantErr: ErrExecutorBadConfig,
+		},
+		{
+			name: "field",
+			json: `{"Shell": {"ShellPath": "sh", "ShellArgs": ["-c"]}}`,
+			want: "hello\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.f
+			if f == nil {
+				f = new(ExecutorFactory)
+				if err := json.Unmarshal([]byte(tt.json), f); err != nil {
+					t.Fatalf("❌ json.Unmarshal() error = %v", err)
+				}
+			}
+			executor, err := f.Executor()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("❌ Executor() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				t.Logf("✅ Executor() error = %v", err)
+				return
+			}
+			defer executor.Close()
+
+			m := NewManagedIO()
+			cmd := &Command{Command: "echo hello"}
+			m.Hijack(cmd)
+			if err := executor.Execute(context.Background(), cmd); err != nil {
+				t.Fatalf("❌ Execute() error = %v", err)
+			}
+			if got := m.Stdout.String(); got != tt.want {
+				t.Errorf("❌ stdout = %q, want %q", got, tt.want)
+			} else {
+				t.Logf("✅ %T: %q", executor, got)
+			}
+		})
+	}
+}
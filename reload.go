@@ -0,0 +1,370 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// This file provides ReloadableExecutor, to change the executor of a
+// long-running program (e.g. rotate the SSH keys, move to another host)
+// without restarting it:
+//
+//	executor := &rexec.ReloadableExecutor{DrainTimeout: time.Minute}
+//	go executor.Watch(ctx, "/etc/myapp/executor.yaml")
+//	defer executor.Close()
+//
+//	err := executor.Execute(ctx, cmd) // on the latest valid configuration
+
+// DefaultReloadPollInterval is the PollInterval of a ReloadableExecutor that
+// does not set it.
+const DefaultReloadPollInterval = 5 * time.Second
+
+// ReloadableExecutor is an Executor running the commands with the executor
+// created from the latest configuration it loaded, by Reload (e.g. from an
+// API) or ReloadFile and Watch (from a config file).
+//
+// A reload switches the new executions to the new executor atomically.
+// The old executor is drained in the background: it is closed once the
+// executions already running on it finish (or the DrainTimeout expires).
+// An invalid configuration is rejected, and the current executor is kept.
+//
+// The zero value has no executor: Execute fails with ErrExecutorNotSet
+// until the first successful reload.
+type ReloadableExecutor struct {
+	// DrainTimeout is the maximum time to wait for the executions on an old
+	// executor before closing it anyway. If <= 0, the old executor is closed
+	// only after all of them finish.
+	DrainTimeout time.Duration
+	// PollInterval is the interval Watch checks the config file at.
+	// If <= 0, DefaultReloadPollInterval is used.
+	PollInterval time.Duration
+
+	// Middlewares decorate the executors loaded from files (see
+	// ExecutorFactory.Middlewares), since the files cannot configure them.
+	Middlewares []Middleware `json:"-"`
+
+	// OnReload, if not nil, is called after each reload (including the ones
+	// of Watch) with nil, or the error that rejected it.
+	OnReload func(err error) `json:"-"`
+
+	// Logger, if not nil, overrides the global Logger for this executor,
+	// and is the Logger of the factories loaded from files.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+
+	mu      sync.RWMutex
+	current *reloadGeneration // nil before the first reload and after Close
+	nextID  uint64
+	closed  bool
+	drains  sync.WaitGroup // the old generations being drained
+}
+
+// reloadGeneration is an executor loaded by a reload, with its executions
+// in flight.
+type reloadGeneration struct {
+	id       uint64
+	executor ExecuteCloser
+	inflight sync.WaitGroup
+}
+
+var (
+	_ Executor      = (*ReloadableExecutor)(nil)
+	_ ExecuteCloser = (*ReloadableExecutor)(nil)
+	_ Targeter      = (*ReloadableExecutor)(nil)
+)
+
+// NewReloadableExecutor returns a ReloadableExecutor with the executor of
+// the factory loaded.
+func NewReloadableExecutor(f ExecutorFactory) (*ReloadableExecutor, error) {
+	r := &ReloadableExecutor{Logger: f.Logger}
+	if err := r.Reload(f); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload creates the executor of the factory, and switches the new
+// executions to it. The old executor, if any, is drained and closed in
+// the background.
+//
+// If the factory is invalid (see ExecutorFactory.ValidateConfig) or fails
+// to create the executor, it returns an error wrapping ErrReloadRejected,
+// and the current executor is kept.
+// After Close, it returns ErrAlreadyClosed.
+func (r *ReloadableExecutor) Reload(f ExecutorFactory) error {
+	err := r.reload(f)
+	if r.OnReload != nil {
+		r.OnReload(err)
+	}
+	return err
+}
+
+func (r *ReloadableExecutor) reload(f ExecutorFactory) error {
+	logger := orGlobalLogger(r.Logger).With("field", "rexec.ReloadableExecutor.Reload", "factory", f)
+
+	if err := f.ValidateConfig(); err != nil {
+		logger.Warn("reload rejected: invalid config", "err", err)
+		return fmt.Errorf("%w: %w", ErrReloadRejected, err)
+	}
+	executor, err := f.Executor()
+	if err != nil {
+		logger.Warn("reload rejected: failed to create executor", "err", err)
+		return fmt.Errorf("%w: %w", ErrReloadRejected, err)
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		_ = executor.Close()
+		logger.Warn("reload rejected: already closed")
+		return ErrAlreadyClosed
+	}
+	r.nextID++
+	id, old := r.nextID, r.current
+	r.current = &reloadGeneration{id: id, executor: executor}
+	if old != nil {
+		r.drains.Add(1) // before Close may wait for it
+	}
+	r.mu.Unlock()
+
+	logger.Info("executor reloaded", "generation", id, "target", TargetOf(executor))
+
+	if old != nil {
+		go func() {
+			defer r.drains.Done()
+			_ = r.drain(old)
+		}()
+	}
+	return nil
+}
+
+// ReloadFile loads the ExecutorFactory from the JSON or YAML file (with the
+// Middlewares and the Logger of r), and reloads it (see Reload).
+//
+// The file is checked by ValidateConfig, so that unknown fields are
+// rejected as well.
+func (r *ReloadableExecutor) ReloadFile(name string) error {
+	f, err := r.loadFile(name)
+	if err != nil {
+		err = fmt.Errorf("%w: %s: %w", ErrReloadRejected, name, err)
+		orGlobalLogger(r.Logger).Warn("reload rejected: bad config file",
+			"field", "rexec.ReloadableExecutor.ReloadFile", "file", name, "err", err)
+		if r.OnReload != nil {
+			r.OnReload(err)
+		}
+		return err
+	}
+	return r.Reload(f)
+}
+
+func (r *ReloadableExecutor) loadFile(name string) (ExecutorFactory, error) {
+	var f ExecutorFactory
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return f, err
+	}
+	if err := ValidateConfig(data); err != nil {
+		return f, err
+	}
+	if err := DecodeConfig(data, &f); err != nil {
+		return f, err
+	}
+	f.Middlewares, f.Logger = r.Middlewares, r.Logger
+	return f, nil
+}
+
+// Watch loads the config file (see ReloadFile), and reloads it whenever
+// its modification time or size changes, checked every PollInterval.
+// The errors of the reloads are logged and reported to OnReload, and the
+// current executor is kept.
+//
+// It blocks until the ctx is done, returning context.Cause(ctx), or until
+// r is closed, returning ErrAlreadyClosed.
+func (r *ReloadableExecutor) Watch(ctx context.Context, name string) error {
+	logger := orGlobalLogger(r.Logger).With("field", "rexec.ReloadableExecutor.Watch", "file", name)
+
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = DefaultReloadPollInterval
+	}
+
+	type stamp struct {
+		modTime time.Time
+		size    int64
+	}
+	var last stamp
+	var statFailed bool
+
+	check := func() error {
+		r.mu.RLock()
+		closed := r.closed
+		r.mu.RUnlock()
+		if closed {
+			return ErrAlreadyClosed
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			if !statFailed { // report once until it recovers
+				statFailed = true
+				logger.Warn("failed to stat config file", "err", err)
+				if r.OnReload != nil {
+					r.OnReload(fmt.Errorf("%w: %w", ErrReloadRejected, err))
+				}
+			}
+			return nil
+		}
+		statFailed = false
+
+		current := stamp{modTime: info.ModTime(), size: info.Size()}
+		if current == last {
+			return nil
+		}
+		last = current
+		logger.Debug("config file changed", "modTime", current.modTime, "size", current.size)
+		if err := r.ReloadFile(name); errors.Is(err, ErrAlreadyClosed) {
+			return err
+		}
+		return nil
+	}
+
+	logger.Info("watching config file", "interval", interval)
+	if err := check(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("stop watching config file", "cause", context.Cause(ctx))
+			return context.Cause(ctx)
+		case <-ticker.C:
+			if err := check(); err != nil {
+				logger.Info("stop watching config file: executor closed")
+				return err
+			}
+		}
+	}
+}
+
+// Execute executes the command with the current executor.
+// A reload during the execution does not affect it: the old executor is
+// closed after it finishes (or the DrainTimeout expires).
+func (r *ReloadableExecutor) Execute(ctx context.Context, cmd *Command) error {
+	logger := loggerFor(ctx, r.Logger).With("field", "rexec.ReloadableExecutor.Execute", "cmd", cmd)
+
+	r.mu.RLock()
+	g, closed := r.current, r.closed
+	if g != nil {
+		g.inflight.Add(1)
+	}
+	r.mu.RUnlock()
+
+	switch {
+	case closed:
+		logger.Warn("reject execution: executor closed")
+		return ErrAlreadyClosed
+	case g == nil:
+		logger.Warn("reject execution: no executor loaded")
+		return fmt.Errorf("%w: no executor loaded", ErrExecutorNotSet)
+	}
+	defer g.inflight.Done()
+
+	logger.Debug("executing with reloaded executor", "generation", g.id)
+	return g.executor.Execute(ctx, cmd)
+}
+
+// drain waits for the executions on the generation to finish (or the
+// DrainTimeout to expire), and closes its executor.
+func (r *ReloadableExecutor) drain(g *reloadGeneration) error {
+	logger := orGlobalLogger(r.Logger).With("field", "rexec.ReloadableExecutor.drain", "generation", g.id)
+
+	drained := make(chan struct{})
+	go func() {
+		g.inflight.Wait()
+		close(drained)
+	}()
+
+	var timeout <-chan time.Time
+	if r.DrainTimeout > 0 {
+		timer := time.NewTimer(r.DrainTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-drained:
+		logger.Debug("old executor drained")
+	case <-timeout:
+		logger.Warn("old executor drain timed out: closing it with executions in flight", "drainTimeout", r.DrainTimeout)
+	}
+
+	err := g.executor.Close()
+	logger.Info("old executor closed", "err", err)
+	return err
+}
+
+// Generation returns the number of successful reloads: the generation of the
+// current executor, from 1. It is 0 if no executor is loaded.
+func (r *ReloadableExecutor) Generation() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.current == nil {
+		return 0
+	}
+	return r.current.id
+}
+
+// Close drains and closes the current executor, waits for the old ones being
+// drained, and rejects further executions and reloads.
+func (r *ReloadableExecutor) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrAlreadyClosed
+	}
+	r.closed = true
+	g := r.current
+	r.current = nil
+	r.mu.Unlock()
+
+	var err error
+	if g != nil {
+		err = r.drain(g)
+	}
+	r.drains.Wait()
+	return err
+}
+
+func (r *ReloadableExecutor) Validate() error {
+	if r == nil {
+		return ErrNilExecutor
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.current == nil {
+		return fmt.Errorf("%w: no executor loaded", ErrExecutorBadConfig)
+	}
+	return r.current.executor.Validate()
+}
+
+// TargetInfo returns the TargetInfo of the current executor.
+func (r *ReloadableExecutor) TargetInfo() TargetInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.current == nil {
+		return TargetInfo{Kind: "Reloadable"}
+	}
+	return TargetOf(r.current.executor)
+}
+
+// ReloadableExecutor errors
+var (
+	ErrReloadRejected = errors.New("reload rejected")
+)
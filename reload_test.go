@@ -0,0 +1,224 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingExecutor blocks the executions until release is closed,
+// and records if it is closed.
+type blockingExecutor struct {
+	started chan struct{}
+	release chan struct{}
+	closed  atomic.Bool
+}
+
+func newBlockingExecutor() *blockingExecutor {
+	return &blockingExecutor{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (e *blockingExecutor) Execute(ctx context.Context, cmd *Command) error {
+	e.started <- struct{}{}
+	select {
+	case <-e.release:
+		if e.closed.Load() {
+			return ErrAlreadyClosed
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *blockingExecutor) Close() error {
+	e.closed.Store(true)
+	return nil
+}
+
+func (e *blockingExecutor) Validate() error {
+	if e == nil {
+		return ErrNilExecutor
+	}
+	return nil
+}
+
+func init() {
+	// the config is the executor itself
+	RegisterExecutorKind("test.blocking", func(config any) (ExecuteCloser, error) {
+		return config.(*blockingExecutor), nil
+	}, nil)
+}
+
+func blockingFactory(e *blockingExecutor) ExecutorFactory {
+	return ExecutorFactory{Kind: "test.blocking", Config: e}
+}
+
+func TestReloadableExecutor_Reload(t *testing.T) {
+	r, err := NewReloadableExecutor(ExecutorFactory{Shell: &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}}})
+	if err != nil {
+		t.Fatalf("❌ NewReloadableExecutor() error = %v", err)
+	}
+	defer r.Close()
+
+	run := func(want string) {
+		t.Helper()
+		var stdout strings.Builder
+		if err := r.Execute(context.Background(), &Command{Command: "echo hello", Stdout: &stdout}); err != nil {
+			t.Fatalf("❌ Execute() error = %v", err)
+		}
+		if got := strings.TrimSpace(stdout.String()); got != want {
+			t.Errorf("❌ Execute() stdout = %q, want %q", got, want)
+		}
+	}
+	run("hello")
+
+	// invalid configs are rejected, the old executor is kept
+	for _, f := range []ExecutorFactory{
+		{Shell: &ShellExecutor{}},
+		{KeepAliveSsh: &KeepAliveSshExecutor{Config: &SshClientConfig{Addr: "localhost:22", Auth: []SshAuth{{PrivateKeyPath: "/nonexistent"}}}}},
+		{},
+	} {
+		if err := r.Reload(f); !errors.Is(err, ErrReloadRejected) {
+			t.Errorf("❌ Reload(%v) error = %v, want %v", f, err, ErrReloadRejected)
+		}
+	}
+	if got := r.Generation(); got != 1 {
+		t.Errorf("❌ Generation() = %d after rejected reloads, want 1", got)
+	}
+	run("hello")
+
+	if err := r.Reload(ExecutorFactory{Shell: &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c", "echo reloaded; :"}}}); err != nil {
+		t.Fatalf("❌ Reload() error = %v", err)
+	}
+	if got := r.Generation(); got != 2 {
+		t.Errorf("❌ Generation() = %d, want 2", got)
+	}
+	run("reloaded")
+	t.Logf("✅ Reload() switches the executor, and rejects invalid configs")
+}
+
+func TestReloadableExecutor_drain(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		wantClosed   bool // the old one, while the execution is still running
+	}{
+		{name: "drain", drainTimeout: 0, wantClosed: false},
+		{name: "timeout", drainTimeout: 20 * time.Millisecond, wantClosed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, next := newBlockingExecutor(), newBlockingExecutor()
+			r := &ReloadableExecutor{DrainTimeout: tt.drainTimeout}
+			if err := r.Reload(blockingFactory(old)); err != nil {
+				t.Fatalf("❌ Reload() error = %v", err)
+			}
+
+			done := make(chan error)
+			go func() { done <- r.Execute(context.Background(), &Command{Command: "x"}) }()
+			<-old.started
+
+			if err := r.Reload(blockingFactory(next)); err != nil {
+				t.Fatalf("❌ Reload() error = %v", err)
+			}
+			// new executions go to the new executor
+			go func() { done <- r.Execute(context.Background(), &Command{Command: "y"}) }()
+			<-next.started
+
+			time.Sleep(50 * time.Millisecond)
+			if got := old.closed.Load(); got != tt.wantClosed {
+				t.Errorf("❌ old executor closed = %v with an execution in flight, want %v", got, tt.wantClosed)
+			}
+
+			close(old.release)
+			close(next.release)
+			<-done
+			<-done
+
+			if err := r.Close(); err != nil {
+				t.Errorf("❌ Close() error = %v", err)
+			}
+			if !old.closed.Load() || !next.closed.Load() {
+				t.Errorf("❌ after Close(): old closed = %v, new closed = %v, want both", old.closed.Load(), next.closed.Load())
+			}
+			if err := r.Execute(context.Background(), &Command{Command: "z"}); !errors.Is(err, ErrAlreadyClosed) {
+				t.Errorf("❌ Execute() after Close() error = %v, want %v", err, ErrAlreadyClosed)
+			}
+			if err := r.Reload(blockingFactory(newBlockingExecutor())); !errors.Is(err, ErrAlreadyClosed) {
+				t.Errorf("❌ Reload() after Close() error = %v, want %v", err, ErrAlreadyClosed)
+			}
+			t.Logf("✅ old executor drained and closed")
+		})
+	}
+}
+
+func TestReloadableExecutor_Watch(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "executor.yaml")
+	write := func(content string, age time.Duration) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// distinct mtimes, in case the file system has a coarse resolution
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("shell:\n  shellPath: /bin/sh\n  shellArgs: [-c]\n", time.Hour)
+
+	reloads := make(chan error, 16)
+	r := &ReloadableExecutor{
+		PollInterval: 10 * time.Millisecond,
+		OnReload:     func(err error) { reloads <- err },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	watched := make(chan error)
+	go func() { watched <- r.Watch(ctx, name) }()
+
+	next := func() error {
+		t.Helper()
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("❌ no reload")
+			return nil
+		}
+	}
+
+	if err := next(); err != nil || r.Generation() != 1 {
+		t.Fatalf("❌ initial load: error = %v, generation = %d", err, r.Generation())
+	}
+
+	write("shell:\n  shelPath: /bin/bash\n", time.Minute) // typo
+	if err := next(); !errors.Is(err, ErrReloadRejected) || !errors.Is(err, ErrUnknownConfigField) {
+		t.Errorf("❌ reload with a typo: error = %v, want %v", err, ErrUnknownConfigField)
+	}
+	if got := r.Generation(); got != 1 {
+		t.Errorf("❌ Generation() = %d after a rejected reload, want 1", got)
+	}
+
+	write("local: {}\n", 0)
+	if err := next(); err != nil || r.Generation() != 2 {
+		t.Errorf("❌ reload: error = %v, generation = %d, want 2", err, r.Generation())
+	}
+	if kind := r.TargetInfo().Kind; kind != "Local" {
+		t.Errorf("❌ TargetInfo().Kind = %q, want Local", kind)
+	}
+
+	cancel()
+	if err := <-watched; !errors.Is(err, context.Canceled) {
+		t.Errorf("❌ Watch() error = %v, want %v", err, context.Canceled)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("❌ Close() error = %v", err)
+	}
+	t.Logf("✅ Watch() reloads the changed config file")
+}
@@ -0,0 +1,240 @@
+package rexec
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// This file provides the rendering of Commands as text/template templates
+// against per-host variables, e.g. for FanOut:
+//
+//	cmd := &rexec.Command{Command: "systemctl restart {{.service}}"}
+//	rendered, err := cmd.Render(map[string]string{"service": "nginx"})
+//	// rendered.Command: "systemctl restart nginx"
+
+// Render returns a clone of the command (see Clone) with the Command, Workdir
+// and the Env values rendered as text/template templates against the vars.
+//
+// Every value interpolated into the Command by an action ({{.name}}, {{printf
+// ...}}, ...) is shell-quoted as a whole word (see ShellQuote), so the vars
+// cannot inject shell syntax into it. For that, the actions must stand as
+// words of their own: an action inside single or double quotes, or right
+// after a backslash, is an error, e.g. with x=`$(id)`, `echo "{{.x}}"` would
+// run id. So are the {{template}} and {{block}} actions in the Command. The
+// Workdir and the Env values are rendered as is: they are checked against
+// WorkdirDangerous and EnvDangerous by Validate.
+//
+// A reference to a var that is not defined is an error, instead of an empty
+// string. The values of the vars with secret keys (see IsSecretEnvKey) are
+// added to the Secrets of the clone.
+//
+// Render should be called before the command is validated and executed.
+func (e *Command) Render(vars map[string]string) (*Command, error) {
+	if e == nil {
+		return nil, ErrNilCommand
+	}
+
+	c := e.Clone()
+	var err error
+	if c.Command, err = renderTemplate("Command", e.Command, vars, true); err != nil {
+		return nil, err
+	}
+	if c.Workdir, err = renderTemplate("Workdir", e.Workdir, vars, false); err != nil {
+		return nil, err
+	}
+	for k, v := range e.Env {
+		if c.Env[k], err = renderTemplate("Env."+k, v, vars, false); err != nil {
+			return nil, err
+		}
+	}
+
+	for k, v := range vars {
+		if v != "" && IsSecretEnvKey(k) {
+			c.Secrets = append(c.Secrets, v)
+		}
+	}
+	return c, nil
+}
+
+// renderTemplate renders the text as a template named name against the vars,
+// with the outputs of the actions shell-quoted if quote is true.
+func renderTemplate(name, text string, vars map[string]string, quote bool) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{shellQuoteFunc: ShellQuote}).
+		Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrRenderCommand, err)
+	}
+	if quote {
+		for _, t := range tmpl.Templates() {
+			if _, err := checkActionsQuoting(t.Tree, t.Tree.Root, shellState{}); err != nil {
+				return "", fmt.Errorf("%w: %w", ErrRenderCommand, err)
+			}
+			quoteActions(t.Tree.Root)
+		}
+	}
+
+	var b strings.Builder
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrRenderCommand, err)
+	}
+	return b.String(), nil
+}
+
+// shellQuoteFunc is the name of ShellQuote in the templates.
+const shellQuoteFunc = "shellQuote"
+
+// quoteActions pipes the outputs of the actions in the node (recursively) to
+// ShellQuote, as html/template does with its escapers.
+func quoteActions(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			quoteActions(child)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 {
+			return // {{$x := ...}} outputs nothing
+		}
+		ident := parse.NewIdentifier(shellQuoteFunc).SetTree(nil).SetPos(n.Pos)
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{ident},
+		})
+	case *parse.IfNode:
+		quoteActions(n.List)
+		quoteActions(n.ElseList)
+	case *parse.RangeNode:
+		quoteActions(n.List)
+		quoteActions(n.ElseList)
+	case *parse.WithNode:
+		quoteActions(n.List)
+		quoteActions(n.ElseList)
+	}
+}
+
+// shellState is where the shell is in a command, while it is scanned.
+type shellState struct {
+	quote   byte // '\'' or '"' inside quotes, or 0
+	escaped bool // right after a backslash
+}
+
+// scan returns the state after the text.
+func (st shellState) scan(text string) shellState {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case st.escaped:
+			st.escaped = false
+		case st.quote == '\'':
+			if c == '\'' {
+				st.quote = 0
+			}
+		case c == '\\':
+			st.escaped = true
+		case st.quote == '"':
+			if c == '"' {
+				st.quote = 0
+			}
+		case c == '\'' || c == '"':
+			st.quote = c
+		}
+	}
+	return st
+}
+
+// checkActionsQuoting returns an error if an action in the node (recursively)
+// outputs inside shell quotes or right after a backslash, where ShellQuote
+// does not protect the value. It returns the state after the node.
+func checkActionsQuoting(tree *parse.Tree, node parse.Node, st shellState) (shellState, error) {
+	// the branches of a control must end in the same state: the state after
+	// the control would be ambiguous otherwise.
+	branches := func(list, elseList *parse.ListNode) (shellState, error) {
+		end, err := checkActionsQuoting(tree, list, st)
+		if err != nil {
+			return st, err
+		}
+		elseEnd, err := checkActionsQuoting(tree, elseList, st)
+		if err != nil {
+			return st, err
+		}
+		if end != elseEnd {
+			location, _ := tree.ErrorContext(node)
+			return st, fmt.Errorf("%s: the branches end with different shell quotes", location)
+		}
+		return end, nil
+	}
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return st, nil
+		}
+		for _, child := range n.Nodes {
+			var err error
+			if st, err = checkActionsQuoting(tree, child, st); err != nil {
+				return st, err
+			}
+		}
+	case *parse.TextNode:
+		st = st.scan(string(n.Text))
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) == 0 && (st.quote != 0 || st.escaped) {
+			location, _ := tree.ErrorContext(n)
+			return st, fmt.Errorf("%s: action inside shell quotes or after a backslash: "+
+				"it is quoted as a word of its own, e.g. use {{.x}} instead of \"{{.x}}\"", location)
+		}
+	case *parse.TemplateNode:
+		// the called template is checked from its own start, not from where
+		// it is called: its actions could end up inside quotes.
+		location, _ := tree.ErrorContext(n)
+		return st, fmt.Errorf("%s: {{template}} and {{block}} are not supported in commands", location)
+	case *parse.IfNode:
+		return branches(n.List, n.ElseList)
+	case *parse.RangeNode:
+		return branches(n.List, n.ElseList)
+	case *parse.WithNode:
+		return branches(n.List, n.ElseList)
+	}
+	return st, nil
+}
+
+// shellSafe matches the strings that need no quoting in a shell.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// ShellQuote returns the value formatted by fmt.Sprint, quoted as a single
+// word for POSIX shells: in single quotes unless it contains only safe
+// characters, e.g.
+//
+//	ShellQuote("nginx")         // nginx
+//	ShellQuote("my file")       // 'my file'
+//	ShellQuote("it's; rm -rf")  // 'it'\''s; rm -rf'
+//	ShellQuote("")              // ''
+func ShellQuote(v any) string {
+	s := fmt.Sprint(v)
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// render errors
+var (
+	ErrRenderCommand = errors.New("failed to render command")
+)
@@ -0,0 +1,191 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		v    any
+		want string
+	}{
+		{"nginx", "nginx"},
+		{"/srv/app-1.2_x", "/srv/app-1.2_x"},
+		{42, "42"},
+		{"", "''"},
+		{"my file", "'my file'"},
+		{"it's; rm -rf /", `'it'\''s; rm -rf /'`},
+		{"$(id)", "'$(id)'"},
+		{"a\nb", "'a\nb'"},
+	}
+	for _, tt := range tests {
+		if got := ShellQuote(tt.v); got != tt.want {
+			t.Errorf("❌ ShellQuote(%q) = %q, want %q", tt.v, got, tt.want)
+		} else {
+			t.Logf("✅ ShellQuote(%q) = %s", tt.v, got)
+		}
+	}
+}
+
+func TestCommand_Render(t *testing.T) {
+	vars := map[string]string{
+		"service":  "nginx",
+		"evil":     "x; rm -rf /",
+		"dir":      "/srv/app",
+		"DB_TOKEN": "s3cr3t",
+		"env":      "prod",
+	}
+	tests := []struct {
+		name    string
+		cmd     *Command
+		want    *Command
+		wantErr string
+	}{
+		{
+			name: "plain",
+			cmd:  &Command{Command: "uptime", Workdir: "/tmp"},
+			want: &Command{Command: "uptime", Workdir: "/tmp"},
+		},
+		{
+			name: "vars",
+			cmd:  &Command{Command: "systemctl restart {{.service}}", Workdir: "{{.dir}}", Env: map[string]string{"ENV": "{{.env}}", "K": "v"}},
+			want: &Command{Command: "systemctl restart nginx", Workdir: "/srv/app", Env: map[string]string{"ENV": "prod", "K": "v"}},
+		},
+		{
+			name: "quoted",
+			cmd:  &Command{Command: "echo {{.evil}} {{printf \"%s-%s\" .service .evil}}"},
+			want: &Command{Command: `echo 'x; rm -rf /' 'nginx-x; rm -rf /'`},
+		},
+		{
+			name: "controls",
+			cmd:  &Command{Command: `{{if eq .env "prod"}}deploy --prod{{else}}deploy{{end}} {{with .service}}{{.}}{{end}}`},
+			want: &Command{Command: "deploy --prod nginx"},
+		},
+		{
+			name: "variables",
+			cmd:  &Command{Command: `{{$s := .service}}echo {{$s}}`},
+			want: &Command{Command: "echo nginx"},
+		},
+		{
+			name: "secrets",
+			cmd:  &Command{Command: "login {{.DB_TOKEN}}"},
+			want: &Command{Command: "login s3cr3t"},
+		},
+		{
+			name:    "missingCommand",
+			cmd:     &Command{Command: "systemctl restart {{.nope}}"},
+			wantErr: `map has no entry for key "nope"`,
+		},
+		{
+			name:    "missingEnv",
+			cmd:     &Command{Command: "ls", Env: map[string]string{"X": "{{.nope}}"}},
+			wantErr: `Env.X`,
+		},
+		{
+			name: "quotesAroundAction",
+			cmd:  &Command{Command: `echo "it's" {{.evil}} '"'`},
+			want: &Command{Command: `echo "it's" 'x; rm -rf /' '"'`},
+		},
+		{
+			name:    "actionInDoubleQuotes",
+			cmd:     &Command{Command: `echo "{{.evil}}"`},
+			wantErr: "action inside shell quotes",
+		},
+		{
+			name:    "actionInSingleQuotes",
+			cmd:     &Command{Command: `echo 'x {{.evil}}'`},
+			wantErr: "action inside shell quotes",
+		},
+		{
+			name:    "actionAfterBackslash",
+			cmd:     &Command{Command: `echo \{{.evil}}`},
+			wantErr: "after a backslash",
+		},
+		{
+			name:    "actionInQuotedBranch",
+			cmd:     &Command{Command: `echo "{{if .env}}{{.evil}}{{end}}"`},
+			wantErr: "action inside shell quotes",
+		},
+		{
+			name:    "unbalancedBranches",
+			cmd:     &Command{Command: `echo {{if .env}}"{{else}}x{{end}}{{.evil}}"`},
+			wantErr: "different shell quotes",
+		},
+		{
+			name:    "templateInQuotes",
+			cmd:     &Command{Command: `{{define "x"}}{{.evil}}{{end}}echo "{{template "x" .}}"`},
+			wantErr: "not supported in commands",
+		},
+		{
+			name:    "blockInQuotes",
+			cmd:     &Command{Command: `echo "{{block "y" .}}{{.evil}}{{end}}"`},
+			wantErr: "not supported in commands",
+		},
+		{
+			name:    "badTemplate",
+			cmd:     &Command{Command: "echo {{.service"},
+			wantErr: "unclosed action",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cmd.Render(vars)
+			switch {
+			case tt.wantErr != "":
+				if !errors.Is(err, ErrRenderCommand) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("❌ Render() error = %v, want %q", err, tt.wantErr)
+				} else {
+					t.Logf("✅ Render() error = %v", err)
+				}
+			case err != nil:
+				t.Errorf("❌ Render() error = %v", err)
+			case got.Command != tt.want.Command || got.Workdir != tt.want.Workdir:
+				t.Errorf("❌ Render() = %q in %q, want %q in %q", got.Command, got.Workdir, tt.want.Command, tt.want.Workdir)
+			case !reflect.DeepEqual(got.Env, tt.want.Env):
+				t.Errorf("❌ Render() env = %v, want %v", got.Env, tt.want.Env)
+			case got.Redact("s3cr3t") != redactedMask:
+				t.Errorf("❌ Render() does not add the secret vars to the Secrets: %v", got.Secrets)
+			default:
+				t.Logf("✅ Render() = %v", got)
+			}
+		})
+	}
+
+	// rendered values are checked by Validate
+	rendered, err := (&Command{Command: "ls", Workdir: "{{.evil}}"}).Render(vars)
+	if err != nil || !errors.Is(rendered.Validate(), ErrContainsDangerous) {
+		t.Errorf("❌ rendered workdir is not validated: %v", err)
+	}
+}
+
+func TestFanOut_Run_template(t *testing.T) {
+	shell := &ShellExecutor{ShellPath: "sh", ShellArgs: []string{"-c"}}
+	fanOut := &FanOut{
+		Targets: []FanOutTarget{
+			{Name: "a", Executor: shell, Vars: map[string]string{"name": "alice"}},
+			{Name: "b", Executor: shell, Vars: map[string]string{"name": "bob; echo pwned"}},
+			{Name: "c", Executor: shell},
+		},
+		Template: true,
+	}
+	results, err := fanOut.Run(context.Background(), &Command{Command: "echo hello {{.name}}"})
+	if !errors.Is(err, ErrFanOutFailed) {
+		t.Errorf("❌ Run() error = %v, want ErrFanOutFailed", err)
+	}
+
+	want := []string{"hello alice\n", "hello bob; echo pwned\n", ""}
+	for i, r := range results {
+		if string(r.Stdout) != want[i] {
+			t.Errorf("❌ %s: stdout = %q, want %q", r.Name, r.Stdout, want[i])
+		} else {
+			t.Logf("✅ %s: %q %v", r.Name, r.Stdout, r.Err)
+		}
+	}
+	if !errors.Is(results[2].Err, ErrRenderCommand) {
+		t.Errorf("❌ c: error = %v, want ErrRenderCommand", results[2].Err)
+	}
+}
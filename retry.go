@@ -0,0 +1,362 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	osexec "os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// This file implements a RetryExecutor that retries the execution of a
+// command on transient failures, such as a failed SSH dial or a connection
+// dropped in the middle of a command.
+
+// RetryExecutor is an Executor decorator that executes the command with the
+// underlying Executor, and retries it according to the Policy if the
+// execution fails with a retryable error.
+//
+// Since a Command can only be executed once, each attempt executes a clone of
+// the given command (see Command.Clone), and the Status of the given command
+// is updated after each attempt.
+//
+// All the attempts share the Stdin, Stdout and Stderr of the given command.
+// If the Stdin is an io.Seeker (e.g. a *bytes.Reader), it is rewound to where
+// it was before the first attempt for each retry; otherwise, the retries read
+// what is left of it. Outputs of failed attempts are not rolled back.
+type RetryExecutor struct {
+	// Executor is the underlying executor to run each attempt.
+	Executor Executor
+	// Policy decides when and how many times to retry.
+	Policy RetryPolicy
+
+	// OnAttempt, if not nil, is called after each attempt, with the record of
+	// that attempt. It is called synchronously in the Execute goroutine.
+	OnAttempt func(RetryAttempt) `json:"-"`
+
+	// Logger, if not nil, overrides the global Logger for this executor.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+}
+
+var (
+	_ Executor      = (*RetryExecutor)(nil)
+	_ ExecuteCloser = (*RetryExecutor)(nil)
+)
+
+// RetryPolicy configures the retries of a RetryExecutor.
+//
+// The backoff before the n-th retry (n >= 1) is:
+//
+//	min(BackoffMillis * Multiplier ^ (n-1), MaxBackoffMillis) ± Jitter
+//
+// If Multiplier <= 1, the backoff is fixed to BackoffMillis.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// If MaxAttempts <= 0, DefaultRetryMaxAttempts is used.
+	MaxAttempts int
+	// BackoffMillis is the backoff before the first retry, in milliseconds.
+	BackoffMillis int
+	// MaxBackoffMillis caps the backoff. Zero means no cap.
+	MaxBackoffMillis int
+	// Multiplier enables exponential backoff if it is > 1.
+	Multiplier float64
+	// Jitter randomizes each backoff by up to ±Jitter (a fraction in [0, 1])
+	// of it.
+	Jitter float64
+
+	// Retryable classifies the errors of attempts.
+	// If nil, IsRetryable is used.
+	Retryable func(error) bool `json:"-"`
+}
+
+// DefaultRetryMaxAttempts is the MaxAttempts of a RetryPolicy that does not
+// set it.
+var DefaultRetryMaxAttempts = 3
+
+// maxAttempts returns the MaxAttempts with default applied.
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts <= 0 {
+		return DefaultRetryMaxAttempts
+	}
+	return p.MaxAttempts
+}
+
+// retryable reports whether err is worth another attempt.
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff returns the time to wait before the given retry (1-based).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	if p.BackoffMillis <= 0 || retry <= 0 {
+		return 0
+	}
+
+	b := float64(p.BackoffMillis) * float64(time.Millisecond)
+	if p.Multiplier > 1 {
+		b *= math.Pow(p.Multiplier, float64(retry-1))
+	}
+	if p.MaxBackoffMillis > 0 {
+		b = math.Min(b, float64(p.MaxBackoffMillis)*float64(time.Millisecond))
+	}
+	if p.Jitter > 0 {
+		b += (rand.Float64()*2 - 1) * math.Min(p.Jitter, 1) * b
+	}
+	if b > float64(math.MaxInt64) || math.IsNaN(b) {
+		b = float64(math.MaxInt64)
+	}
+
+	return max(time.Duration(b), 0)
+}
+
+// RetryAttempt is the record of a single attempt made by a RetryExecutor.
+type RetryAttempt struct {
+	Attempt  int           // 1-based attempt number
+	Start    time.Time     // when the attempt started
+	Duration time.Duration // how long the attempt took
+	Status   int           // the Status of the command after the attempt
+	Err      error         // the error of the attempt, nil if succeeded
+}
+
+// RetryError is returned by RetryExecutor.Execute if all attempts failed or
+// a non-retryable error occurred.
+// It records all the attempts made.
+type RetryError struct {
+	Attempts []RetryAttempt
+}
+
+func (e *RetryError) Error() string {
+	if len(e.Attempts) == 0 {
+		return "no attempt made"
+	}
+	last := e.Attempts[len(e.Attempts)-1]
+	return fmt.Sprintf("failed after %d attempt(s): %v", len(e.Attempts), last.Err)
+}
+
+// Unwrap returns the errors of all attempts, so that errors.Is and errors.As
+// can match any of them.
+func (e *RetryError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Attempts))
+	for _, a := range e.Attempts {
+		if a.Err != nil {
+			errs = append(errs, a.Err)
+		}
+	}
+	return errs
+}
+
+// Execute the command with retries.
+//
+// It returns nil if any attempt succeeded, or a *RetryError recording all
+// the attempts otherwise.
+func (e *RetryExecutor) Execute(ctx context.Context, cmd *Command) error {
+	logger := loggerFor(ctx, e.Logger).With("field", "rexec.RetryExecutor.Execute", "cmd", cmd)
+
+	if err := ctx.Err(); err != nil {
+		logger.Info("skipping execution: context done", "ctxErr", err)
+		return err
+	}
+
+	if e.Executor == nil {
+		logger.Warn("reject execution: nil underlying executor")
+		return fmt.Errorf("%w: nil underlying executor of RetryExecutor", ErrInternalError)
+	}
+
+	if cmd == nil {
+		logger.Warn("reject execution: nil command")
+		return ErrNilCommand
+	}
+
+	if !cmd.started.CompareAndSwap(false, true) {
+		// compare-and-swap return true for the first call
+		// and false for later calls.
+		logger.Warn("reject execution: command already started")
+		return ErrStartedCommand
+	}
+
+	cmd.Status = -1
+
+	// set the defaults on the given command, instead of on each clone,
+	// so that the outputs of all attempts go to the same place.
+	cmd.setDefaultStdio()
+	rewindStdin := stdinRewinder(cmd.Stdin)
+
+	maxAttempts := e.Policy.maxAttempts()
+	attempts := make([]RetryAttempt, 0, 1)
+
+	for i := 1; i <= maxAttempts; i++ {
+		if i > 1 {
+			backoff := e.Policy.backoff(i - 1)
+			logger.Info("retrying command", "attempt", i, "backoff", backoff)
+
+			if err := sleepContext(ctx, backoff); err != nil {
+				logger.Info("stop retrying: context done", "ctxErr", err)
+				attempts = append(attempts, RetryAttempt{Attempt: i, Start: time.Now(), Status: -1, Err: err})
+				break
+			}
+			if err := rewindStdin(); err != nil {
+				logger.Warn("stop retrying: failed to rewind stdin", "err", err)
+				attempts = append(attempts, RetryAttempt{Attempt: i, Start: time.Now(), Status: -1, Err: err})
+				break
+			}
+		}
+
+		attempt := cmd.Clone()
+
+		start := time.Now()
+		err := e.Executor.Execute(ctx, attempt)
+		record := RetryAttempt{
+			Attempt:  i,
+			Start:    start,
+			Duration: time.Since(start),
+			Status:   attempt.Status,
+			Err:      err,
+		}
+		attempts = append(attempts, record)
+		cmd.Status = attempt.Status
+
+		if e.OnAttempt != nil {
+			e.OnAttempt(record)
+		}
+
+		if err == nil {
+			logger.Debug("attempt succeeded", "attempt", i)
+			return nil
+		}
+		if !e.Policy.retryable(err) {
+			logger.Warn("attempt failed with non-retryable error", "attempt", i, "err", err)
+			break
+		}
+		logger.Warn("attempt failed with retryable error", "attempt", i, "err", err)
+	}
+
+	return &RetryError{Attempts: attempts}
+}
+
+// stdinRewinder returns a function that seeks the stdin back to the current
+// offset, if it is an io.Seeker. Otherwise, the returned function is a no-op.
+func stdinRewinder(stdin io.Reader) func() error {
+	seeker, ok := stdin.(io.Seeker)
+	if !ok {
+		return func() error { return nil }
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return func() error { return nil }
+	}
+	return func() error {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+}
+
+// sleepContext sleeps for d, or returns the ctx.Err() if ctx is done before.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// IsRetryable is the default classification of the errors returned by the
+// executors in this package. It reports whether the error is transient so
+// that executing the command again may succeed:
+//
+//   - Retryable: failures to dial the SSH server (except for authentication
+//     and host key errors), failures to open an SSH session, and SSH
+//     connections lost before the command exits.
+//   - Not retryable: nil, context cancellations and deadlines, invalid or
+//     already started commands, commands exited with a non-zero status,
+//     and anything else.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var (
+		sshExitError     *ssh.ExitError
+		procExitError    *osexec.ExitError
+		sshMissingStatus *ssh.ExitMissingError
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, ErrNilCommand),
+		errors.Is(err, ErrInvalidCommand),
+		errors.Is(err, ErrStartedCommand),
+		errors.Is(err, ErrParseCommand),
+		errors.Is(err, ErrBadSshConfig):
+		return false
+	case errors.As(err, &sshExitError), errors.As(err, &procExitError):
+		return false
+	case errors.Is(err, ErrSshSession):
+		return true
+	case errors.Is(err, ErrSshDial):
+		return !isSshHandshakeRejected(err)
+	case errors.As(err, &sshMissingStatus), errors.Is(err, io.EOF):
+		// the connection is lost before the command exits.
+		return true
+	}
+	return false
+}
+
+// isSshHandshakeRejected reports whether the SSH dial error is a rejection
+// that will not go away by itself: an authentication failure or a host key
+// mismatch.
+func isSshHandshakeRejected(err error) bool {
+	msg := err.Error()
+	for _, s := range []string{
+		"unable to authenticate",
+		"no supported methods remain",
+		"key is unknown",
+		"key mismatch",
+		"host keys are denied",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// impl ExecuteCloser for RetryExecutor.
+
+// Close closes the underlying Executor if it is an ExecuteCloser.
+func (e *RetryExecutor) Close() error {
+	if closer, ok := e.Executor.(ExecuteCloser); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (e *RetryExecutor) Validate() error {
+	if e == nil {
+		return ErrNilExecutor
+	}
+	if e.Executor == nil {
+		return fmt.Errorf("%w: underlying executor is nil", ErrExecutorBadConfig)
+	}
+	if v, ok := e.Executor.(ExecuteCloser); ok {
+		return v.Validate()
+	}
+	return nil
+}
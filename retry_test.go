@@ -0,0 +1,228 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	osexec "os/exec"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// flakyExecutor fails the first `failures` executions with err,
+// and succeeds afterward. It reads all the stdin and echoes it to stdout
+// in each execution.
+type flakyExecutor struct {
+	failures int
+	err      error
+
+	calls int
+}
+
+func (e *flakyExecutor) Execute(ctx context.Context, cmd *Command) error {
+	e.calls++
+	if !cmd.started.CompareAndSwap(false, true) {
+		return ErrStartedCommand
+	}
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+	in, _ := io.ReadAll(cmd.Stdin)
+	_, _ = fmt.Fprintf(cmd.Stdout, "call %d: %s\n", e.calls, in)
+
+	if e.calls <= e.failures {
+		cmd.Status = -1
+		return e.err
+	}
+	cmd.Status = 0
+	return nil
+}
+
+func TestRetryExecutor_Execute(t *testing.T) {
+	dialErr := fmt.Errorf("%w: dial tcp: connection refused", ErrSshDial)
+
+	tests := []struct {
+		name         string
+		executor     *flakyExecutor
+		policy       RetryPolicy
+		wantErr      bool
+		wantAttempts int
+		wantStdout   string
+	}{
+		{
+			name:         "noFailure",
+			executor:     &flakyExecutor{failures: 0},
+			policy:       RetryPolicy{MaxAttempts: 3},
+			wantErr:      false,
+			wantAttempts: 1,
+			wantStdout:   "call 1: in\n",
+		},
+		{
+			name:         "retrySucceeded",
+			executor:     &flakyExecutor{failures: 2, err: dialErr},
+			policy:       RetryPolicy{MaxAttempts: 3, BackoffMillis: 10, Multiplier: 2},
+			wantErr:      false,
+			wantAttempts: 3,
+			wantStdout:   "call 1: in\ncall 2: in\ncall 3: in\n",
+		},
+		{
+			name:         "retryExhausted",
+			executor:     &flakyExecutor{failures: 5, err: dialErr},
+			policy:       RetryPolicy{MaxAttempts: 2, BackoffMillis: 10},
+			wantErr:      true,
+			wantAttempts: 2,
+			wantStdout:   "call 1: in\ncall 2: in\n",
+		},
+		{
+			name:         "nonRetryable",
+			executor:     &flakyExecutor{failures: 1, err: fmt.Errorf("%w: bad", ErrInvalidCommand)},
+			policy:       RetryPolicy{MaxAttempts: 3},
+			wantErr:      true,
+			wantAttempts: 1,
+			wantStdout:   "call 1: in\n",
+		},
+		{
+			name:         "customRetryable",
+			executor:     &flakyExecutor{failures: 1, err: errors.New("whatever")},
+			policy:       RetryPolicy{MaxAttempts: 3, Retryable: func(error) bool { return true }},
+			wantErr:      false,
+			wantAttempts: 2,
+			wantStdout:   "call 1: in\ncall 2: in\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts []RetryAttempt
+			e := &RetryExecutor{
+				Executor:  tt.executor,
+				Policy:    tt.policy,
+				OnAttempt: func(a RetryAttempt) { attempts = append(attempts, a) },
+			}
+
+			stdout := &bytes.Buffer{}
+			cmd := &Command{Command: "echo", Stdin: bytes.NewReader([]byte("in")), Stdout: stdout}
+
+			err := e.Execute(context.Background(), cmd)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("❌ RetryExecutor.Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(attempts) != tt.wantAttempts {
+				t.Errorf("❌ RetryExecutor.Execute() made %d attempts, want %d", len(attempts), tt.wantAttempts)
+			}
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("❌ RetryExecutor.Execute() stdout = %q, want %q", got, tt.wantStdout)
+			}
+
+			var retryErr *RetryError
+			if tt.wantErr {
+				if !errors.As(err, &retryErr) || len(retryErr.Attempts) != tt.wantAttempts {
+					t.Errorf("❌ RetryExecutor.Execute() error = %#v, want *RetryError with %d attempts", err, tt.wantAttempts)
+				}
+				if !errors.Is(err, tt.executor.err) {
+					t.Errorf("❌ RetryExecutor.Execute() error = %v, want wrapping %v", err, tt.executor.err)
+				}
+			} else if cmd.Status != 0 {
+				t.Errorf("❌ RetryExecutor.Execute() status = %d, want 0", cmd.Status)
+			}
+
+			if err := e.Execute(context.Background(), cmd); !errors.Is(err, ErrStartedCommand) {
+				t.Errorf("❌ RetryExecutor.Execute() again error = %v, want %v", err, ErrStartedCommand)
+			}
+			t.Logf("✅ RetryExecutor.Execute() error = %v, attempts = %d", err, len(attempts))
+		})
+	}
+}
+
+func TestRetryExecutor_Execute_exitStatus(t *testing.T) {
+	e := &RetryExecutor{
+		Executor: &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}},
+		Policy:   RetryPolicy{MaxAttempts: 3},
+	}
+	cmd := &Command{Command: "exit 3"}
+
+	err := e.Execute(context.Background(), cmd)
+
+	var exitErr *osexec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("❌ RetryExecutor.Execute() error = %v, want *exec.ExitError", err)
+	}
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || len(retryErr.Attempts) != 1 {
+		t.Errorf("❌ RetryExecutor.Execute() error = %v, want 1 attempt", err)
+	}
+	if cmd.Status != 3 {
+		t.Errorf("❌ RetryExecutor.Execute() status = %d, want 3", cmd.Status)
+	} else {
+		t.Logf("✅ RetryExecutor.Execute() exit status is not retried: %v", err)
+	}
+}
+
+func TestRetryExecutor_Execute_cancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	e := &RetryExecutor{
+		Executor: &flakyExecutor{failures: 10, err: fmt.Errorf("%w: EOF", ErrSshSession)},
+		Policy:   RetryPolicy{MaxAttempts: 10, BackoffMillis: 1000},
+	}
+
+	start := time.Now()
+	err := e.Execute(ctx, &Command{Command: "echo"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("❌ RetryExecutor.Execute() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("❌ RetryExecutor.Execute() took %v, want to stop backoff on context done", elapsed)
+	} else {
+		t.Logf("✅ RetryExecutor.Execute() stopped in %v: %v", elapsed, err)
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{BackoffMillis: 100, Multiplier: 2, MaxBackoffMillis: 500}
+	want := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond}
+	for retry, w := range want {
+		if got := p.backoff(retry); got != w {
+			t.Errorf("❌ RetryPolicy.backoff(%d) = %v, want %v", retry, got, w)
+		}
+	}
+
+	p.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < 80*time.Millisecond || got > 120*time.Millisecond {
+			t.Fatalf("❌ RetryPolicy.backoff(1) = %v, want 100ms ± 20%%", got)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", context.Canceled, false},
+		{"invalidCommand", fmt.Errorf("%w: %w", ErrInvalidCommand, ErrEmptyCommand), false},
+		{"startedCommand", ErrStartedCommand, false},
+		{"sshExitStatus", &ssh.ExitError{}, false},
+		{"procExitStatus", &osexec.ExitError{}, false},
+		{"dialRefused", fmt.Errorf("%w: dial tcp 127.0.0.1:22: connect: connection refused", ErrSshDial), true},
+		{"dialAuth", fmt.Errorf("%w: ssh: handshake failed: ssh: unable to authenticate", ErrSshDial), false},
+		{"dialHostKey", fmt.Errorf("%w: ssh: handshake failed: knownhosts: key is unknown", ErrSshDial), false},
+		{"session", fmt.Errorf("%w: EOF", ErrSshSession), true},
+		{"exitMissing", &ssh.ExitMissingError{}, true},
+		{"other", errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("❌ IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
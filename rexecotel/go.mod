@@ -0,0 +1,29 @@
+module github.com/cdfmlr/rexec/v2/rexecotel
+
+go 1.22.2
+
+// rexec.Tracer is in rexec v2.4.0 and later. To work on rexecotel against
+// the rexec of this repository, use a local (git-ignored) go.work:
+//
+//	go work init . ./rexecotel
+//	go work edit -replace github.com/cdfmlr/rexec/v2@v2.4.0=.
+require (
+	github.com/cdfmlr/rexec/v2 v2.4.0
+	go.opentelemetry.io/otel v1.34.0
+	go.opentelemetry.io/otel/sdk v1.34.0
+	go.opentelemetry.io/otel/trace v1.34.0
+)
+
+require (
+	github.com/creack/pty v1.1.24 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
+	go.opentelemetry.io/otel/metric v1.34.0 // indirect
+	golang.org/x/crypto v0.31.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
@@ -0,0 +1,97 @@
+// Package rexecotel adapts OpenTelemetry tracing to rexec.Tracer.
+//
+//	executor := &rexec.KeepAliveSshExecutor{
+//		Config: config,
+//		Tracer: rexecotel.NewTracer(otel.GetTracerProvider()),
+//	}
+//
+// The spans of an execution are children of the OpenTelemetry span in the
+// ctx given to Execute.
+package rexecotel
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/cdfmlr/rexec/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope name of the tracers created by
+// NewTracer.
+const ScopeName = "github.com/cdfmlr/rexec/v2"
+
+// Tracer is a rexec.Tracer that starts OpenTelemetry spans.
+type Tracer struct {
+	Tracer trace.Tracer
+}
+
+var _ rexec.Tracer = (*Tracer)(nil)
+
+// NewTracer returns a Tracer with the tracer named ScopeName of the provider.
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{Tracer: provider.Tracer(ScopeName)}
+}
+
+// Start starts an OpenTelemetry span as a child of the span in ctx (if any).
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, rexec.Span) {
+	ctx, span := t.Tracer.Start(ctx, name, trace.WithAttributes(Attributes(attrs...)...))
+	return ctx, &otelSpan{span: span}
+}
+
+// otelSpan adapts a trace.Span to rexec.Span.
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) SetAttributes(attrs ...slog.Attr) {
+	s.span.SetAttributes(Attributes(attrs...)...)
+}
+
+func (s *otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *otelSpan) End() {
+	s.span.End()
+}
+
+// Attributes converts slog attributes to OpenTelemetry ones.
+// Groups are flattened with dotted keys: "group.key".
+func Attributes(attrs ...slog.Attr) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = appendAttribute(kvs, "", a)
+	}
+	return kvs
+}
+
+func appendAttribute(kvs []attribute.KeyValue, prefix string, a slog.Attr) []attribute.KeyValue {
+	key := prefix + a.Key
+	v := a.Value.Resolve()
+
+	switch v.Kind() {
+	case slog.KindString:
+		return append(kvs, attribute.String(key, v.String()))
+	case slog.KindInt64:
+		return append(kvs, attribute.Int64(key, v.Int64()))
+	case slog.KindUint64:
+		return append(kvs, attribute.Int64(key, int64(v.Uint64())))
+	case slog.KindFloat64:
+		return append(kvs, attribute.Float64(key, v.Float64()))
+	case slog.KindBool:
+		return append(kvs, attribute.Bool(key, v.Bool()))
+	case slog.KindDuration:
+		return append(kvs, attribute.Int64(key, v.Duration().Nanoseconds()))
+	case slog.KindGroup:
+		for _, g := range v.Group() {
+			kvs = appendAttribute(kvs, key+".", g)
+		}
+		return kvs
+	default:
+		return append(kvs, attribute.String(key, v.String()))
+	}
+}
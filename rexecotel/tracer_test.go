@@ -0,0 +1,108 @@
+package rexecotel
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/cdfmlr/rexec/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newRecordingTracer() (*Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return NewTracer(provider), recorder
+}
+
+func TestTracer(t *testing.T) {
+	tracer, recorder := newRecordingTracer()
+
+	ctx, parent := tracer.Tracer.Start(context.Background(), "caller")
+	_, span := tracer.Start(ctx, "child", slog.String("host", "example.com:22"))
+	span.SetAttributes(slog.Int("status", 1))
+	span.RecordError(errors.New("boom"))
+	span.End()
+	parent.End()
+
+	ended := recorder.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("❌ got %d ended spans, want 2", len(ended))
+	}
+	child := ended[0]
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range child.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+
+	switch {
+	case child.Name() != "child":
+		t.Errorf("❌ span name = %q, want child", child.Name())
+	case child.Parent().SpanID() != parent.SpanContext().SpanID():
+		t.Errorf("❌ span is not a child of the caller span")
+	case attrs["host"].AsString() != "example.com:22" || attrs["status"].AsInt64() != 1:
+		t.Errorf("❌ span attrs = %v", child.Attributes())
+	case child.Status().Code != codes.Error || len(child.Events()) != 1:
+		t.Errorf("❌ span error not recorded: %v %v", child.Status(), child.Events())
+	default:
+		t.Logf("✅ span: %s %v", child.Name(), child.Attributes())
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	got := Attributes(
+		slog.String("s", "v"),
+		slog.Int("i", 1),
+		slog.Bool("b", true),
+		slog.Float64("f", 0.5),
+		slog.Duration("d", time.Second),
+		slog.Group("g", slog.String("k", "v")),
+		slog.Any("a", []int{1}),
+	)
+	want := []attribute.KeyValue{
+		attribute.String("s", "v"),
+		attribute.Int64("i", 1),
+		attribute.Bool("b", true),
+		attribute.Float64("f", 0.5),
+		attribute.Int64("d", int64(time.Second)),
+		attribute.String("g.k", "v"),
+		attribute.String("a", "[1]"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("❌ Attributes() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("❌ Attributes()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTracer_LocalExecutor(t *testing.T) {
+	tracer, recorder := newRecordingTracer()
+
+	ctx, parent := tracer.Tracer.Start(context.Background(), "caller")
+	executor := &rexec.LocalExecutor{}
+	err := executor.Execute(rexec.WithTracer(ctx, tracer), &rexec.Command{Command: "true"})
+	parent.End()
+	if err != nil {
+		t.Fatalf("❌ Execute() error = %v", err)
+	}
+
+	for _, span := range recorder.Ended() {
+		if span.Name() == "rexec.runProc" {
+			if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("❌ rexec.runProc is not a child of the caller span")
+			} else {
+				t.Logf("✅ rexec.runProc span: %v", span.Attributes())
+			}
+			return
+		}
+	}
+	t.Errorf("❌ no rexec.runProc span recorded")
+}
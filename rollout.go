@@ -0,0 +1,357 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file provides Rollout to run a command on hosts in batches (e.g. a
+// canary, then 10%, then the rest), gated by a failure budget and health
+// checks between the batches.
+
+// Rollout runs the same command on the targets batch by batch. Each batch is
+// run as a FanOut. Between the batches, the rollout stops if the failures
+// exceed the budget or the health check fails on the batch:
+//
+//	results, err := (&rexec.Rollout{
+//		Targets:     rexec.SshTargets(configs...),
+//		Batches:     []string{"1", "10%", "100%"}, // canary, 10%, the rest
+//		Pause:       30 * time.Second,
+//		MaxFailures: 2,
+//		HealthCheck: &rexec.Command{Command: "systemctl is-active myapp"},
+//	}).Run(ctx, &rexec.Command{Command: "deploy.sh"})
+//
+// The targets that are not run because the rollout stopped are skipped, with
+// an error wrapping ErrFanOutSkipped in their results.
+type Rollout struct {
+	// Targets to run the command on, in order.
+	Targets []FanOutTarget
+
+	// Batches are the sizes of the batches, in order: a number of targets
+	// ("1", "5") or a percentage of all the targets ("10%", rounded up).
+	// The last size is repeated until all the targets are run.
+	// If empty, all the targets are run in one batch.
+	Batches []string
+	// Concurrency is the maximum number of targets running at the same time
+	// in a batch. If <= 0, the whole batch runs at the same time.
+	Concurrency int
+	// Pause is the time to wait between the batches.
+	Pause time.Duration
+	// Template renders the command and the health checks for each target with
+	// its Vars (see FanOut.Template).
+	Template bool
+
+	// MaxFailures is the number of failed targets tolerated: the rollout
+	// stops after a batch that makes the failures exceed it.
+	// If < 0, the number of failures is not limited.
+	MaxFailures int
+	// MaxFailureRate, if > 0, stops the rollout after a batch that makes the
+	// ratio of failed targets to the finished ones exceed it, e.g. 0.1.
+	MaxFailureRate float64
+
+	// HealthCheck, if not nil, is run on each target of a batch after the
+	// command. The rollout stops if it fails on any of them.
+	HealthCheck *Command
+	// BatchHealthChecks are the health checks of the batches, by index
+	// (from 0), overriding the HealthCheck, e.g. a thorough check of the
+	// canary. A nil or missing one falls back to the HealthCheck.
+	BatchHealthChecks []*Command
+
+	// OnEvent, if not nil, is called with the progress of the rollout.
+	// The calls are serialized.
+	OnEvent func(RolloutEvent) `json:"-"`
+
+	// Logger, if not nil, overrides the global Logger for this rollout.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+}
+
+// RolloutEventKind is the kind of a RolloutEvent.
+type RolloutEventKind string
+
+const (
+	RolloutBatchStarted  RolloutEventKind = "batch_started"  // Targets: the batch
+	RolloutHostFinished  RolloutEventKind = "host_finished"  // Result: the command on a target
+	RolloutHealthChecked RolloutEventKind = "health_checked" // Result: the health check on a target
+	RolloutBatchFinished RolloutEventKind = "batch_finished" // Err: why the rollout stops, if it does
+	RolloutPaused        RolloutEventKind = "paused"         // before waiting the Pause
+	RolloutFinished      RolloutEventKind = "finished"       // Err: the error returned by Run
+)
+
+// RolloutEvent reports the progress of a Rollout.
+type RolloutEvent struct {
+	Kind RolloutEventKind
+
+	// Batch is the index of the current batch, from 1.
+	Batch int
+	// Batches is the number of batches.
+	Batches int
+	// Targets are the names of the targets in the batch.
+	Targets []string
+	// Result is the result of a target, for RolloutHostFinished and
+	// RolloutHealthChecked.
+	Result *HostResult
+
+	// Total, Done and Failed are the number of the targets: all of them,
+	// the finished ones and the failed ones.
+	Total, Done, Failed int
+
+	Err error
+}
+
+// Run runs the command tmpl on the targets, batch by batch.
+//
+// The tmpl is cloned for each target, as with FanOut.Run.
+//
+// It returns the results in the order of the Targets. The error wraps
+// ErrRolloutAborted if the rollout stopped before running all the targets,
+// ErrHealthCheckFailed (only) if it ran all of them but the health check
+// failed after the last batch, or ErrFanOutFailed if it ran all of them but
+// some failed.
+func (r *Rollout) Run(ctx context.Context, tmpl *Command) (results []HostResult, err error) {
+	logger := loggerFor(ctx, r.Logger).With("field", "rexec.Rollout.Run", "cmd", tmpl, "targets", len(r.Targets))
+
+	if tmpl == nil {
+		logger.Warn("reject rollout: nil command")
+		return nil, ErrNilCommand
+	}
+	sizes, err := r.plan()
+	if err != nil {
+		logger.Warn("reject rollout: invalid batches", "batches", r.Batches, "err", err)
+		return nil, err
+	}
+
+	var stdin []byte
+	if tmpl.Stdin != nil {
+		if stdin, err = io.ReadAll(tmpl.Stdin); err != nil {
+			logger.Warn("reject rollout: failed to read stdin", "err", err)
+			return nil, fmt.Errorf("%w: failed to read stdin: %w", ErrInvalidCommand, err)
+		}
+	}
+	// the stdin of a health check is replayed to each batch, as the one of
+	// the tmpl.
+	healthStdins := make(map[*Command][]byte)
+	for _, check := range append([]*Command{r.HealthCheck}, r.BatchHealthChecks...) {
+		if _, ok := healthStdins[check]; ok || check == nil || check.Stdin == nil {
+			continue
+		}
+		if healthStdins[check], err = io.ReadAll(check.Stdin); err != nil {
+			logger.Warn("reject rollout: failed to read health check stdin", "err", err)
+			return nil, fmt.Errorf("%w: failed to read health check stdin: %w", ErrInvalidCommand, err)
+		}
+	}
+
+	results = make([]HostResult, 0, len(r.Targets))
+	progress := RolloutEvent{Batches: len(sizes), Total: len(r.Targets)}
+	emit := func(event RolloutEvent) {
+		if r.OnEvent != nil {
+			r.OnEvent(event)
+		}
+	}
+	defer func() {
+		event := progress
+		event.Kind, event.Err = RolloutFinished, err
+		emit(event)
+	}()
+
+	logger.Info("rollout started", "batches", sizes)
+
+	next := 0
+	for b, size := range sizes {
+		batch := r.Targets[next : next+size]
+		next += size
+
+		progress.Batch = b + 1
+		progress.Targets = make([]string, len(batch))
+		for i, target := range batch {
+			progress.Targets[i] = target.Name
+		}
+		event := progress
+		event.Kind = RolloutBatchStarted
+		emit(event)
+
+		batchLogger := logger.With("batch", b+1, "size", size)
+		batchLogger.Debug("rollout batch started")
+
+		// run the command on the batch
+		cmd := tmpl.Clone()
+		cmd.Stdin = bytes.NewReader(stdin)
+		batchResults, _ := r.fanOut(batch, batchLogger, func(result HostResult) {
+			progress.Done++
+			if !result.OK() {
+				progress.Failed++
+			}
+			event := progress
+			event.Kind, event.Result = RolloutHostFinished, &result
+			emit(event)
+		}).Run(ctx, cmd)
+		results = append(results, batchResults...)
+
+		// gate the next batch
+		stop := r.checkFailures(progress)
+		if stop == nil && ctx.Err() != nil {
+			stop = context.Cause(ctx)
+		}
+		if check := r.healthCheck(b); stop == nil && check != nil {
+			stop = r.checkHealth(ctx, batch, check, healthStdins[check], batchLogger, func(result HostResult) {
+				event := progress
+				event.Kind, event.Result = RolloutHealthChecked, &result
+				emit(event)
+			})
+		}
+
+		event = progress
+		event.Kind, event.Err = RolloutBatchFinished, stop
+		emit(event)
+
+		if stop == nil && next < len(r.Targets) && r.Pause > 0 {
+			event = progress
+			event.Kind = RolloutPaused
+			emit(event)
+
+			batchLogger.Debug("rollout paused", "pause", r.Pause)
+			select {
+			case <-time.After(r.Pause):
+			case <-ctx.Done():
+				stop = context.Cause(ctx)
+			}
+		}
+
+		if stop != nil && next == len(r.Targets) && errors.Is(stop, ErrHealthCheckFailed) {
+			// nothing to abort: all the targets have run.
+			batchLogger.Warn("rollout finished with a failed health check", "done", progress.Done, "failed", progress.Failed, "err", stop)
+			return results, fmt.Errorf("after batch %d of %d: %w", b+1, len(sizes), stop)
+		}
+		if stop != nil {
+			batchLogger.Warn("rollout aborted", "done", progress.Done, "failed", progress.Failed, "err", stop)
+			for _, target := range r.Targets[next:] {
+				result := HostResult{Name: target.Name, Status: -1}
+				if target.Executor != nil {
+					result.Target = TargetOf(target.Executor)
+				}
+				result.Err = fmt.Errorf("%w: %w", ErrFanOutSkipped, stop)
+				results = append(results, result)
+			}
+			return results, fmt.Errorf("%w: after batch %d of %d: %w", ErrRolloutAborted, b+1, len(sizes), stop)
+		}
+	}
+
+	if progress.Failed > 0 {
+		logger.Warn("rollout finished with failures", "failed", progress.Failed)
+		return results, fmt.Errorf("%w: %d of %d targets", ErrFanOutFailed, progress.Failed, progress.Total)
+	}
+
+	logger.Info("rollout succeeded")
+	return results, nil
+}
+
+// fanOut returns the FanOut to run a batch.
+func (r *Rollout) fanOut(batch []FanOutTarget, logger *slog.Logger, onResult func(HostResult)) *FanOut {
+	concurrency := len(batch)
+	if r.Concurrency > 0 && r.Concurrency < concurrency {
+		concurrency = r.Concurrency
+	}
+	return &FanOut{
+		Targets:     batch,
+		Concurrency: concurrency,
+		Template:    r.Template,
+		OnResult:    onResult,
+		Logger:      logger,
+	}
+}
+
+// checkFailures returns an error wrapping ErrFailureBudget if the failures
+// in the progress exceed the budget, or nil.
+func (r *Rollout) checkFailures(progress RolloutEvent) error {
+	if r.MaxFailures >= 0 && progress.Failed > r.MaxFailures {
+		return fmt.Errorf("%w: %d targets failed, max %d", ErrFailureBudget, progress.Failed, r.MaxFailures)
+	}
+	if r.MaxFailureRate > 0 && progress.Done > 0 {
+		if rate := float64(progress.Failed) / float64(progress.Done); rate > r.MaxFailureRate {
+			return fmt.Errorf("%w: failure rate %.2f, max %.2f", ErrFailureBudget, rate, r.MaxFailureRate)
+		}
+	}
+	return nil
+}
+
+// healthCheck returns the health check of the batch b (from 0), or nil.
+func (r *Rollout) healthCheck(b int) *Command {
+	if b < len(r.BatchHealthChecks) && r.BatchHealthChecks[b] != nil {
+		return r.BatchHealthChecks[b]
+	}
+	return r.HealthCheck
+}
+
+// checkHealth runs the health check on the batch, with the stdin, and
+// returns an error wrapping ErrHealthCheckFailed if it fails on any target,
+// or nil.
+func (r *Rollout) checkHealth(ctx context.Context, batch []FanOutTarget, check *Command, stdin []byte, logger *slog.Logger, onResult func(HostResult)) error {
+	check = check.Clone()
+	check.Stdin = bytes.NewReader(stdin)
+	results, err := r.fanOut(batch, logger, onResult).Run(ctx, check)
+	if err == nil {
+		return nil
+	}
+	for _, result := range results {
+		if !result.OK() {
+			return fmt.Errorf("%w: on target %s: status %d: %w", ErrHealthCheckFailed, result.Name, result.Status, result.Err)
+		}
+	}
+	return fmt.Errorf("%w: %w", ErrHealthCheckFailed, err)
+}
+
+// plan returns the sizes of the batches to run all the targets.
+func (r *Rollout) plan() ([]int, error) {
+	total := len(r.Targets)
+	specs := r.Batches
+	if len(specs) == 0 {
+		specs = []string{"100%"}
+	}
+
+	var sizes []int
+	for i, remaining := 0, total; remaining > 0; i++ {
+		spec := specs[min(i, len(specs)-1)]
+		size, err := parseBatchSize(spec, total)
+		if err != nil {
+			return nil, err
+		}
+		size = min(size, remaining)
+		sizes = append(sizes, size)
+		remaining -= size
+	}
+	return sizes, nil
+}
+
+// parseBatchSize parses a batch size spec ("5" or "10%") of total targets.
+// A percentage is rounded up, so the size is at least 1.
+func parseBatchSize(spec string, total int) (int, error) {
+	s := strings.TrimSpace(spec)
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		percent, err := strconv.ParseFloat(p, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, fmt.Errorf("%w: %q: want a percentage in (0%%, 100%%]", ErrInvalidBatchSize, spec)
+		}
+		return max(1, int(math.Ceil(float64(total)*percent/100))), nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%w: %q: want a positive number or a percentage", ErrInvalidBatchSize, spec)
+	}
+	return n, nil
+}
+
+// Rollout errors
+var (
+	ErrRolloutAborted    = errors.New("rollout aborted")
+	ErrFailureBudget     = errors.New("failure budget exceeded")
+	ErrHealthCheckFailed = errors.New("health check failed")
+	ErrInvalidBatchSize  = errors.New("invalid batch size")
+)
@@ -0,0 +1,294 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBatchSize(t *testing.T) {
+	tests := []struct {
+		spec    string
+		total   int
+		want    int
+		wantErr bool
+	}{
+		{"1", 10, 1, false},
+		{" 5 ", 10, 5, false},
+		{"10%", 10, 1, false},
+		{"10%", 25, 3, false},
+		{"1%", 3, 1, false},
+		{"100%", 7, 7, false},
+		{"0", 10, 0, true},
+		{"-1", 10, 0, true},
+		{"0%", 10, 0, true},
+		{"150%", 10, 0, true},
+		{"abc", 10, 0, true},
+		{"", 10, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBatchSize(tt.spec, tt.total)
+		switch {
+		case (err != nil) != tt.wantErr:
+			t.Errorf("❌ parseBatchSize(%q, %d) error = %v, wantErr %v", tt.spec, tt.total, err, tt.wantErr)
+		case err != nil && !errors.Is(err, ErrInvalidBatchSize):
+			t.Errorf("❌ parseBatchSize(%q, %d) error = %v, want ErrInvalidBatchSize", tt.spec, tt.total, err)
+		case got != tt.want:
+			t.Errorf("❌ parseBatchSize(%q, %d) = %d, want %d", tt.spec, tt.total, got, tt.want)
+		default:
+			t.Logf("✅ parseBatchSize(%q, %d) = %d, %v", tt.spec, tt.total, got, err)
+		}
+	}
+}
+
+func TestRollout_plan(t *testing.T) {
+	tests := []struct {
+		batches []string
+		total   int
+		want    []int
+	}{
+		{nil, 5, []int{5}},
+		{[]string{"1", "10%", "100%"}, 20, []int{1, 2, 17}},
+		{[]string{"1", "2"}, 7, []int{1, 2, 2, 2}},
+		{[]string{"50%"}, 5, []int{3, 2}},
+		{[]string{"10"}, 3, []int{3}},
+		{[]string{"1"}, 0, nil},
+	}
+	for _, tt := range tests {
+		r := &Rollout{Targets: make([]FanOutTarget, tt.total), Batches: tt.batches}
+		got, err := r.plan()
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("❌ plan(%v, %d) = %v, %v, want %v", tt.batches, tt.total, got, err, tt.want)
+		} else {
+			t.Logf("✅ plan(%v, %d) = %v", tt.batches, tt.total, got)
+		}
+	}
+}
+
+// rolloutTargets returns n local targets named host0, host1, ... .
+// The command fails on the hosts in fail.
+func rolloutTargets(n int, fail ...string) []FanOutTarget {
+	targets := make([]FanOutTarget, n)
+	for i := range targets {
+		name := fmt.Sprintf("host%d", i)
+		shell := &ShellExecutor{ShellPath: "sh", ShellArgs: []string{"-c"}}
+		executor := Executor(shell)
+		for _, f := range fail {
+			if f == name {
+				executor = ExecutorFunc(func(ctx context.Context, cmd *Command) error {
+					cmd.Command = "exit 1"
+					return shell.Execute(ctx, cmd)
+				})
+			}
+		}
+		targets[i] = FanOutTarget{Name: name, Executor: executor}
+	}
+	return targets
+}
+
+func TestRollout_Run(t *testing.T) {
+	tests := []struct {
+		name        string
+		rollout     Rollout
+		wantErr     error
+		wantRun     int // targets run (not skipped)
+		wantBatches int // batches started
+	}{
+		{
+			name:        "allOK",
+			rollout:     Rollout{Targets: rolloutTargets(10), Batches: []string{"1", "30%"}},
+			wantRun:     10,
+			wantBatches: 4,
+		},
+		{
+			name:        "failuresWithinBudget",
+			rollout:     Rollout{Targets: rolloutTargets(5, "host2"), Batches: []string{"1", "2"}, MaxFailures: 1},
+			wantErr:     ErrFanOutFailed,
+			wantRun:     5,
+			wantBatches: 3,
+		},
+		{
+			name:        "canaryFailed",
+			rollout:     Rollout{Targets: rolloutTargets(5, "host0"), Batches: []string{"1", "100%"}},
+			wantErr:     ErrFailureBudget,
+			wantRun:     1,
+			wantBatches: 1,
+		},
+		{
+			name:        "unlimitedFailures",
+			rollout:     Rollout{Targets: rolloutTargets(4, "host0", "host1"), Batches: []string{"1"}, MaxFailures: -1},
+			wantErr:     ErrFanOutFailed,
+			wantRun:     4,
+			wantBatches: 4,
+		},
+		{
+			name:        "failureRate",
+			rollout:     Rollout{Targets: rolloutTargets(10, "host3"), Batches: []string{"4"}, MaxFailures: -1, MaxFailureRate: 0.2},
+			wantErr:     ErrFailureBudget,
+			wantRun:     4,
+			wantBatches: 1,
+		},
+		{
+			name: "healthCheckFailed",
+			rollout: Rollout{
+				Targets:     rolloutTargets(6),
+				Batches:     []string{"2"},
+				HealthCheck: &Command{Command: "test -e /not/found/file"},
+			},
+			wantErr:     ErrHealthCheckFailed,
+			wantRun:     2,
+			wantBatches: 1,
+		},
+		{
+			name: "healthCheckFailedLastBatch",
+			rollout: Rollout{
+				Targets:     rolloutTargets(2),
+				Batches:     []string{"2"},
+				HealthCheck: &Command{Command: "test -e /not/found/file"},
+			},
+			wantErr:     ErrHealthCheckFailed,
+			wantRun:     2,
+			wantBatches: 1,
+		},
+		{
+			name: "healthCheckStdin",
+			rollout: Rollout{
+				Targets:     rolloutTargets(3),
+				Batches:     []string{"1"},
+				HealthCheck: &Command{Command: "grep -q healthy", Stdin: strings.NewReader("healthy")},
+			},
+			wantRun:     3,
+			wantBatches: 3,
+		},
+		{
+			name: "batchHealthCheckFailed",
+			rollout: Rollout{
+				Targets:           rolloutTargets(6),
+				Batches:           []string{"1", "2"},
+				HealthCheck:       &Command{Command: "true"},
+				BatchHealthChecks: []*Command{nil, {Command: "test -e /not/found/file"}},
+			},
+			wantErr:     ErrHealthCheckFailed,
+			wantRun:     3,
+			wantBatches: 2,
+		},
+		{
+			name: "batchHealthCheckOverride",
+			rollout: Rollout{
+				Targets:           rolloutTargets(3),
+				Batches:           []string{"1"},
+				HealthCheck:       &Command{Command: "test -e /not/found/file"},
+				BatchHealthChecks: []*Command{{Command: "true"}, {Command: "grep -q ok", Stdin: strings.NewReader("ok")}, {Command: "true"}},
+			},
+			wantRun:     3,
+			wantBatches: 3,
+		},
+		{
+			name: "healthCheckOK",
+			rollout: Rollout{
+				Targets:     rolloutTargets(3),
+				Batches:     []string{"2"},
+				HealthCheck: &Command{Command: "true"},
+			},
+			wantRun:     3,
+			wantBatches: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []RolloutEvent
+			tt.rollout.OnEvent = func(e RolloutEvent) { events = append(events, e) }
+
+			results, err := tt.rollout.Run(context.Background(), &Command{Command: "true"})
+
+			run, batches := 0, 0
+			for _, r := range results {
+				if !errors.Is(r.Err, ErrFanOutSkipped) {
+					run++
+				}
+			}
+			for _, e := range events {
+				if e.Kind == RolloutBatchStarted {
+					batches++
+				}
+			}
+			last := events[len(events)-1]
+
+			switch {
+			case tt.wantErr == nil && err != nil, tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("❌ Run() error = %v, want %v", err, tt.wantErr)
+			case tt.wantErr != nil && errors.Is(err, ErrRolloutAborted) != (tt.wantRun < len(tt.rollout.Targets)):
+				t.Errorf("❌ Run() error = %v, want ErrRolloutAborted only if some targets are skipped", err)
+			case len(results) != len(tt.rollout.Targets):
+				t.Errorf("❌ got %d results, want %d", len(results), len(tt.rollout.Targets))
+			case run != tt.wantRun:
+				t.Errorf("❌ %d targets run, want %d", run, tt.wantRun)
+			case batches != tt.wantBatches:
+				t.Errorf("❌ %d batches started, want %d", batches, tt.wantBatches)
+			case last.Kind != RolloutFinished || last.Err != err:
+				t.Errorf("❌ last event = %+v, want finished with %v", last, err)
+			default:
+				t.Logf("✅ run %d targets in %d batches: %v", run, batches, err)
+			}
+			for i, r := range results {
+				if r.Name != tt.rollout.Targets[i].Name {
+					t.Errorf("❌ results[%d].Name = %q, want %q", i, r.Name, tt.rollout.Targets[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestRollout_Run_events(t *testing.T) {
+	var kinds []string
+	rollout := &Rollout{
+		Targets:     rolloutTargets(3),
+		Batches:     []string{"1", "2"},
+		Pause:       time.Millisecond,
+		HealthCheck: &Command{Command: "true"},
+		OnEvent: func(e RolloutEvent) {
+			kinds = append(kinds, fmt.Sprintf("%s:%d/%d:%d", e.Kind, e.Batch, e.Batches, e.Done))
+		},
+	}
+	if _, err := rollout.Run(context.Background(), &Command{Command: "true"}); err != nil {
+		t.Fatalf("❌ Run() error = %v", err)
+	}
+
+	want := strings.Join([]string{
+		"batch_started:1/2:0", "host_finished:1/2:1", "health_checked:1/2:1", "batch_finished:1/2:1", "paused:1/2:1",
+		"batch_started:2/2:1", "host_finished:2/2:2", "host_finished:2/2:3", "health_checked:2/2:3", "health_checked:2/2:3", "batch_finished:2/2:3",
+		"finished:2/2:3",
+	}, " ")
+	if got := strings.Join(kinds, " "); got != want {
+		t.Errorf("❌ events = %s\nwant %s", got, want)
+	} else {
+		t.Logf("✅ events: %s", got)
+	}
+}
+
+func TestRollout_Run_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rollout := &Rollout{
+		Targets: rolloutTargets(3),
+		Batches: []string{"1"},
+		Pause:   time.Minute,
+		OnEvent: func(e RolloutEvent) {
+			if e.Kind == RolloutPaused {
+				cancel()
+			}
+		},
+	}
+	results, err := rollout.Run(ctx, &Command{Command: "true"})
+	switch {
+	case !errors.Is(err, ErrRolloutAborted) || !errors.Is(err, context.Canceled):
+		t.Errorf("❌ Run() error = %v, want ErrRolloutAborted and context.Canceled", err)
+	case !results[0].OK() || !errors.Is(results[1].Err, ErrFanOutSkipped):
+		t.Errorf("❌ results = %+v", results)
+	default:
+		t.Logf("✅ canceled during the pause: %v", err)
+	}
+}
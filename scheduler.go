@@ -0,0 +1,511 @@
+package rexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// This file provides Scheduler, to run commands periodically on cron
+// schedules:
+//
+//	scheduler := &rexec.Scheduler{OnResult: report}
+//	err := scheduler.Add(rexec.ScheduledCommand{
+//		Name:     "rotate-logs",
+//		Schedule: "CRON_TZ=Europe/Paris 0 30 3 * * *",
+//		Command:  &rexec.Command{Command: "logrotate /etc/logrotate.conf"},
+//		Executor: executor,
+//		Overlap:  rexec.OverlapSkip,
+//		Jitter:   5 * time.Minute,
+//	})
+//	go scheduler.Run(ctx)
+
+// DefaultScheduleMissedGrace is the MissedGrace of a ScheduledCommand that
+// does not set it.
+const DefaultScheduleMissedGrace = time.Minute
+
+// OverlapPolicy is what a Scheduler does when a command is due while its
+// previous run is still running.
+type OverlapPolicy string
+
+const (
+	OverlapSkip   OverlapPolicy = "skip"   // skip the new run (the default)
+	OverlapQueue  OverlapPolicy = "queue"  // run it after the previous one
+	OverlapCancel OverlapPolicy = "cancel" // cancel the previous run, then run it
+)
+
+// MissedPolicy is what a Scheduler does with the runs of a command it is
+// late for (see ScheduledCommand.MissedGrace), e.g. after the machine slept
+// or the clock jumped forward.
+type MissedPolicy string
+
+const (
+	MissedSkip    MissedPolicy = "skip"     // skip them (the default)
+	MissedRunOnce MissedPolicy = "run-once" // run once, now, for all of them
+)
+
+// ScheduledCommand is a command a Scheduler runs on a schedule.
+type ScheduledCommand struct {
+	// Name identifies the command in the Scheduler, and is the Name of its
+	// results.
+	Name string
+	// Schedule is the cron expression of the runs (see ParseCron).
+	Schedule string
+	// Command is the template of the runs: each run executes a clone of it
+	// (see Command.Clone), with its Stdin read once and replayed.
+	Command *Command
+	// Executor runs the command.
+	Executor Executor
+
+	// Overlap is the policy for the runs due while the previous one is still
+	// running. If empty, OverlapSkip is used.
+	Overlap OverlapPolicy
+	// MaxQueued is the number of runs queued by OverlapQueue: the runs due
+	// while the queue is full are skipped. If <= 0, 1 is used.
+	MaxQueued int
+
+	// Jitter delays each run by up to Jitter, to spread the load of
+	// commands on the same schedule. The delay is derived from the Name and
+	// the scheduled time, so that it is deterministic.
+	Jitter time.Duration
+
+	// Missed is the policy for the runs the Scheduler is late for by more
+	// than MissedGrace. If empty, MissedSkip is used.
+	//
+	// Either way, the Scheduler runs a command at most once when it is late
+	// for several of its runs: the ones between are dropped.
+	Missed MissedPolicy
+	// MissedGrace is how late a run may start. If <= 0,
+	// DefaultScheduleMissedGrace is used.
+	MissedGrace time.Duration
+}
+
+// Scheduler runs commands on cron schedules (see ScheduledCommand), until
+// the ctx of Run is done.
+//
+// The results of the runs (including the skipped ones) are given to
+// OnResult, as HostResults named by the ScheduledCommand. The runs of
+// different commands are concurrent.
+//
+// The zero value is ready to use, with the SystemClock.
+type Scheduler struct {
+	// Clock tells the time of the schedules.
+	// If nil, SystemClock is used. See FakeClock for the tests.
+	Clock Clock `json:"-"`
+
+	// Hooks, if not nil, are added to the ctx of the runs (see WithHooks).
+	Hooks *Hooks `json:"-"`
+
+	// OnResult, if not nil, is called with the result of each run when it
+	// finishes (or is skipped). The calls are serialized.
+	OnResult func(HostResult) `json:"-"`
+
+	// Logger, if not nil, overrides the global Logger for this scheduler.
+	// See Logger for how the logger of an execution is resolved.
+	Logger *slog.Logger `json:"-"`
+
+	initOnce sync.Once
+	wake     chan struct{} // wakes Run up when the commands change
+	resultMu sync.Mutex    // serializes OnResult
+	runs     sync.WaitGroup
+
+	mu      sync.Mutex
+	entries map[string]*scheduleEntry
+	running bool
+}
+
+// scheduleEntry is a ScheduledCommand added to a Scheduler.
+// Its fields are guarded by the lock of the Scheduler.
+type scheduleEntry struct {
+	cmd      ScheduledCommand
+	schedule *CronSchedule
+	stdin    []byte
+
+	next    time.Time // the next run, zero until Run computes it
+	active  *scheduledRun
+	queue   []time.Time // the scheduled times of the queued runs
+	removed bool
+}
+
+// scheduledRun is a run of a scheduleEntry.
+type scheduledRun struct {
+	scheduled time.Time
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+}
+
+func (s *Scheduler) init() {
+	s.wake = make(chan struct{}, 1)
+	s.entries = make(map[string]*scheduleEntry)
+}
+
+func (s *Scheduler) clock() Clock {
+	if s.Clock != nil {
+		return s.Clock
+	}
+	return SystemClock
+}
+
+// Add adds the command to the Scheduler. It can be called while the
+// Scheduler is running: the first run of the command is the first time of
+// its schedule after it is added (or after Run starts).
+//
+// It returns an error wrapping ErrBadSchedule if the command is invalid, or
+// if another command has the same Name.
+func (s *Scheduler) Add(sc ScheduledCommand) error {
+	s.initOnce.Do(s.init)
+	logger := orGlobalLogger(s.Logger).With("field", "rexec.Scheduler.Add", "name", sc.Name, "schedule", sc.Schedule)
+
+	entry, err := newScheduleEntry(sc, s.clock().Now())
+	if err != nil {
+		logger.Warn("reject scheduled command", "err", err)
+		return err
+	}
+
+	s.mu.Lock()
+	if _, ok := s.entries[sc.Name]; ok {
+		s.mu.Unlock()
+		logger.Warn("reject scheduled command: name already exists")
+		return fmt.Errorf("%w: %q already exists", ErrBadSchedule, sc.Name)
+	}
+	s.entries[sc.Name] = entry
+	s.mu.Unlock()
+
+	s.notify()
+	logger.Info("scheduled command added")
+	return nil
+}
+
+// newScheduleEntry checks the ScheduledCommand, and prepares its entry.
+func newScheduleEntry(sc ScheduledCommand, now time.Time) (*scheduleEntry, error) {
+	if sc.Name == "" {
+		return nil, fmt.Errorf("%w: empty name", ErrBadSchedule)
+	}
+	if sc.Executor == nil {
+		return nil, fmt.Errorf("%w: %q: nil executor", ErrBadSchedule, sc.Name)
+	}
+	switch sc.Overlap {
+	case "", OverlapSkip, OverlapQueue, OverlapCancel:
+	default:
+		return nil, fmt.Errorf("%w: %q: unknown overlap policy %q", ErrBadSchedule, sc.Name, sc.Overlap)
+	}
+	switch sc.Missed {
+	case "", MissedSkip, MissedRunOnce:
+	default:
+		return nil, fmt.Errorf("%w: %q: unknown missed policy %q", ErrBadSchedule, sc.Name, sc.Missed)
+	}
+
+	schedule, err := ParseCron(sc.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrBadSchedule, sc.Name, err)
+	}
+	if schedule.Next(now).IsZero() {
+		return nil, fmt.Errorf("%w: %q: %q never runs", ErrBadSchedule, sc.Name, sc.Schedule)
+	}
+
+	// validate a clone: the template is not run itself.
+	if err := sc.Command.Clone().Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrBadSchedule, sc.Name, err)
+	}
+	var stdin []byte
+	if sc.Command.Stdin != nil {
+		if stdin, err = io.ReadAll(sc.Command.Stdin); err != nil {
+			return nil, fmt.Errorf("%w: %q: failed to read stdin: %w", ErrBadSchedule, sc.Name, err)
+		}
+	}
+
+	return &scheduleEntry{cmd: sc, schedule: schedule, stdin: stdin}, nil
+}
+
+// Remove removes the command of the name from the Scheduler, and drops its
+// queued runs. Its running run (if any) is not cancelled.
+// It reports whether the command was found.
+func (s *Scheduler) Remove(name string) bool {
+	s.initOnce.Do(s.init)
+
+	s.mu.Lock()
+	entry, ok := s.entries[name]
+	if ok {
+		entry.removed = true
+		entry.queue = nil
+		delete(s.entries, name)
+	}
+	s.mu.Unlock()
+
+	if ok {
+		s.notify()
+	}
+	return ok
+}
+
+// Next returns the time of the next run of the command of the name, or
+// false if it is unknown or the Scheduler is not running yet.
+func (s *Scheduler) Next(name string) (time.Time, bool) {
+	s.initOnce.Do(s.init)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[name]
+	if !ok || entry.next.IsZero() {
+		return time.Time{}, false
+	}
+	return entry.next, true
+}
+
+// notify wakes Run up.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the commands on their schedules until the ctx is done. The ctx
+// of the runs is derived from it: they are cancelled then, and Run waits
+// for them before returning the cause of the ctx.
+//
+// It returns ErrSchedulerRunning if the Scheduler is already running.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.initOnce.Do(s.init)
+	logger := loggerFor(ctx, s.Logger).With("field", "rexec.Scheduler.Run")
+
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return ErrSchedulerRunning
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.runs.Wait()
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+	}()
+
+	logger.Info("scheduler started")
+	clock := s.clock()
+	for {
+		next := s.dispatch(ctx, clock.Now())
+
+		var timer Timer
+		var fired <-chan time.Time
+		if !next.IsZero() {
+			timer = clock.NewTimer(next.Sub(clock.Now()))
+			fired = timer.C()
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			logger.Info("scheduler stopped", "cause", context.Cause(ctx))
+			return context.Cause(ctx)
+		case <-fired:
+		case <-s.wake:
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// dispatch starts the runs due at now, and returns the time of the next
+// one (zero if there are no commands).
+func (s *Scheduler) dispatch(ctx context.Context, now time.Time) time.Time {
+	logger := loggerFor(ctx, s.Logger).With("field", "rexec.Scheduler.dispatch")
+
+	var (
+		next    time.Time
+		skipped []HostResult
+	)
+
+	s.mu.Lock()
+	for _, name := range sortedKeys(s.entries) {
+		entry := s.entries[name]
+		if entry.next.IsZero() {
+			entry.next = entry.schedule.Next(now)
+		}
+
+		if !entry.next.After(now) {
+			scheduled := entry.next
+			entry.next = entry.schedule.Next(now)
+
+			late := now.Sub(scheduled)
+			if late > entry.missedGrace() && entry.cmd.Missed != MissedRunOnce {
+				logger.Warn("scheduled run missed", "name", name, "scheduled", scheduled, "late", late)
+				skipped = append(skipped, entry.skipped(scheduled, fmt.Errorf("%w: late by %v", ErrScheduleMissed, late)))
+			} else if err := s.start(ctx, entry, scheduled); err != nil {
+				logger.Warn("scheduled run skipped", "name", name, "scheduled", scheduled, "err", err)
+				skipped = append(skipped, entry.skipped(scheduled, err))
+			}
+		}
+
+		if !entry.next.IsZero() && (next.IsZero() || entry.next.Before(next)) {
+			next = entry.next
+		}
+	}
+	s.mu.Unlock()
+
+	for _, result := range skipped {
+		s.report(result)
+	}
+	return next
+}
+
+func (e *scheduleEntry) missedGrace() time.Duration {
+	if e.cmd.MissedGrace > 0 {
+		return e.cmd.MissedGrace
+	}
+	return DefaultScheduleMissedGrace
+}
+
+// skipped returns the result of a run skipped because of the err.
+func (e *scheduleEntry) skipped(scheduled time.Time, err error) HostResult {
+	return HostResult{
+		Name:   e.cmd.Name,
+		Target: TargetOf(e.cmd.Executor),
+		Status: -1,
+		Err:    err,
+		Start:  scheduled,
+	}
+}
+
+// start starts a run of the entry scheduled at the time, or applies its
+// Overlap policy if it is running. It returns an error wrapping
+// ErrScheduleSkipped if the run is skipped.
+// It must be called with the lock held.
+func (s *Scheduler) start(ctx context.Context, entry *scheduleEntry, scheduled time.Time) error {
+	if entry.active != nil {
+		switch entry.cmd.Overlap {
+		case OverlapQueue:
+			if len(entry.queue) >= max(entry.cmd.MaxQueued, 1) {
+				return fmt.Errorf("%w: the previous run is still running, and the queue is full", ErrScheduleSkipped)
+			}
+			entry.queue = append(entry.queue, scheduled)
+		case OverlapCancel:
+			entry.active.cancel(ErrScheduleReplaced)
+			entry.queue = []time.Time{scheduled}
+		default:
+			return fmt.Errorf("%w: the previous run is still running", ErrScheduleSkipped)
+		}
+		return nil
+	}
+
+	run := newScheduledRun(ctx, scheduled)
+	entry.active = run
+
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+		for {
+			s.report(s.execute(entry, run))
+
+			s.mu.Lock()
+			if len(entry.queue) == 0 || entry.removed {
+				entry.active = nil
+				s.mu.Unlock()
+				return
+			}
+			run = newScheduledRun(ctx, entry.queue[0])
+			entry.queue = entry.queue[1:]
+			entry.active = run
+			s.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func newScheduledRun(ctx context.Context, scheduled time.Time) *scheduledRun {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &scheduledRun{scheduled: scheduled, ctx: ctx, cancel: cancel}
+}
+
+// execute runs a clone of the command of the entry, after its jitter.
+func (s *Scheduler) execute(entry *scheduleEntry, run *scheduledRun) HostResult {
+	defer run.cancel(nil)
+	logger := loggerFor(run.ctx, s.Logger).With("field", "rexec.Scheduler.execute", "name", entry.cmd.Name, "scheduled", run.scheduled)
+	clock := s.clock()
+
+	result := HostResult{Name: entry.cmd.Name, Target: TargetOf(entry.cmd.Executor), Status: -1}
+
+	if delay := entry.jitter(run.scheduled); delay > 0 {
+		logger.Debug("delay scheduled run", "jitter", delay)
+		timer := clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-run.ctx.Done():
+			timer.Stop()
+			result.Start = clock.Now()
+			result.Err = fmt.Errorf("%w: %w", ErrScheduleSkipped, context.Cause(run.ctx))
+			return result
+		}
+	}
+
+	ctx := run.ctx
+	if s.Hooks != nil {
+		ctx = WithHooks(ctx, s.Hooks)
+	}
+
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	cmd := entry.cmd.Command.Clone()
+	cmd.Stdin = bytes.NewReader(entry.stdin)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	result.Start = clock.Now()
+	err := entry.cmd.Executor.Execute(ctx, cmd)
+	result.Duration = clock.Now().Sub(result.Start)
+
+	if err != nil && errors.Is(context.Cause(run.ctx), ErrScheduleReplaced) {
+		err = fmt.Errorf("%w: %w", ErrScheduleReplaced, err)
+	}
+	result.Err = err
+	result.Status = cmd.Status
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+
+	if result.OK() {
+		logger.Info("scheduled run succeeded", "duration", result.Duration)
+	} else {
+		logger.Warn("scheduled run failed", "status", result.Status, "err", result.Err)
+	}
+	return result
+}
+
+// jitter returns the delay of the run of the entry scheduled at the time:
+// a hash of the name and the time, in [0, Jitter).
+func (e *scheduleEntry) jitter(scheduled time.Time) time.Duration {
+	if e.cmd.Jitter <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s@%d", e.cmd.Name, scheduled.Unix())
+	return time.Duration(h.Sum64() % uint64(e.cmd.Jitter))
+}
+
+// report gives the result to OnResult.
+func (s *Scheduler) report(result HostResult) {
+	if s.OnResult == nil {
+		return
+	}
+	s.resultMu.Lock()
+	defer s.resultMu.Unlock()
+	s.OnResult(result)
+}
+
+// Scheduler errors
+var (
+	ErrBadSchedule      = errors.New("bad scheduled command")
+	ErrSchedulerRunning = errors.New("scheduler is already running")
+	ErrScheduleSkipped  = errors.New("scheduled run skipped")
+	ErrScheduleMissed   = errors.New("scheduled run missed")
+	ErrScheduleReplaced = errors.New("scheduled run replaced by the next one")
+)
@@ -0,0 +1,260 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestScheduler runs a Scheduler on a FakeClock at 2024-01-01 00:00:00
+// UTC, with the results sent to the returned channel. The scheduler is
+// stopped at the end of the test.
+func startTestScheduler(t *testing.T, commands ...ScheduledCommand) (*Scheduler, *FakeClock, chan HostResult) {
+	t.Helper()
+
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	results := make(chan HostResult, 16)
+	s := &Scheduler{Clock: clock, OnResult: func(r HostResult) { results <- r }}
+	for _, sc := range commands {
+		if err := s.Add(sc); err != nil {
+			t.Fatalf("❌ Add(%s) error = %v", sc.Name, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- s.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-stopped; !errors.Is(err, context.Canceled) {
+			t.Errorf("❌ Run() error = %v, want %v", err, context.Canceled)
+		}
+	})
+
+	clock.WaitTimers(1)
+	return s, clock, results
+}
+
+// receive returns the next result, or fails the test after a while.
+func receive(t *testing.T, results chan HostResult) HostResult {
+	t.Helper()
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatalf("❌ no result")
+		return HostResult{}
+	}
+}
+
+func TestScheduler_Run(t *testing.T) {
+	var exits atomic.Int32
+	sh := &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}}
+	s, clock, results := startTestScheduler(t, ScheduledCommand{
+		Name:     "hello",
+		Schedule: "*/10 * * * * *",
+		Command:  &Command{Command: "cat", Stdin: strings.NewReader("hello")},
+		Executor: sh,
+	})
+	s.Hooks = &Hooks{OnExit: func(context.Context, *Command, TargetInfo, error) { exits.Add(1) }}
+
+	if next, ok := s.Next("hello"); !ok || !next.Equal(clock.Now().Add(10*time.Second)) {
+		t.Errorf("❌ Next() = %v, %v, want %v", next, ok, clock.Now().Add(10*time.Second))
+	}
+
+	for i := range 2 {
+		clock.Advance(10 * time.Second)
+		r := receive(t, results)
+		if !r.OK() || r.Name != "hello" || string(r.Stdout) != "hello" {
+			t.Errorf("❌ result %d = %+v, want hello", i, r)
+		}
+		if !r.Start.Equal(clock.Now()) {
+			t.Errorf("❌ result %d start = %v, want %v", i, r.Start, clock.Now())
+		}
+		clock.WaitTimers(1)
+	}
+	if got := exits.Load(); got != 2 {
+		t.Errorf("❌ OnExit called %d times, want 2", got)
+	}
+
+	if !s.Remove("hello") || s.Remove("hello") {
+		t.Errorf("❌ Remove() does not report the command")
+	}
+	if err := s.Run(context.Background()); !errors.Is(err, ErrSchedulerRunning) {
+		t.Errorf("❌ Run() again error = %v, want %v", err, ErrSchedulerRunning)
+	}
+	t.Logf("✅ the command runs on its schedule")
+}
+
+func TestScheduler_Add_bad(t *testing.T) {
+	sh := &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}}
+	good := ScheduledCommand{Name: "good", Schedule: "@hourly", Command: &Command{Command: "true"}, Executor: sh}
+
+	tests := []struct {
+		name   string
+		modify func(sc *ScheduledCommand)
+	}{
+		{name: "noName", modify: func(sc *ScheduledCommand) { sc.Name = "" }},
+		{name: "duplicate", modify: func(sc *ScheduledCommand) {}},
+		{name: "badCron", modify: func(sc *ScheduledCommand) { sc.Schedule = "* * *" }},
+		{name: "never", modify: func(sc *ScheduledCommand) { sc.Schedule = "0 0 31 4 *" }},
+		{name: "nilExecutor", modify: func(sc *ScheduledCommand) { sc.Executor = nil }},
+		{name: "nilCommand", modify: func(sc *ScheduledCommand) { sc.Command = nil }},
+		{name: "emptyCommand", modify: func(sc *ScheduledCommand) { sc.Command = &Command{} }},
+		{name: "badOverlap", modify: func(sc *ScheduledCommand) { sc.Overlap = "replace" }},
+		{name: "badMissed", modify: func(sc *ScheduledCommand) { sc.Missed = "run-all" }},
+	}
+
+	s := &Scheduler{}
+	if err := s.Add(good); err != nil {
+		t.Fatalf("❌ Add(good) error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := good
+			tt.modify(&sc)
+			if err := s.Add(sc); !errors.Is(err, ErrBadSchedule) {
+				t.Errorf("❌ Add() error = %v, want %v", err, ErrBadSchedule)
+				return
+			}
+			t.Logf("✅ Add() rejected")
+		})
+	}
+}
+
+func TestScheduler_overlap(t *testing.T) {
+	tests := []struct {
+		overlap OverlapPolicy
+		// check the results after the second run was due, and the first one
+		// was released.
+		check func(t *testing.T, e *blockingExecutor, results chan HostResult)
+	}{
+		{overlap: OverlapSkip, check: func(t *testing.T, e *blockingExecutor, results chan HostResult) {
+			if r := receive(t, results); !errors.Is(r.Err, ErrScheduleSkipped) {
+				t.Errorf("❌ second result error = %v, want %v", r.Err, ErrScheduleSkipped)
+			}
+			close(e.release)
+			if r := receive(t, results); !r.OK() {
+				t.Errorf("❌ first result = %+v, want OK", r)
+			}
+		}},
+		{overlap: OverlapQueue, check: func(t *testing.T, e *blockingExecutor, results chan HostResult) {
+			close(e.release)
+			for i := range 2 {
+				if r := receive(t, results); !r.OK() {
+					t.Errorf("❌ result %d = %+v, want OK", i, r)
+				}
+			}
+		}},
+		{overlap: OverlapCancel, check: func(t *testing.T, e *blockingExecutor, results chan HostResult) {
+			if r := receive(t, results); !errors.Is(r.Err, ErrScheduleReplaced) {
+				t.Errorf("❌ first result error = %v, want %v", r.Err, ErrScheduleReplaced)
+			}
+			<-e.started
+			close(e.release)
+			if r := receive(t, results); !r.OK() {
+				t.Errorf("❌ second result = %+v, want OK", r)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.overlap), func(t *testing.T) {
+			e := newBlockingExecutor()
+			_, clock, results := startTestScheduler(t, ScheduledCommand{
+				Name:     "slow",
+				Schedule: "* * * * *",
+				Command:  &Command{Command: "sleep"},
+				Executor: e,
+				Overlap:  tt.overlap,
+			})
+
+			clock.Advance(time.Minute)
+			<-e.started
+			clock.WaitTimers(1)
+			clock.Advance(time.Minute)
+
+			tt.check(t, e, results)
+			t.Logf("✅ overlap %s", tt.overlap)
+		})
+	}
+}
+
+func TestScheduler_missed(t *testing.T) {
+	tests := []struct {
+		missed  MissedPolicy
+		wantRun bool
+	}{
+		{missed: MissedSkip, wantRun: false},
+		{missed: MissedRunOnce, wantRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.missed), func(t *testing.T) {
+			_, clock, results := startTestScheduler(t, ScheduledCommand{
+				Name:        "every-minute",
+				Schedule:    "* * * * *",
+				Command:     &Command{Command: "true"},
+				Executor:    &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}},
+				Missed:      tt.missed,
+				MissedGrace: 10 * time.Second,
+			})
+
+			// e.g. the machine slept for an hour: the timer fires late
+			clock.Set(clock.Now().Add(time.Hour))
+
+			r := receive(t, results)
+			if got := r.OK(); got != tt.wantRun {
+				t.Errorf("❌ result = %+v, want run = %v", r, tt.wantRun)
+			}
+			if !tt.wantRun && !errors.Is(r.Err, ErrScheduleMissed) {
+				t.Errorf("❌ result error = %v, want %v", r.Err, ErrScheduleMissed)
+			}
+
+			// the runs between are dropped
+			select {
+			case r := <-results:
+				t.Errorf("❌ unexpected result %+v", r)
+			case <-time.After(50 * time.Millisecond):
+			}
+			t.Logf("✅ missed %s: %v", tt.missed, r.Err)
+		})
+	}
+}
+
+func TestScheduler_jitter(t *testing.T) {
+	const jitter = 30 * time.Second
+	sc := ScheduledCommand{
+		Name:     "jittered",
+		Schedule: "* * * * *",
+		Command:  &Command{Command: "true"},
+		Executor: &ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}},
+		Jitter:   jitter,
+	}
+	_, clock, results := startTestScheduler(t, sc)
+
+	scheduled := clock.Now().Add(time.Minute)
+	delay := (&scheduleEntry{cmd: sc}).jitter(scheduled)
+	if delay < 0 || delay >= jitter {
+		t.Fatalf("❌ jitter = %v, want in [0, %v)", delay, jitter)
+	}
+	if again := (&scheduleEntry{cmd: sc}).jitter(scheduled); again != delay {
+		t.Errorf("❌ jitter = %v then %v, want deterministic", delay, again)
+	}
+
+	clock.Advance(time.Minute)
+	clock.WaitTimers(2) // the next schedule, and the jitter
+	select {
+	case r := <-results:
+		t.Fatalf("❌ run before the jitter: %+v", r)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(delay)
+	r := receive(t, results)
+	if !r.OK() || !r.Start.Equal(scheduled.Add(delay)) {
+		t.Errorf("❌ result = %+v, want started at %v", r, scheduled.Add(delay))
+	}
+	t.Logf("✅ run delayed by %v", delay)
+}
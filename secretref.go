@@ -0,0 +1,187 @@
+package rexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"strings"
+	"sync"
+)
+
+// This file provides secret references, so that configuration files refer to
+// the secrets (SSH passwords, private keys, ...) instead of containing them:
+//
+//	{"Password": "env:SSH_PASS"}
+//	{"PrivateKey": "file:/run/secrets/ssh_key", "Passphrase": "env:SSH_KEY_PASSPHRASE"}
+//	{"FixedHostKey": "exec:vault kv get -field=host_key secret/ssh/web1"}
+//
+// The references are resolved when the secrets are used (e.g. by
+// SshAuth.PrepareContext when dialing), so the resolved values are never
+// stored in the configs. The "exec:" ones are opt-in (see SecretExec).
+//
+// Notice that a literal secret starting with "env:" or "file:" is taken as a
+// reference: prefix it with "literal:".
+
+// SecretResolver resolves the secret references of a scheme.
+type SecretResolver interface {
+	// ResolveSecret returns the secret referred to by ref, which is the
+	// reference without the "scheme:" prefix.
+	// The errors must not contain the secret.
+	ResolveSecret(ctx context.Context, ref string) (string, error)
+}
+
+// SecretResolverFunc is a function implementing SecretResolver.
+type SecretResolverFunc func(ctx context.Context, ref string) (string, error)
+
+func (f SecretResolverFunc) ResolveSecret(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// The built-in secret reference schemes.
+const (
+	// SecretEnv refers to an environment variable: "env:SSH_PASS".
+	SecretEnv = "env"
+	// SecretFile refers to the content of a file: "file:/run/secrets/key".
+	// A trailing newline is removed.
+	SecretFile = "file"
+	// SecretExec refers to the stdout of a program: "exec:pass show ssh/web".
+	// The command line is split like Command.Command, and run without a
+	// shell. A trailing newline is removed.
+	//
+	// It is not registered by default, since any secret (e.g. from a config
+	// file or an executor URL) could run a program then. Opt in with:
+	//
+	//	rexec.RegisterSecretResolver(rexec.SecretExec, rexec.ExecSecretResolver)
+	SecretExec = "exec"
+	// SecretLiteral is the secret itself: "literal:env:not-a-reference".
+	// It is an escape for literal secrets that look like references.
+	SecretLiteral = "literal"
+)
+
+var secretResolvers = struct {
+	sync.RWMutex
+	m map[string]SecretResolver
+}{m: map[string]SecretResolver{
+	SecretEnv:     SecretResolverFunc(resolveEnvSecret),
+	SecretFile:    SecretResolverFunc(resolveFileSecret),
+	SecretLiteral: SecretResolverFunc(func(_ context.Context, ref string) (string, error) { return ref, nil }),
+}}
+
+// ExecSecretResolver resolves the "exec:" references (see SecretExec). It is
+// not registered by default.
+var ExecSecretResolver SecretResolver = SecretResolverFunc(resolveExecSecret)
+
+// RegisterSecretResolver registers the resolver of the secret references
+// "scheme:...", e.g. for a vault. It replaces the resolver already
+// registered for the scheme, if any, including the built-in ones, which is
+// handy to stub them in tests. A nil resolver unregisters the scheme.
+//
+// It panics if the scheme is empty or contains a ":".
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	if scheme == "" || strings.Contains(scheme, ":") {
+		panic("rexec: RegisterSecretResolver: bad scheme " + scheme)
+	}
+
+	secretResolvers.Lock()
+	defer secretResolvers.Unlock()
+	if resolver == nil {
+		delete(secretResolvers.m, scheme)
+		return
+	}
+	secretResolvers.m[scheme] = resolver
+}
+
+// secretResolverOf returns the scheme, the ref and the resolver of the
+// value, if it is a reference with a registered scheme.
+func secretResolverOf(value string) (scheme, ref string, resolver SecretResolver, ok bool) {
+	scheme, ref, found := strings.Cut(value, ":")
+	if !found {
+		return "", "", nil, false
+	}
+	secretResolvers.RLock()
+	defer secretResolvers.RUnlock()
+	resolver, ok = secretResolvers.m[scheme]
+	return scheme, ref, resolver, ok
+}
+
+// IsSecretRef reports whether the value refers to a secret: it starts with
+// the "scheme:" of a registered SecretResolver, other than "literal:".
+func IsSecretRef(value string) bool {
+	scheme, _, _, ok := secretResolverOf(value)
+	return ok && scheme != SecretLiteral
+}
+
+// ResolveSecret returns the secret referred to by the value if it is a
+// reference (see IsSecretRef), the value without the "literal:" prefix,
+// or the value itself otherwise.
+//
+// The errors wrap ErrSecretRef, and name the reference but not the secret.
+func ResolveSecret(ctx context.Context, value string) (string, error) {
+	scheme, ref, resolver, ok := secretResolverOf(value)
+	if !ok {
+		return value, nil
+	}
+	secret, err := resolver.ResolveSecret(ctx, ref)
+	if err != nil {
+		if scheme == SecretExec {
+			ref = redactedMask // the arguments may contain secrets
+		}
+		return "", fmt.Errorf("%w: %s:%s: %w", ErrSecretRef, scheme, ref, err)
+	}
+	return secret, nil
+}
+
+// maskSecret returns the value with the secret masked: the references are
+// kept as is, since they are not secrets, except the "exec:" ones, whose
+// arguments may contain secrets (e.g. a token), which are masked as a whole.
+func maskSecret(value string) string {
+	if value == "" || (IsSecretRef(value) && !strings.HasPrefix(value, SecretExec+":")) {
+		return value
+	}
+	return redactedMask
+}
+
+func resolveEnvSecret(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable is not set")
+	}
+	return value, nil
+}
+
+func resolveFileSecret(_ context.Context, name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return trimNewline(string(data)), nil
+}
+
+func resolveExecSecret(ctx context.Context, command string) (string, error) {
+	args, err := cmdSlice(command)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrParseCommand, err)
+	}
+	if len(args) == 0 {
+		return "", ErrEmptyCommand
+	}
+	// the stderr is not in the error, in case it contains the secret.
+	out, err := osexec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("command failed: %w", err)
+	}
+	return trimNewline(string(out)), nil
+}
+
+// trimNewline removes a trailing "\n" or "\r\n".
+func trimNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
+
+// secret reference errors
+var (
+	ErrSecretRef = errors.New("failed to resolve secret reference")
+)
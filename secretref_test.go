@@ -0,0 +1,207 @@
+package rexec
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("REXEC_TEST_SECRET", "hunter2")
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(file, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	RegisterSecretResolver("vault", SecretResolverFunc(func(ctx context.Context, ref string) (string, error) {
+		if ref == "secret/ssh" {
+			return "hunter2", nil
+		}
+		return "", fmt.Errorf("no such secret")
+	}))
+	defer RegisterSecretResolver("vault", nil)
+	RegisterSecretResolver(SecretExec, ExecSecretResolver)
+	defer RegisterSecretResolver(SecretExec, nil)
+
+	tests := []struct {
+		value   string
+		want    string
+		isRef   bool
+		wantErr bool
+	}{
+		{value: "hunter2", want: "hunter2"},
+		{value: "unknown:hunter2", want: "unknown:hunter2"},
+		{value: "env:REXEC_TEST_SECRET", want: "hunter2", isRef: true},
+		{value: "env:REXEC_TEST_NOT_SET", isRef: true, wantErr: true},
+		{value: "file:" + file, want: "hunter2", isRef: true},
+		{value: "file:/not/found/secret", isRef: true, wantErr: true},
+		{value: "exec:echo hunter2", want: "hunter2", isRef: true},
+		{value: "exec:sh -c 'echo hunter2; exit 1'", isRef: true, wantErr: true},
+		{value: "exec:", isRef: true, wantErr: true},
+		{value: "literal:env:REXEC_TEST_SECRET", want: "env:REXEC_TEST_SECRET"},
+		{value: "vault:secret/ssh", want: "hunter2", isRef: true},
+		{value: "vault:secret/nope", isRef: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveSecret(context.Background(), tt.value)
+		switch {
+		case IsSecretRef(tt.value) != tt.isRef:
+			t.Errorf("❌ IsSecretRef(%q) = %v, want %v", tt.value, !tt.isRef, tt.isRef)
+		case tt.wantErr && !errors.Is(err, ErrSecretRef):
+			t.Errorf("❌ ResolveSecret(%q) error = %v, want ErrSecretRef", tt.value, err)
+		case tt.wantErr && (!strings.Contains(err.Error(), maskExecRef(tt.value)) || strings.Contains(strings.ReplaceAll(err.Error(), tt.value, ""), "hunter2")):
+			t.Errorf("❌ ResolveSecret(%q) error = %v, want the reference without the secret", tt.value, err)
+		case !tt.wantErr && (err != nil || got != tt.want):
+			t.Errorf("❌ ResolveSecret(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		default:
+			t.Logf("✅ ResolveSecret(%q) = %q, %v", tt.value, got, err)
+		}
+	}
+}
+
+// maskExecRef returns the value as named in the errors: the arguments of
+// the exec: references are masked.
+func maskExecRef(value string) string {
+	if strings.HasPrefix(value, SecretExec+":") {
+		return SecretExec + ":" + redactedMask
+	}
+	return value
+}
+
+func TestSecretExec_optIn(t *testing.T) {
+	const value = "exec:echo hunter2"
+	if IsSecretRef(value) {
+		t.Fatalf("❌ IsSecretRef(%q) = true, want exec: not registered by default", value)
+	}
+	if got, err := ResolveSecret(context.Background(), value); err != nil || got != value {
+		t.Errorf("❌ ResolveSecret(%q) = %q, %v, want the literal value", value, got, err)
+	}
+
+	RegisterSecretResolver(SecretExec, ExecSecretResolver)
+	defer RegisterSecretResolver(SecretExec, nil)
+	if got, err := ResolveSecret(context.Background(), value); err != nil || got != "hunter2" {
+		t.Errorf("❌ ResolveSecret(%q) = %q, %v, want %q once registered", value, got, err, "hunter2")
+	}
+	t.Logf("✅ exec: is opt-in")
+}
+
+func TestMaskSecret(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "", want: ""},
+		{value: "hunter2", want: redactedMask},
+		{value: "env:SSH_PASS", want: "env:SSH_PASS"},
+		{value: "file:/run/secrets/key", want: "file:/run/secrets/key"},
+		{value: "literal:env:hunter2", want: redactedMask},
+		{value: "exec:vault read -token=hunter2 secret/ssh", want: redactedMask},
+	}
+	for _, register := range []bool{false, true} {
+		if register {
+			RegisterSecretResolver(SecretExec, ExecSecretResolver)
+			defer RegisterSecretResolver(SecretExec, nil)
+		}
+		for _, tt := range tests {
+			if got := maskSecret(tt.value); got != tt.want {
+				t.Errorf("❌ maskSecret(%q) = %q, want %q (exec registered: %v)", tt.value, got, tt.want, register)
+			} else {
+				t.Logf("✅ maskSecret(%q) = %q", tt.value, got)
+			}
+		}
+	}
+}
+
+func TestSshAuth_secretRefs(t *testing.T) {
+	auth := SshAuth{Password: "env:SSH_PASS", PrivateKey: "hunter2", Passphrase: "file:/run/secrets/passphrase"}
+	for _, s := range []string{fmt.Sprint(auth), fmt.Sprintf("%+v", auth)} {
+		if strings.Contains(s, "hunter2") || !strings.Contains(s, "env:SSH_PASS") || !strings.Contains(s, "file:/run/secrets/passphrase") {
+			t.Errorf("❌ SshAuth formatted as %s, want the secret masked and the references kept", s)
+		} else {
+			t.Logf("✅ %s", s)
+		}
+	}
+
+	// an encrypted private key with a passphrase from the env
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REXEC_TEST_PASSPHRASE", "hunter2")
+	t.Setenv("REXEC_TEST_BAD_PASSPHRASE", "nope")
+
+	tests := []struct {
+		name    string
+		auth    SshAuth
+		wantErr bool
+	}{
+		{"password", SshAuth{Password: "env:REXEC_TEST_PASSPHRASE"}, false},
+		{"passwordNotSet", SshAuth{Password: "env:REXEC_TEST_NOT_SET"}, true},
+		{"keyFileRef", SshAuth{PrivateKey: "file:" + keyFile, Passphrase: "env:REXEC_TEST_PASSPHRASE"}, false},
+		{"keyPath", SshAuth{PrivateKeyPath: keyFile, Passphrase: "literal:hunter2"}, false},
+		{"noPassphrase", SshAuth{PrivateKeyPath: keyFile}, true},
+		{"badPassphrase", SshAuth{PrivateKeyPath: keyFile, Passphrase: "env:REXEC_TEST_BAD_PASSPHRASE"}, true},
+		{"passphraseNotSet", SshAuth{PrivateKeyPath: keyFile, Passphrase: "env:REXEC_TEST_NOT_SET"}, true},
+	}
+	for _, tt := range tests {
+		auth := tt.auth
+		err := auth.PrepareContext(context.Background())
+		switch {
+		case (err != nil) != tt.wantErr:
+			t.Errorf("❌ %s: PrepareContext() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		case err != nil && strings.Contains(err.Error(), "hunter2"):
+			t.Errorf("❌ %s: PrepareContext() error leaks the secret: %v", tt.name, err)
+		case auth.Password != tt.auth.Password || auth.Passphrase != tt.auth.Passphrase:
+			t.Errorf("❌ %s: PrepareContext() stored the resolved secret", tt.name)
+		default:
+			t.Logf("✅ %s: PrepareContext() error = %v", tt.name, err)
+		}
+	}
+}
+
+func TestImmediateSshExecutor_secretRefs(t *testing.T) {
+	t.Setenv("REXEC_TEST_SSH_PASS", "root")
+
+	for _, auth := range []SshAuth{
+		{Password: "env:REXEC_TEST_SSH_PASS"},
+		{PrivateKey: "file:./testsshd/testsshd.id_rsa"},
+	} {
+		executor := &ImmediateSshExecutor{Config: &SshClientConfig{
+			Addr:           "localhost:24622",
+			User:           "root",
+			Auth:           []SshAuth{auth},
+			TimeoutSeconds: 5,
+			HostKeyCheck:   ignoreHostKeyCheck,
+		}}
+		m := NewManagedIO()
+		cmd := &Command{Command: "echo hello"}
+		m.Hijack(cmd)
+		if err := executor.Execute(context.Background(), cmd); err != nil || m.Stdout.String() != "hello\n" {
+			t.Errorf("❌ %v: Execute() = %q, %v", auth, m.Stdout.String(), err)
+		} else {
+			t.Logf("✅ %v: %q", auth, m.Stdout.String())
+		}
+	}
+
+	_, err := hostKeyCallback(context.Background(), &SshHostKeyCheckConfig{FixedHostKey: "env:REXEC_TEST_NOT_SET"})
+	if !errors.Is(err, ErrSecretRef) || !strings.Contains(err.Error(), "env:REXEC_TEST_NOT_SET") {
+		t.Errorf("❌ hostKeyCallback() error = %v, want ErrSecretRef naming the reference", err)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/cdfmlr/rexec/v2"
+)
+
+// Token is what a bearer token grants.
+type Token struct {
+	// Name identifies the holder of the token: it is the principal of the
+	// commands run with it (see rexec.WithPrincipal), e.g. in audit logs.
+	Name string
+	// Executors are the glob patterns (path.Match) of the names of the
+	// executors the token may use. Empty means all of them.
+	Executors []string
+	// Policy, if not nil, checks the commands run with the token on the
+	// target of the executor (see rexec.Policy.Check).
+	Policy *rexec.Policy
+}
+
+// allows reports whether the token may use the executor named name.
+func (t *Token) allows(name string) bool {
+	if len(t.Executors) == 0 {
+		return true
+	}
+	for _, pattern := range t.Executors {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check checks the command against the Policy of the token, if any.
+func (t *Token) check(cmd *rexec.Command, executor rexec.Executor) error {
+	if t.Policy == nil {
+		return nil
+	}
+	return t.Policy.Check(cmd, rexec.TargetOf(executor).Name())
+}
+
+// authenticate returns the Token of the bearer token of the request,
+// or nil if it is missing or unknown.
+//
+// The token is compared with all the known ones in constant time.
+func (s *Server) authenticate(r *http.Request) *Token {
+	scheme, bearer, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || bearer == "" {
+		return nil
+	}
+	digest := sha256.Sum256([]byte(bearer))
+
+	var found *Token
+	for secret, token := range s.Tokens {
+		known := sha256.Sum256([]byte(secret))
+		if subtle.ConstantTimeCompare(digest[:], known[:]) == 1 {
+			found = &token
+		}
+	}
+	return found
+}
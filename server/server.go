@@ -0,0 +1,469 @@
+// Package server exposes rexec executors as an HTTP/JSON service.
+//
+// The Server runs rexec.Commands (in their JSON form) on named,
+// pre-configured executors, for the clients holding bearer tokens:
+//
+//	srv := &server.Server{
+//		Executors: map[string]rexec.Executor{
+//			"web1": &rexec.KeepAliveSshExecutor{Config: webConfig},
+//		},
+//		Tokens: map[string]server.Token{
+//			os.Getenv("DEPLOY_TOKEN"): {Name: "deploy-bot", Executors: []string{"web*"}, Policy: policy},
+//		},
+//	}
+//	defer srv.Close()
+//	log.Fatal(http.ListenAndServe(":8080", srv))
+//
+// The routes are:
+//
+//	GET    /v1/executors               the executors the token may use
+//	POST   /v1/executors/{name}/run    run the Command in the body, and respond with its Result;
+//	                                   ?stream=sse or ?stream=chunked streams the outputs
+//	POST   /v1/executors/{name}/jobs   start the Command in the body as a Job
+//	GET    /v1/jobs/{id}               the Job
+//	DELETE /v1/jobs/{id}               cancel the Job
+//
+// Every request needs an "Authorization: Bearer <token>" header. The errors
+// are responded as {"Error": "..."} with the HTTP status: 400 for bad
+// commands, 401 for bad tokens, 403 for commands denied by the Policy of
+// the token, 404 for unknown executors and jobs (including the ones the
+// token may not use), and 503 when the queue of the jobs is full.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cdfmlr/rexec/v2"
+)
+
+// DefaultMaxOutputBytes is the MaxOutputBytes of a Server that does not set
+// it.
+const DefaultMaxOutputBytes = 1 << 20
+
+// DefaultMaxDoneJobs is the MaxDoneJobs of a Server that does not set it.
+const DefaultMaxDoneJobs = 100
+
+// maxRequestBytes limits the size of the request bodies.
+const maxRequestBytes = 1 << 20
+
+// Server is an http.Handler running commands on its Executors.
+// See the package doc for the API.
+//
+// The Server does not own the Executors: close them after closing the
+// Server.
+type Server struct {
+	// Executors are the executors to run commands on, by name.
+	Executors map[string]rexec.Executor
+	// Tokens are the bearer tokens accepted, with what they grant.
+	// Without tokens, all the requests are rejected.
+	Tokens map[string]Token
+
+	// MaxOutputBytes caps the stdout and the stderr kept in a Result
+	// (each). The rest is discarded, and the Result is marked truncated.
+	// If <= 0, DefaultMaxOutputBytes is used. Streamed outputs are not
+	// capped.
+	MaxOutputBytes int64
+
+	// MaxDoneJobs is the number of done jobs (with their outputs) kept by
+	// the own JobManager of the Server, if the Jobs are not given: the
+	// older ones are removed. If <= 0, DefaultMaxDoneJobs is used.
+	MaxDoneJobs int
+
+	// Jobs runs the jobs. If nil, the Server runs them with its own
+	// rexec.JobManager, on a rexec.MemoryJobStore keeping the last
+	// MaxDoneJobs done jobs, with the outputs capped at MaxOutputBytes. Use
+	// a JobManager on a rexec.FileJobStore to keep the jobs across
+	// restarts.
+	//
+	// The Server does not own the Jobs: close them after closing the Server.
+	Jobs *rexec.JobManager `json:"-"`
+
+	// Logger, if not nil, overrides the global rexec.Logger for the server.
+	Logger *slog.Logger `json:"-"`
+
+	initOnce sync.Once
+	mux      *http.ServeMux
+	jobs     *rexec.JobManager
+	ownJobs  bool // the jobs are not the given Jobs: Close closes them
+}
+
+var _ http.Handler = (*Server)(nil)
+
+func (s *Server) init() {
+	s.jobs = s.Jobs
+	if s.jobs == nil {
+		s.jobs = &rexec.JobManager{
+			Store:          &rexec.MemoryJobStore{MaxDoneJobs: s.maxDoneJobs()},
+			MaxOutputBytes: s.maxOutputBytes(),
+			Logger:         s.Logger,
+		}
+		s.ownJobs = true
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /v1/executors", s.handleListExecutors)
+	s.mux.HandleFunc("POST /v1/executors/{name}/run", s.handleRun)
+	s.mux.HandleFunc("POST /v1/executors/{name}/jobs", s.handleStartJob)
+	s.mux.HandleFunc("GET /v1/jobs/{id}", s.handleGetJob)
+	s.mux.HandleFunc("DELETE /v1/jobs/{id}", s.handleCancelJob)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.initOnce.Do(s.init)
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close cancels the queued and running jobs and waits for them to finish,
+// unless the Jobs are given: they are closed by their owner.
+func (s *Server) Close() error {
+	s.initOnce.Do(s.init)
+	if !s.ownJobs {
+		return nil
+	}
+	return s.jobs.Close()
+}
+
+// logger returns the logger of the server.
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return rexec.Logger
+}
+
+func (s *Server) maxDoneJobs() int {
+	if s.MaxDoneJobs > 0 {
+		return s.MaxDoneJobs
+	}
+	return DefaultMaxDoneJobs
+}
+
+func (s *Server) maxOutputBytes() int64 {
+	if s.MaxOutputBytes > 0 {
+		return s.MaxOutputBytes
+	}
+	return DefaultMaxOutputBytes
+}
+
+// ExecutorInfo describes an executor of the Server.
+type ExecutorInfo struct {
+	Name   string
+	Target rexec.TargetInfo
+}
+
+func (s *Server) handleListExecutors(w http.ResponseWriter, r *http.Request) {
+	token := s.authenticate(r)
+	if token == nil {
+		s.unauthorized(w)
+		return
+	}
+
+	executors := []ExecutorInfo{}
+	for name, executor := range s.Executors {
+		if token.allows(name) {
+			executors = append(executors, ExecutorInfo{Name: name, Target: rexec.TargetOf(executor)})
+		}
+	}
+	sort.Slice(executors, func(i, j int) bool { return executors[i].Name < executors[j].Name })
+	writeJSON(w, http.StatusOK, executors)
+}
+
+// Result is the result of a command.
+type Result struct {
+	// Status is the exit status of the command (see rexec.Command.Status).
+	Status int
+	// Error is the error of the executor, if any.
+	Error string `json:",omitempty"`
+
+	Stdout string
+	Stderr string
+	// StdoutTruncated and StderrTruncated report if the outputs exceeded
+	// the MaxOutputBytes of the Server.
+	StdoutTruncated bool `json:",omitempty"`
+	StderrTruncated bool `json:",omitempty"`
+
+	Start time.Time
+	End   time.Time
+}
+
+// setError sets the Error of the result to the err, if not nil.
+func (r *Result) setError(err error) {
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.accept(w, r)
+	if !ok {
+		return
+	}
+	logger := s.logger().With("field", "rexec/server.Server.handleRun", "executor", req.name, "principal", req.token.Name)
+
+	result := Result{Status: -1}
+	switch mode := r.URL.Query().Get("stream"); mode {
+	case streamNone:
+		stdout, stderr := newCappedBuffer(s.maxOutputBytes()), newCappedBuffer(s.maxOutputBytes())
+		req.cmd.Stdout, req.cmd.Stderr = stdout, stderr
+
+		result.Start = time.Now()
+		err := req.executor.Execute(req.ctx, req.cmd)
+		result.End = time.Now()
+
+		result.Status = req.cmd.Status
+		result.setError(err)
+		result.Stdout, result.StdoutTruncated = stdout.result()
+		result.Stderr, result.StderrTruncated = stderr.result()
+		logger.Info("command finished", "status", result.Status, "err", err)
+		writeJSON(w, http.StatusOK, result)
+
+	case streamSSE:
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		out := &flushWriter{w: w}
+		req.cmd.Stdout = sseWriter{flushWriter: out, event: "stdout"}
+		req.cmd.Stderr = sseWriter{flushWriter: out, event: "stderr"}
+
+		result.Start = time.Now()
+		err := req.executor.Execute(req.ctx, req.cmd)
+		result.End = time.Now()
+
+		result.Status = req.cmd.Status
+		result.setError(err)
+		logger.Info("command finished", "status", result.Status, "err", err)
+		_ = out.writeEvent("result", result)
+
+	case streamChunked:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Trailer", trailerStatus+", "+trailerError)
+		w.WriteHeader(http.StatusOK)
+		out := chunkedWriter{&flushWriter{w: w}}
+		req.cmd.Stdout, req.cmd.Stderr = out, out
+
+		err := req.executor.Execute(req.ctx, req.cmd)
+
+		w.Header().Set(trailerStatus, strconv.Itoa(req.cmd.Status))
+		if err != nil {
+			w.Header().Set(trailerError, err.Error())
+		}
+		logger.Info("command finished", "status", req.cmd.Status, "err", err)
+
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown stream %q: want %s or %s", mode, streamSSE, streamChunked))
+	}
+}
+
+func (s *Server) handleStartJob(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.accept(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := s.jobs.Submit(req.ctx, req.name, req.executor, req.cmd)
+	if errors.Is(err, rexec.ErrJobQueueFull) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.logger().Info("job submitted", "field", "rexec/server.Server.handleStartJob",
+		"job", job.ID, "executor", req.name, "principal", req.token.Name)
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, Job{Job: *job})
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	s.writeJob(w, job)
+}
+
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	if err := s.jobs.Cancel(job.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	job, err := s.jobs.Wait(r.Context(), job.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.logger().Info("job cancelled", "field", "rexec/server.Server.handleCancelJob", "job", job.ID)
+	s.writeJob(w, job)
+}
+
+// Job is a rexec.Job, with its outputs so far.
+type Job struct {
+	rexec.Job
+	Stdout string
+	Stderr string
+}
+
+// writeJob responds with the Job of the job.
+func (s *Server) writeJob(w http.ResponseWriter, job *rexec.Job) {
+	stdout, err := s.jobs.Output(job.ID, rexec.JobStdout)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	stderr, err := s.jobs.Output(job.ID, rexec.JobStderr)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, Job{Job: *job, Stdout: string(stdout), Stderr: string(stderr)})
+}
+
+// request is an accepted request to run a command.
+type request struct {
+	ctx      context.Context // with the principal
+	token    *Token
+	name     string
+	executor rexec.Executor
+	cmd      *rexec.Command
+}
+
+// accept authenticates the request to run the command in the body on the
+// executor in the path, checks the command, and returns it. Otherwise, it
+// responds with the error, and returns false.
+func (s *Server) accept(w http.ResponseWriter, r *http.Request) (*request, bool) {
+	token := s.authenticate(r)
+	if token == nil {
+		s.unauthorized(w)
+		return nil, false
+	}
+
+	name := r.PathValue("name")
+	executor, ok := s.Executors[name]
+	if !ok || !token.allows(name) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown executor %q", name))
+		return nil, false
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	cmd := &rexec.Command{}
+	if err := rexec.DecodeConfig(data, cmd); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad command: %w", err))
+		return nil, false
+	}
+	cmd.Status = 0
+	if err := cmd.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	if err := token.check(cmd, executor); err != nil {
+		s.logger().Warn("command denied", "field", "rexec/server.Server.accept",
+			"executor", name, "principal", token.Name, "err", err)
+		writeError(w, http.StatusForbidden, err)
+		return nil, false
+	}
+
+	return &request{
+		ctx:      rexec.WithPrincipal(r.Context(), token.Name),
+		token:    token,
+		name:     name,
+		executor: executor,
+		cmd:      cmd,
+	}, true
+}
+
+// job authenticates the request on the job in the path, and returns it.
+// Otherwise, it responds with the error, and returns false.
+// The jobs of the other principals are not found.
+func (s *Server) job(w http.ResponseWriter, r *http.Request) (*rexec.Job, bool) {
+	token := s.authenticate(r)
+	if token == nil {
+		s.unauthorized(w)
+		return nil, false
+	}
+	id := r.PathValue("id")
+	job, err := s.jobs.Get(id)
+	if errors.Is(err, rexec.ErrJobNotFound) || err == nil && job.Principal != token.Name {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown job %q", id))
+		return nil, false
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return job, true
+}
+
+func (s *Server) unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="rexec"`)
+	writeError(w, http.StatusUnauthorized, errors.New("missing or unknown bearer token"))
+}
+
+// writeJSON responds with the JSON encoding of v.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with {"Error": err}.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct{ Error string }{Error: err.Error()})
+}
+
+// cappedBuffer keeps the first max bytes written to it, and discards the
+// rest. It is safe for concurrent use, so that a running command can be
+// read.
+type cappedBuffer struct {
+	mu        sync.Mutex
+	buf       []byte
+	max       int64
+	truncated bool
+}
+
+func newCappedBuffer(max int64) *cappedBuffer {
+	return &cappedBuffer{max: max}
+}
+
+// Write never fails, so that the command is not broken by the cap.
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	room := b.max - int64(len(b.buf))
+	if int64(len(p)) > room {
+		b.buf = append(b.buf, p[:max(room, 0)]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// result returns what is kept, and if the rest was discarded.
+func (b *cappedBuffer) result() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf), b.truncated
+}
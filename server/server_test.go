@@ -0,0 +1,401 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdfmlr/rexec/v2"
+	"github.com/cdfmlr/rexec/v2/internal/testsshd"
+)
+
+const (
+	adminToken    = "admin-token"
+	readOnlyToken = "read-only-token"
+)
+
+// newTestServer returns a Server with a shell executor "sh" and an SSH
+// executor "ssh" (on a testsshd), served by an httptest.Server.
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+
+	sshd, err := testsshd.New(nil)
+	if err != nil {
+		t.Fatalf("❌ testsshd.New() error = %v", err)
+	}
+	t.Cleanup(func() { _ = sshd.Close() })
+
+	ssh := &rexec.KeepAliveSshExecutor{Config: &rexec.SshClientConfig{
+		Addr:         sshd.Addr(),
+		User:         "testuser",
+		Auth:         []rexec.SshAuth{{Password: "test"}},
+		HostKeyCheck: &rexec.SshHostKeyCheckConfig{InsecureIgnore: true},
+	}}
+	t.Cleanup(func() { _ = ssh.Close() })
+
+	readOnly := &rexec.Policy{Rules: []rexec.PolicyRule{{Name: "read-only", Action: rexec.PolicyAllow, Programs: []string{"echo", "cat"}}}}
+
+	srv := &Server{
+		Executors: map[string]rexec.Executor{
+			"sh":  &rexec.ShellExecutor{ShellPath: "/bin/sh", ShellArgs: []string{"-c"}},
+			"ssh": ssh,
+		},
+		Tokens: map[string]Token{
+			adminToken:    {Name: "admin"},
+			readOnlyToken: {Name: "reader", Executors: []string{"ss*"}, Policy: readOnly},
+		},
+		MaxOutputBytes: 64,
+	}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		ts.Close()
+		_ = srv.Close()
+	})
+	return srv, ts
+}
+
+// do sends the request with the token, and returns the response with its
+// body read.
+func do(t *testing.T, method, url, token, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("❌ %s %s error = %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+// decode decodes the JSON body into a T.
+func decode[T any](t *testing.T, body string) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		t.Fatalf("❌ decode %q: %v", body, err)
+	}
+	return v
+}
+
+func TestServer_run(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		token      string
+		executor   string
+		body       string
+		wantCode   int
+		wantResult Result // if wantCode is 200
+		wantError  string // a substring, otherwise
+	}{
+		{
+			name:       "shell",
+			token:      adminToken,
+			executor:   "sh",
+			body:       `{"Command": "echo $GREETING; echo oops >&2; exit 3", "Env": {"GREETING": "hello"}}`,
+			wantCode:   http.StatusOK,
+			wantResult: Result{Status: 3, Stdout: "hello\n", Stderr: "oops\n", Error: "exit status 3"},
+		},
+		{
+			name:       "ssh",
+			token:      adminToken,
+			executor:   "ssh",
+			body:       `{"Command": "echo hello from ssh"}`,
+			wantCode:   http.StatusOK,
+			wantResult: Result{Status: 0, Stdout: "hello from ssh\n"},
+		},
+		{
+			name:       "yaml",
+			token:      readOnlyToken,
+			executor:   "ssh",
+			body:       "command: echo yaml\n",
+			wantCode:   http.StatusOK,
+			wantResult: Result{Status: 0, Stdout: "yaml\n"},
+		},
+		{
+			name:       "truncated",
+			token:      adminToken,
+			executor:   "sh",
+			body:       `{"Command": "head -c 100 /dev/zero | tr '\\0' x"}`,
+			wantCode:   http.StatusOK,
+			wantResult: Result{Status: 0, Stdout: strings.Repeat("x", 64), StdoutTruncated: true},
+		},
+		{
+			name:      "no token",
+			executor:  "sh",
+			body:      `{"Command": "true"}`,
+			wantCode:  http.StatusUnauthorized,
+			wantError: "bearer token",
+		},
+		{
+			name:      "bad token",
+			token:     "nope",
+			executor:  "sh",
+			body:      `{"Command": "true"}`,
+			wantCode:  http.StatusUnauthorized,
+			wantError: "bearer token",
+		},
+		{
+			name:      "executor not allowed",
+			token:     readOnlyToken,
+			executor:  "sh",
+			body:      `{"Command": "echo hi"}`,
+			wantCode:  http.StatusNotFound,
+			wantError: `unknown executor "sh"`,
+		},
+		{
+			name:      "unknown executor",
+			token:     adminToken,
+			executor:  "nope",
+			body:      `{"Command": "true"}`,
+			wantCode:  http.StatusNotFound,
+			wantError: `unknown executor "nope"`,
+		},
+		{
+			name:      "denied by policy",
+			token:     readOnlyToken,
+			executor:  "ssh",
+			body:      `{"Command": "rm -rf /tmp/x"}`,
+			wantCode:  http.StatusForbidden,
+			wantError: "denied by policy",
+		},
+		{
+			name:      "unknown field",
+			token:     adminToken,
+			executor:  "sh",
+			body:      `{"Comand": "true"}`,
+			wantCode:  http.StatusBadRequest,
+			wantError: "Comand: unknown field",
+		},
+		{
+			name:      "empty command",
+			token:     adminToken,
+			executor:  "sh",
+			body:      `{}`,
+			wantCode:  http.StatusBadRequest,
+			wantError: "command is empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := do(t, http.MethodPost, ts.URL+"/v1/executors/"+tt.executor+"/run", tt.token, tt.body)
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("❌ status code = %d, want %d: %s", resp.StatusCode, tt.wantCode, body)
+			}
+			if tt.wantCode != http.StatusOK {
+				if got := decode[struct{ Error string }](t, body).Error; !strings.Contains(got, tt.wantError) {
+					t.Errorf("❌ Error = %q, want %q", got, tt.wantError)
+				}
+				t.Logf("✅ %d %s", resp.StatusCode, body)
+				return
+			}
+
+			got := decode[Result](t, body)
+			if got.Start.IsZero() || got.End.Before(got.Start) {
+				t.Errorf("❌ Start = %v, End = %v", got.Start, got.End)
+			}
+			got.Start, got.End = time.Time{}, time.Time{}
+			if got != tt.wantResult {
+				t.Errorf("❌ Result = %+v, want %+v", got, tt.wantResult)
+			}
+			t.Logf("✅ %d %s", resp.StatusCode, body)
+		})
+	}
+}
+
+func TestServer_run_stream(t *testing.T) {
+	_, ts := newTestServer(t)
+	body := `{"Command": "echo one; echo two >&2; exit 2"}`
+
+	t.Run("sse", func(t *testing.T) {
+		resp, data := do(t, http.MethodPost, ts.URL+"/v1/executors/sh/run?stream=sse", adminToken, body)
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("❌ Content-Type = %q", ct)
+		}
+
+		events := map[string][]string{}
+		scanner := bufio.NewScanner(strings.NewReader(data))
+		var event string
+		for scanner.Scan() {
+			line := scanner.Text()
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event = name
+			} else if d, ok := strings.CutPrefix(line, "data: "); ok {
+				events[event] = append(events[event], d)
+			}
+		}
+		if got := strings.Join(events["stdout"], ""); got != `"one\n"` {
+			t.Errorf("❌ stdout events = %v", events["stdout"])
+		}
+		if got := strings.Join(events["stderr"], ""); got != `"two\n"` {
+			t.Errorf("❌ stderr events = %v", events["stderr"])
+		}
+		if len(events["result"]) != 1 || decode[Result](t, events["result"][0]).Status != 2 {
+			t.Errorf("❌ result events = %v", events["result"])
+		}
+		t.Logf("✅ SSE:\n%s", data)
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		resp, data := do(t, http.MethodPost, ts.URL+"/v1/executors/sh/run?stream=chunked", adminToken, body)
+		if !strings.Contains(data, "one\n") || !strings.Contains(data, "two\n") {
+			t.Errorf("❌ body = %q", data)
+		}
+		if got := resp.Trailer.Get(trailerStatus); got != "2" {
+			t.Errorf("❌ %s trailer = %q, want 2", trailerStatus, got)
+		}
+		if got := resp.Trailer.Get(trailerError); got != "exit status 2" {
+			t.Errorf("❌ %s trailer = %q", trailerError, got)
+		}
+		t.Logf("✅ chunked: %q, trailers: %v", data, resp.Trailer)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		resp, _ := do(t, http.MethodPost, ts.URL+"/v1/executors/sh/run?stream=ws", adminToken, body)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("❌ status code = %d, want 400", resp.StatusCode)
+		}
+	})
+}
+
+func TestServer_jobs(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	start := func(command string) Job {
+		t.Helper()
+		resp, body := do(t, http.MethodPost, ts.URL+"/v1/executors/sh/jobs", adminToken, `{"Command": "`+command+`"}`)
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("❌ start job: status code = %d: %s", resp.StatusCode, body)
+		}
+		job := decode[Job](t, body)
+		if loc := resp.Header.Get("Location"); loc != "/v1/jobs/"+job.ID {
+			t.Errorf("❌ Location = %q", loc)
+		}
+		return job
+	}
+	poll := func(id string, token string) (int, Job) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			resp, body := do(t, http.MethodGet, ts.URL+"/v1/jobs/"+id, token, "")
+			if resp.StatusCode != http.StatusOK {
+				return resp.StatusCode, Job{}
+			}
+			job := decode[Job](t, body)
+			if job.State.Done() || time.Now().After(deadline) {
+				return resp.StatusCode, job
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("succeeded", func(t *testing.T) {
+		job := start("echo done")
+		if job.State != rexec.JobQueued || job.Principal != "admin" || job.Executor != "sh" {
+			t.Errorf("❌ started job = %+v", job)
+		}
+		_, job = poll(job.ID, adminToken)
+		if job.State != rexec.JobSucceeded || job.Status != 0 || job.Stdout != "done\n" {
+			t.Errorf("❌ job = %+v, want succeeded", job)
+		}
+		if code, _ := poll(job.ID, readOnlyToken); code != http.StatusNotFound {
+			t.Errorf("❌ job of another principal: status code = %d, want 404", code)
+		}
+		t.Logf("✅ job = %+v", job)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		_, job := poll(start("exit 4").ID, adminToken)
+		if job.State != rexec.JobFailed || job.Status != 4 {
+			t.Errorf("❌ job = %+v, want failed with status 4", job)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		job := start("sleep 10")
+		began := time.Now()
+		resp, body := do(t, http.MethodDelete, ts.URL+"/v1/jobs/"+job.ID, adminToken, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("❌ DELETE status code = %d: %s", resp.StatusCode, body)
+		}
+		job = decode[Job](t, body)
+		if job.State != rexec.JobCancelled || time.Since(began) > 5*time.Second {
+			t.Errorf("❌ job = %+v, want cancelled", job)
+		}
+		t.Logf("✅ job = %+v", job)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		if code, _ := poll("nope", adminToken); code != http.StatusNotFound {
+			t.Errorf("❌ status code = %d, want 404", code)
+		}
+	})
+}
+
+func TestServer_MaxDoneJobs(t *testing.T) {
+	srv, ts := newTestServer(t)
+	srv.MaxDoneJobs = 1 // before the first request: the jobs are not created yet
+
+	var ids []string
+	for range 2 {
+		resp, body := do(t, http.MethodPost, ts.URL+"/v1/executors/sh/jobs", adminToken, `{"Command": "true"}`)
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("❌ start job: status code = %d: %s", resp.StatusCode, body)
+		}
+		id := decode[Job](t, body).ID
+		if _, err := srv.jobs.Wait(context.Background(), id); err != nil {
+			t.Fatalf("❌ Wait() error = %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	for i, wantCode := range []int{http.StatusNotFound, http.StatusOK} {
+		if resp, body := do(t, http.MethodGet, ts.URL+"/v1/jobs/"+ids[i], adminToken, ""); resp.StatusCode != wantCode {
+			t.Errorf("❌ GET job %d: status code = %d, want %d: %s", i, resp.StatusCode, wantCode, body)
+		}
+	}
+	t.Logf("✅ the older done job is removed")
+}
+
+func TestServer_Close(t *testing.T) {
+	srv, ts := newTestServer(t)
+	resp, body := do(t, http.MethodPost, ts.URL+"/v1/executors/sh/jobs", adminToken, `{"Command": "sleep 10"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("❌ status code = %d: %s", resp.StatusCode, body)
+	}
+	id := decode[Job](t, body).ID
+
+	done := make(chan error)
+	go func() { done <- srv.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("❌ Close() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("❌ Close() does not cancel the running jobs")
+	}
+
+	job, err := srv.jobs.Get(id)
+	if err != nil || job.State != rexec.JobCancelled {
+		t.Errorf("❌ job = (%v, %v) after Close(), want %s", job, err, rexec.JobCancelled)
+	}
+	t.Logf("✅ Close() cancels the running jobs")
+}
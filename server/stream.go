@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// stream modes of the run endpoint (the "stream" query param)
+const (
+	streamNone    = ""
+	streamSSE     = "sse"     // Server-Sent Events: "stdout", "stderr" and "result" events
+	streamChunked = "chunked" // the outputs as is, with the status in the trailers
+)
+
+// trailers of chunked streams
+const (
+	trailerStatus = "Rexec-Status"
+	trailerError  = "Rexec-Error"
+)
+
+// flushWriter writes to an http.ResponseWriter and flushes after each
+// write. The writes of the stdout and stderr are serialized.
+type flushWriter struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+}
+
+func (f *flushWriter) write(fn func() error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := fn(); err != nil {
+		return err
+	}
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// chunkedWriter writes the outputs as is to the body.
+type chunkedWriter struct{ *flushWriter }
+
+func (c chunkedWriter) Write(p []byte) (int, error) {
+	err := c.write(func() error {
+		_, err := c.w.Write(p)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sseWriter writes the outputs as Server-Sent Events of the event name,
+// with the chunks JSON-encoded as the data, so that newlines are kept.
+type sseWriter struct {
+	*flushWriter
+	event string
+}
+
+func (s sseWriter) Write(p []byte) (int, error) {
+	if err := s.writeEvent(s.event, string(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeEvent writes an event with the JSON encoding of v as its data.
+func (f *flushWriter) writeEvent(event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return f.write(func() error {
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event, data)
+		_, err := f.w.Write(b.Bytes())
+		return err
+	})
+}
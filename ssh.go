@@ -1,7 +1,9 @@
 package rexec
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
@@ -20,18 +22,27 @@ import (
 // // // ssh dialing // // //
 
 // dialSsh is a helper function to prepare authentication methods and
-// dial the SSH client.
-func dialSsh(config *SshClientConfig) (*ssh.Client, error) {
-	authMethods, errs := prepareSshAuthMethods(config.Auth)
+// dial the SSH client. The logger and tracer are the resolved ones of the
+// caller.
+//
+// It is traced as a "rexec.dialSsh" span, with the "rexec.ssh.connect" (TCP)
+// and "rexec.ssh.handshake" (SSH) phases as its children.
+// The TCP connection is canceled if ctx is done.
+func dialSsh(ctx context.Context, logger *slog.Logger, tracer Tracer, config *SshClientConfig) (client *ssh.Client, err error) {
+	ctx, span := tracer.Start(ctx, "rexec.dialSsh",
+		slog.String(AttrHost, config.Addr), slog.String(AttrUser, config.User))
+	defer func() { endSpan(span, err) }()
+
+	authMethods, errs := prepareSshAuthMethods(ctx, config.Auth)
 	for _, authErr := range errs {
 		if authErr != nil {
 			// It's totally fine to error here, since there can be multiple auth methods.
 			// And if all of them failed, the connection will fail and a well-formed error
 			// will be returned by ssh.Dial.
-			Logger.Warn("failed to prepare SSH auth methods", "err", authErr)
+			logger.Warn("failed to prepare SSH auth methods", "err", authErr)
 		}
 	}
-	hostKeyCheck, err := hostKeyCallback(config.HostKeyCheck)
+	hostKeyCheck, err := hostKeyCallback(ctx, config.HostKeyCheck)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare SSH host key callback: %w", err)
 	}
@@ -42,7 +53,25 @@ func dialSsh(config *SshClientConfig) (*ssh.Client, error) {
 		HostKeyCallback: hostKeyCheck,
 	}
 
-	return ssh.Dial("tcp", config.Addr, clientConfig)
+	// the same as ssh.Dial, but in two traced phases.
+
+	_, connectSpan := tracer.Start(ctx, "rexec.ssh.connect", slog.String(AttrHost, config.Addr))
+	dialer := net.Dialer{Timeout: clientConfig.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", config.Addr)
+	endSpan(connectSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSshDial, err)
+	}
+
+	_, handshakeSpan := tracer.Start(ctx, "rexec.ssh.handshake",
+		slog.String(AttrHost, config.Addr), slog.String(AttrUser, config.User))
+	c, chans, reqs, err := ssh.NewClientConn(conn, config.Addr, clientConfig) // closes conn on error
+	endSpan(handshakeSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSshDial, err)
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
 }
 
 // // // host key checking // // //
@@ -53,18 +82,23 @@ func dialSsh(config *SshClientConfig) (*ssh.Client, error) {
 //	FixedHostKey > KnownHostsPath > InsecureIgnore > default known_hosts > deny all
 //
 // Make it a function instead of a method of SshHostKeyCheckConfig is by design
-// to allow nil config.
+// to allow nil config. The FixedHostKey is resolved with the ctx if it is a
+// secret reference.
 //
 // A nil/zero config means using the default known_hosts,
 // which trys to read from ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts if
 // exist, or it denies all host keys (which makes all connections fail).
-func hostKeyCallback(config *SshHostKeyCheckConfig) (ssh.HostKeyCallback, error) {
+func hostKeyCallback(ctx context.Context, config *SshHostKeyCheckConfig) (ssh.HostKeyCallback, error) {
 	if config == nil {
 		return defaultKnownHostsCallback()
 	}
 
 	if config.FixedHostKey != "" {
-		hostKeyString := strings.TrimSpace(config.FixedHostKey)
+		hostKey, err := ResolveSecret(ctx, config.FixedHostKey)
+		if err != nil {
+			return nil, fmt.Errorf("fixed host key: %w", err)
+		}
+		hostKeyString := strings.TrimSpace(hostKey)
 		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKeyString))
 		if err != nil {
 			return nil, err
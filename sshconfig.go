@@ -1,8 +1,14 @@
 package rexec
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -51,7 +57,9 @@ type SshClientConfig struct {
 // the rest will be ignored.
 type SshHostKeyCheckConfig struct {
 	// FixedHostKey is an "ssh-ed25519 ..." you got from
-	// `ssh-keyscan <server-ip>` (excluding the IP address part)
+	// `ssh-keyscan <server-ip>` (excluding the IP address part).
+	// It may be a secret reference, e.g. "file:/etc/rexec/web1.pub"
+	// (see ResolveSecret).
 	FixedHostKey string
 	// KnownHostsPath is a list of paths to the known_hosts files,
 	// usually ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts
@@ -66,6 +74,34 @@ func (c SshClientConfig) Timeout() time.Duration {
 	return time.Duration(c.TimeoutSeconds) * time.Second
 }
 
+// LogValue implements slog.LogValuer. The secrets in the Auth are masked.
+func (c SshClientConfig) LogValue() slog.Value {
+	auths := make([]any, 0, len(c.Auth))
+	for i, a := range c.Auth {
+		auths = append(auths, slog.Any(strconv.Itoa(i), a))
+	}
+	return slog.GroupValue(
+		slog.String("addr", c.Addr),
+		slog.String("user", c.User),
+		slog.Group("auth", auths...),
+		slog.Int("timeoutSeconds", c.TimeoutSeconds),
+		slog.Any("keepAlive", c.KeepAlive),
+		slog.Any("hostKeyCheck", c.HostKeyCheck),
+	)
+}
+
+// Format implements fmt.Formatter, so that the config is printed with the
+// secrets in the Auth masked.
+func (c SshClientConfig) Format(f fmt.State, verb rune) {
+	type sshClientConfig SshClientConfig // without methods: no recursion
+	masked := c
+	masked.Auth = make([]SshAuth, len(c.Auth))
+	for i, a := range c.Auth {
+		masked.Auth[i] = a.masked()
+	}
+	formatMasked(f, verb, sshClientConfig(masked), "SshClientConfig")
+}
+
 // validateSshClientConfig checks if SshClientConfig is not nil or
 // contains empty Addr.
 func validateSshClientConfig(c *SshClientConfig) error {
@@ -89,28 +125,61 @@ func validateSshClientConfig(c *SshClientConfig) error {
 // SshKeepAliveConfig contains the configuration for the SSH client to keep the
 // connection alive.
 //
-// The final interval between keep-alive will be:
+// The client probes the server every IntervalSeconds. A probe that is not
+// answered within TimeoutSeconds fails. After MaxFailures consecutive failed
+// probes, the connection is declared dead and closed, and the client redials
+// with a backoff until it succeeds.
+//
+// The interval between redials (retries > 0) is:
 //
-//	max(IntervalSeconds + IncrementSeconds * retries, MinSshKeepAliveInterval)
+//	linear:      IntervalSeconds + IncrementSeconds * retries      (Multiplier <= 1)
+//	exponential: IntervalSeconds * Multiplier ^ retries             (Multiplier > 1)
+//
+// capped by MaxIntervalSeconds, randomized by Jitter and then bounded below by
+// MinSshKeepAliveInterval.
 //
 // Special cases:
 //   - If IntervalSeconds < 0, it will be defaulted to 0.
-//   - If IncrementSeconds == 0, the interval will be fixed.
+//   - If IncrementSeconds == 0 (and Multiplier <= 1), the interval will be fixed.
 //   - If IncrementSeconds < 0, the interval will be decreased.
 //   - If the calculated interval is less than MinSshKeepAliveInterval, it will
 //     be defaulted to MinSshKeepAliveInterval.
+//   - If TimeoutSeconds <= 0, a probe times out after one (non-retry) interval.
+//   - If MaxFailures <= 0, the first failed probe closes the connection.
 type SshKeepAliveConfig struct {
 	IntervalSeconds  int // the initial interval between keep-alive, in seconds
 	IncrementSeconds int // the increment of interval between keep-alive, in seconds
+
+	// TimeoutSeconds is the maximum time to wait for the reply of a single
+	// keep-alive probe, in seconds.
+	TimeoutSeconds int
+	// MaxFailures is the number of consecutive failed probes before the
+	// connection is considered dead.
+	MaxFailures int
+
+	// Multiplier enables exponential backoff between redials if it is > 1.
+	Multiplier float64
+	// MaxIntervalSeconds caps the interval between redials. Zero means no cap.
+	MaxIntervalSeconds int
+	// Jitter randomizes the interval between redials by up to ±Jitter
+	// (a fraction in [0, 1]) of it, to avoid many clients redialing in
+	// lockstep.
+	Jitter float64
 }
 
 // MinSshKeepAliveInterval is the minimum interval between keep-alive.
 // This is used as the minimum return value for the interval() function.
 var MinSshKeepAliveInterval = 1 * time.Second
 
-// interval calculates the final interval between keep-alive:
+// maxSshKeepAliveInterval keeps the exponential backoff away from overflowing
+// time.Duration when no MaxIntervalSeconds is configured.
+const maxSshKeepAliveInterval = 24 * time.Hour
+
+// interval calculates the final interval between keep-alive, without jitter:
+//
+//	max(min(IntervalSeconds + IncrementSeconds * retries, MaxIntervalSeconds), MinSshKeepAliveInterval)
 //
-//	max(IntervalSeconds + IncrementSeconds * retries, MinSshKeepAliveInterval)
+// or with IntervalSeconds * Multiplier ^ retries if Multiplier > 1.
 func (c SshKeepAliveConfig) interval(retries int) time.Duration {
 	if c.IntervalSeconds < 0 {
 		c.IntervalSeconds = 0
@@ -119,8 +188,22 @@ func (c SshKeepAliveConfig) interval(retries int) time.Duration {
 		retries = 0
 	}
 
-	i := time.Duration(c.IntervalSeconds) * time.Second
-	i += time.Duration(c.IncrementSeconds) * time.Second * time.Duration(retries)
+	var i time.Duration
+	if c.Multiplier > 1 {
+		base := max(time.Duration(c.IntervalSeconds)*time.Second, MinSshKeepAliveInterval)
+		f := float64(base) * math.Pow(c.Multiplier, float64(retries))
+		if f > float64(maxSshKeepAliveInterval) || math.IsInf(f, 0) || math.IsNaN(f) {
+			f = float64(maxSshKeepAliveInterval)
+		}
+		i = time.Duration(f)
+	} else {
+		i = time.Duration(c.IntervalSeconds) * time.Second
+		i += time.Duration(c.IncrementSeconds) * time.Second * time.Duration(retries)
+	}
+
+	if c.MaxIntervalSeconds > 0 {
+		i = min(i, time.Duration(c.MaxIntervalSeconds)*time.Second)
+	}
 
 	if i < MinSshKeepAliveInterval {
 		i = MinSshKeepAliveInterval
@@ -129,6 +212,44 @@ func (c SshKeepAliveConfig) interval(retries int) time.Duration {
 	return i
 }
 
+// backoff is the interval before the next redial after retries failures:
+// interval(retries) randomized by Jitter.
+//
+// There is no jitter for retries <= 0, which is the regular keep-alive
+// interval.
+func (c SshKeepAliveConfig) backoff(retries int) time.Duration {
+	i := c.interval(retries)
+	if retries <= 0 || c.Jitter <= 0 {
+		return i
+	}
+
+	jitter := min(c.Jitter, 1)
+	delta := (rand.Float64()*2 - 1) * jitter * float64(i) // [-jitter*i, +jitter*i)
+	i += time.Duration(delta)
+
+	if c.MaxIntervalSeconds > 0 {
+		i = min(i, time.Duration(c.MaxIntervalSeconds)*time.Second)
+	}
+	if i < MinSshKeepAliveInterval {
+		i = MinSshKeepAliveInterval
+	}
+	return i
+}
+
+// timeout is the maximum time to wait for the reply of a keep-alive probe.
+func (c SshKeepAliveConfig) timeout() time.Duration {
+	if c.TimeoutSeconds <= 0 {
+		return c.interval(0)
+	}
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// maxFailures is the number of consecutive failed probes to tolerate
+// before closing the connection. It is at least 1.
+func (c SshKeepAliveConfig) maxFailures() int {
+	return max(c.MaxFailures, 1)
+}
+
 // SshAuth wraps the ssh.AuthMethod to make it easier to bind values from
 // configuration files or databases.
 //
@@ -139,6 +260,10 @@ func (c SshKeepAliveConfig) interval(retries int) time.Duration {
 // Set exactly one of Password, PrivateKey, PrivateKeyPath field to
 // authenticate with RFC 4252 password or public key authentication.
 //
+// The Password, PrivateKey and Passphrase may be secret references instead
+// of the secrets, e.g. "env:SSH_PASS" or "file:/run/secrets/ssh_key"
+// (see ResolveSecret). They are resolved by PrepareContext.
+//
 // For other authentication methods, use NewSshAuth() to set a custom auth
 // method.
 type SshAuth struct {
@@ -149,6 +274,9 @@ type SshAuth struct {
 	PrivateKey string
 	// PrivateKeyPath is the path to the private key to use for authentication.
 	PrivateKeyPath string
+	// Passphrase decrypts the PrivateKey (or the one at PrivateKeyPath),
+	// if it is encrypted.
+	Passphrase string
 
 	// Retries is the number of times to retry the connection for this auth method.
 	// If Retries < 0, will retry indefinitely.
@@ -174,8 +302,57 @@ func NewSshAuth(authMethod ssh.AuthMethod) *SshAuth {
 	}
 }
 
+// LogValue implements slog.LogValuer. The Password, PrivateKey and
+// Passphrase are masked, unless they are secret references.
+func (a SshAuth) LogValue() slog.Value {
+	m := a.masked()
+	return slog.GroupValue(
+		slog.String("password", m.Password),
+		slog.String("privateKey", m.PrivateKey),
+		slog.String("privateKeyPath", m.PrivateKeyPath),
+		slog.String("passphrase", m.Passphrase),
+		slog.Int("retries", m.Retries),
+	)
+}
+
+// Format implements fmt.Formatter, so that the SshAuth is printed with the
+// Password, PrivateKey and Passphrase masked.
+func (a SshAuth) Format(f fmt.State, verb rune) {
+	type sshAuth SshAuth // without methods: no recursion
+	formatMasked(f, verb, sshAuth(a.masked()), "SshAuth")
+}
+
+// masked returns a copy of the SshAuth with the secrets masked.
+// The secret references are kept.
+func (a SshAuth) masked() SshAuth {
+	a.Password = maskSecret(a.Password)
+	a.PrivateKey = maskSecret(a.PrivateKey)
+	a.Passphrase = maskSecret(a.Passphrase)
+	return a
+}
+
+// formatMasked formats the masked value v (of a method-less copy of the type
+// named name) for the verb, as if it were of the original type.
+func formatMasked(f fmt.State, verb rune, v any, name string) {
+	s := fmt.Sprintf(fmt.FormatString(f, verb), v)
+	if verb == 'v' && f.Flag('#') {
+		// %#v prints the Go type name: "rexec.sshAuth{...}"
+		local := fmt.Sprintf("%T", v)
+		s = strings.Replace(s, local, "rexec."+name, 1)
+	}
+	_, _ = io.WriteString(f, s)
+}
+
 // Prepare prepares the SshAuth for AuthMethod() call.
-func (a *SshAuth) Prepare() (err error) {
+// It is PrepareContext with the background context.
+func (a *SshAuth) Prepare() error {
+	return a.PrepareContext(context.Background())
+}
+
+// PrepareContext prepares the SshAuth for AuthMethod() call, resolving the
+// secret references in the Password, PrivateKey and Passphrase with the ctx
+// (see ResolveSecret). The resolved secrets are not stored in the fields.
+func (a *SshAuth) PrepareContext(ctx context.Context) (err error) {
 	if a.authMethod != nil {
 		if a.Password != "" || a.PrivateKey != "" || a.PrivateKeyPath != "" {
 			return ErrSshAuthMutex
@@ -187,12 +364,16 @@ func (a *SshAuth) Prepare() (err error) {
 		if a.PrivateKey != "" || a.PrivateKeyPath != "" {
 			return ErrSshAuthMutex
 		}
-		a.Password = strings.TrimSpace(a.Password)
-		if a.Password == "" {
+		password, err := ResolveSecret(ctx, a.Password)
+		if err != nil {
+			return fmt.Errorf("password: %w", err)
+		}
+		password = strings.TrimSpace(password)
+		if password == "" {
 			return ErrSshAuthEmptyPassword
 		}
 
-		a.authMethod = ssh.Password(a.Password)
+		a.authMethod = ssh.Password(password)
 
 		return nil
 	}
@@ -216,13 +397,26 @@ func (a *SshAuth) Prepare() (err error) {
 
 	// parse the private key, set signer.
 	if a.PrivateKey != "" {
-		a.PrivateKey = strings.TrimSpace(a.PrivateKey)
-		if a.PrivateKey == "" {
+		privateKey, err := ResolveSecret(ctx, a.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("private key: %w", err)
+		}
+		privateKey = strings.TrimSpace(privateKey)
+		if privateKey == "" {
 			return ErrSshAuthEmptyPrivateKey
 		}
 
-		key := []byte(a.PrivateKey)
-		signer, err := ssh.ParsePrivateKey(key)
+		key := []byte(privateKey)
+		var signer ssh.Signer
+		if a.Passphrase != "" {
+			passphrase, resolveErr := ResolveSecret(ctx, a.Passphrase)
+			if resolveErr != nil {
+				return fmt.Errorf("passphrase: %w", resolveErr)
+			}
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
 		if err != nil {
 			// log.Fatalf("unable to parse private key: %v", err)
 			return fmt.Errorf("unable to parse private key: %w", err)
@@ -264,12 +458,12 @@ var (
 	ErrSshAuthEmptyPrivateKey = fmt.Errorf("private key is empty")
 )
 
-func prepareSshAuthMethods(auths []SshAuth) ([]ssh.AuthMethod, []error) {
+func prepareSshAuthMethods(ctx context.Context, auths []SshAuth) ([]ssh.AuthMethod, []error) {
 	authMethods := make([]ssh.AuthMethod, 0, len(auths))
 	errs := make([]error, 0)
 
 	for _, auth := range auths {
-		err := auth.Prepare()
+		err := auth.PrepareContext(ctx)
 		if err != nil {
 			errs = append(errs, err)
 			continue
@@ -0,0 +1,92 @@
+package rexec
+
+import (
+	"fmt"
+)
+
+// This file describes where an Executor runs commands, for policies,
+// audit records and other consumers that need the target metadata.
+
+// TargetInfo describes where an Executor runs commands.
+type TargetInfo struct {
+	// Kind is the kind of the executor, e.g. "Local", "Shell",
+	// "ImmediateSsh" or "KeepAliveSsh" (the field names of ExecutorFactory).
+	Kind string
+	// Addr is the "host:port" of a remote target. Empty for local ones.
+	Addr string
+	// User is the user to run commands as on a remote target.
+	User string
+}
+
+// Name identifies the target: the Addr of a remote target,
+// or the Kind of a local one.
+func (t TargetInfo) Name() string {
+	if t.Addr != "" {
+		return t.Addr
+	}
+	return t.Kind
+}
+
+// String returns "Kind(user@addr)" for remote targets, or "Kind" otherwise.
+func (t TargetInfo) String() string {
+	switch {
+	case t.Addr == "":
+		return t.Kind
+	case t.User == "":
+		return fmt.Sprintf("%s(%s)", t.Kind, t.Addr)
+	default:
+		return fmt.Sprintf("%s(%s@%s)", t.Kind, t.User, t.Addr)
+	}
+}
+
+// Targeter is implemented by Executors that know where they run commands.
+// Decorators forward it to the executors they decorate.
+type Targeter interface {
+	TargetInfo() TargetInfo
+}
+
+// TargetOf returns the TargetInfo of the executor if it is a Targeter,
+// or a TargetInfo with only the Go type of the executor as the Kind.
+func TargetOf(executor Executor) TargetInfo {
+	if t, ok := executor.(Targeter); ok {
+		return t.TargetInfo()
+	}
+	return TargetInfo{Kind: fmt.Sprintf("%T", executor)}
+}
+
+// impl Targeter for each executor
+
+var (
+	_ Targeter = (*LocalExecutor)(nil)
+	_ Targeter = (*ShellExecutor)(nil)
+	_ Targeter = (*ImmediateSshExecutor)(nil)
+	_ Targeter = (*KeepAliveSshExecutor)(nil)
+	_ Targeter = (*RetryExecutor)(nil)
+	_ Targeter = (*chainedExecutor)(nil)
+)
+
+func (e *LocalExecutor) TargetInfo() TargetInfo { return TargetInfo{Kind: "Local"} }
+
+func (e *ShellExecutor) TargetInfo() TargetInfo { return TargetInfo{Kind: "Shell"} }
+
+func (e *ImmediateSshExecutor) TargetInfo() TargetInfo {
+	return sshTargetInfo("ImmediateSsh", e.Config)
+}
+
+func (e *KeepAliveSshExecutor) TargetInfo() TargetInfo {
+	return sshTargetInfo("KeepAliveSsh", e.Config)
+}
+
+func (e *RetryExecutor) TargetInfo() TargetInfo { return TargetOf(e.Executor) }
+
+func (c *chainedExecutor) TargetInfo() TargetInfo { return TargetOf(c.base) }
+
+// sshTargetInfo returns the TargetInfo of an SSH executor with the config.
+func sshTargetInfo(kind string, config *SshClientConfig) TargetInfo {
+	t := TargetInfo{Kind: kind}
+	if config != nil {
+		t.Addr = config.Addr
+		t.User = config.User
+	}
+	return t
+}
@@ -0,0 +1,35 @@
+package rexec
+
+import (
+	"testing"
+)
+
+func TestTargetOf(t *testing.T) {
+	sshConfig := &SshClientConfig{Addr: "example.com:22", User: "root"}
+
+	tests := []struct {
+		name       string
+		executor   Executor
+		wantString string
+		wantName   string
+	}{
+		{"local", &LocalExecutor{}, "Local", "Local"},
+		{"shell", &ShellExecutor{ShellPath: "/bin/sh"}, "Shell", "Shell"},
+		{"immSsh", &ImmediateSshExecutor{Config: sshConfig}, "ImmediateSsh(root@example.com:22)", "example.com:22"},
+		{"keepAliveSsh", &KeepAliveSshExecutor{Config: &SshClientConfig{Addr: "example.com:22"}}, "KeepAliveSsh(example.com:22)", "example.com:22"},
+		{"nilSshConfig", &ImmediateSshExecutor{}, "ImmediateSsh", "ImmediateSsh"},
+		{"retry", &RetryExecutor{Executor: &ImmediateSshExecutor{Config: sshConfig}}, "ImmediateSsh(root@example.com:22)", "example.com:22"},
+		{"chain", Chain(&LocalExecutor{}, WithRetry(RetryPolicy{})), "Local", "Local"},
+		{"func", ExecutorFunc(nil), "rexec.ExecutorFunc", "rexec.ExecutorFunc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TargetOf(tt.executor)
+			if got.String() != tt.wantString || got.Name() != tt.wantName {
+				t.Errorf("❌ TargetOf() = %v (name %q), want %v (name %q)", got, got.Name(), tt.wantString, tt.wantName)
+			} else {
+				t.Logf("✅ TargetOf() = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package rexec
+
+import (
+	"context"
+	"log/slog"
+)
+
+// This file defines a small tracing interface to instrument the phases of
+// executions with spans: SSH dial (TCP connect and SSH handshake), session
+// creation, and the command itself.
+//
+// rexec does not depend on any tracing library. Adapters implement Tracer,
+// e.g. the OpenTelemetry one in the rexecotel package:
+//
+//	executor := &rexec.KeepAliveSshExecutor{
+//		Config: config,
+//		Tracer: rexecotel.NewTracer(otel.GetTracerProvider()),
+//	}
+//
+// The spans are started with the ctx given to Execute, so they are children
+// of the caller's span in ctx (if the adapter supports it).
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start starts a span named name, as a child of the span in ctx (if any),
+	// with the attributes. It returns the span and a ctx carrying it.
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Span is a traced operation started by a Tracer.
+type Span interface {
+	// SetAttributes sets the attributes of the span.
+	SetAttributes(attrs ...slog.Attr)
+	// RecordError records the error and marks the span as failed.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// Span attribute keys set by rexec.
+const (
+	AttrHost       = "rexec.host"        // address of a remote target: "host:port"
+	AttrUser       = "rexec.user"        // user on a remote target
+	AttrCommand    = "rexec.command"     // command line, with the secrets masked
+	AttrPath       = "rexec.path"        // path of the local program
+	AttrExitStatus = "rexec.exit_status" // exit status of the command
+)
+
+// context-scoped tracers
+
+type tracerCtxKey struct{}
+
+// WithTracer returns a copy of ctx that carries the tracer.
+// Executions with the returned context trace to it, overriding the Tracer
+// fields of the executors.
+func WithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, tracerCtxKey{}, tracer)
+}
+
+// tracerFor resolves the tracer for an execution with ctx by an executor
+// with the tracer field: ctx tracer > executor tracer > no-op tracer.
+func tracerFor(ctx context.Context, tracer Tracer) Tracer {
+	if ctx != nil {
+		if t, ok := ctx.Value(tracerCtxKey{}).(Tracer); ok && t != nil {
+			return t
+		}
+	}
+	if tracer != nil {
+		return tracer
+	}
+	return noopTracer{}
+}
+
+// endSpan records the err (if any) and ends the span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// noopTracer starts spans that do nothing.
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...slog.Attr) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...slog.Attr) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
@@ -0,0 +1,147 @@
+package rexec
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+// recordingTracer records the spans it starts.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	tracer *recordingTracer
+
+	name   string
+	parent string
+	attrs  map[string]slog.Value
+	err    error
+	ended  bool
+}
+
+type recordedSpanCtxKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span) {
+	span := &recordedSpan{tracer: t, name: name, attrs: map[string]slog.Value{}}
+	if parent, ok := ctx.Value(recordedSpanCtxKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	span.SetAttributes(attrs...)
+
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+
+	return context.WithValue(ctx, recordedSpanCtxKey{}, span), span
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...slog.Attr) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+
+func (s *recordedSpan) End() { s.ended = true }
+
+// span returns the first recorded span named name.
+func (t *recordingTracer) span(name string) *recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestLocalExecutor_Tracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	executor := &LocalExecutor{Tracer: tracer}
+
+	cmd := &Command{Command: "sh -c 'exit 3'"}
+	_ = executor.Execute(context.Background(), cmd)
+
+	span := tracer.span("rexec.runProc")
+	switch {
+	case span == nil:
+		t.Fatalf("❌ no rexec.runProc span: %v", tracer.spans)
+	case !span.ended:
+		t.Errorf("❌ span not ended")
+	case span.err == nil:
+		t.Errorf("❌ span error not recorded")
+	case span.attrs[AttrExitStatus].Int64() != 3:
+		t.Errorf("❌ span exit status = %v, want 3", span.attrs[AttrExitStatus])
+	case span.attrs[AttrCommand].String() == "":
+		t.Errorf("❌ span has no command attribute")
+	default:
+		t.Logf("✅ span: %+v", span.attrs)
+	}
+}
+
+func TestImmediateSshExecutor_Tracer(t *testing.T) {
+	executorTracer := &recordingTracer{}
+	ctxTracer := &recordingTracer{}
+
+	executor := &ImmediateSshExecutor{
+		Config: &SshClientConfig{
+			Addr:           "localhost:24622",
+			User:           "root",
+			Auth:           []SshAuth{{Password: "root"}},
+			TimeoutSeconds: 5,
+			HostKeyCheck:   ignoreHostKeyCheck,
+		},
+		Tracer: executorTracer,
+	}
+
+	// the caller's span in ctx is the parent.
+	ctx, caller := ctxTracer.Start(WithTracer(context.Background(), ctxTracer), "caller")
+	defer caller.End()
+
+	if err := executor.Execute(ctx, &Command{Command: "echo hello"}); err != nil {
+		t.Fatalf("❌ Execute() error = %v", err)
+	}
+
+	if len(executorTracer.spans) != 0 {
+		t.Errorf("❌ ctx tracer should override the executor Tracer")
+	}
+
+	wants := []struct{ name, parent string }{
+		{"rexec.dialSsh", "caller"},
+		{"rexec.ssh.connect", "rexec.dialSsh"},
+		{"rexec.ssh.handshake", "rexec.dialSsh"},
+		{"rexec.execWithSshClient", "caller"},
+		{"rexec.ssh.newSession", "rexec.execWithSshClient"},
+		{"rexec.runSshSession", "rexec.execWithSshClient"},
+	}
+	for _, want := range wants {
+		span := ctxTracer.span(want.name)
+		switch {
+		case span == nil:
+			t.Errorf("❌ missing span %s", want.name)
+		case span.parent != want.parent:
+			t.Errorf("❌ span %s parent = %q, want %q", want.name, span.parent, want.parent)
+		case !span.ended || span.err != nil:
+			t.Errorf("❌ span %s ended=%v err=%v", want.name, span.ended, span.err)
+		default:
+			t.Logf("✅ span %s <- %s", want.name, want.parent)
+		}
+	}
+
+	dial := ctxTracer.span("rexec.dialSsh")
+	if dial != nil && (dial.attrs[AttrHost].String() != "localhost:24622" || dial.attrs[AttrUser].String() != "root") {
+		t.Errorf("❌ dial span attrs = %v", dial.attrs)
+	}
+	session := ctxTracer.span("rexec.runSshSession")
+	if session != nil && session.attrs[AttrExitStatus].Int64() != 0 {
+		t.Errorf("❌ session span exit status = %v, want 0", session.attrs[AttrExitStatus])
+	}
+}
@@ -0,0 +1,216 @@
+package rexec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// This file provides the validation of ExecutorFactory configurations,
+// reporting all the problems at once with their paths, for humans fixing
+// config files:
+//
+//	if err := rexec.ValidateConfig(data); err != nil {
+//		// Shell.ShelPath: unknown field; KeepAliveSsh.Config.Addr: executor has bad configuration: addr is empty
+//	}
+
+// ValidateConfig checks the JSON or YAML configuration of an ExecutorFactory
+// (see ExecutorFactory.ValidateConfig), and additionally reports the unknown
+// fields and the values of wrong types, that json.Unmarshal ignores or stops
+// at, including the ones in the Config of a registered Kind.
+//
+// It returns nil or ConfigErrors sorted by path.
+// It does not create the executor, nor dial the SSH servers.
+func ValidateConfig(data []byte) error {
+	data, err := configToJSON(data)
+	if err != nil {
+		return err
+	}
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadConfigFormat, err)
+	}
+
+	errs := checkConfigFields("", raw, reflect.TypeOf(ExecutorFactory{}))
+
+	var f ExecutorFactory
+	_ = json.Unmarshal(data, &f) // the type errors are reported above
+
+	// the Config of a Kind is an any: check it against the config type
+	if obj, ok := raw.(map[string]any); ok && f.Kind != "" {
+		if key, ok := lookupConfigKey(obj, "Config"); ok {
+			if kind, ok := lookupExecutorKind(f.Kind); ok && kind.configType != nil {
+				errs = append(errs, checkConfigFields(key, obj[key], kind.configType)...)
+			}
+		}
+	}
+
+	if err := f.ValidateConfig(); err != nil {
+		errs = append(errs, err.(ConfigErrors)...)
+	}
+	return errs.orNil()
+}
+
+// ValidateConfig checks the configuration of the factory, and returns all
+// the problems found as ConfigErrors sorted by path, or nil:
+//
+//   - no executor or multiple executors set;
+//   - an unknown Kind, or a Config that does not decode into its config type;
+//   - an empty ShellPath;
+//   - an SSH config that is nil, or has an empty or bad Addr ("host:port");
+//   - an SshAuth without exactly one of Password, PrivateKey and
+//     PrivateKeyPath (ErrSshAuthMutex);
+//   - a PrivateKeyPath or KnownHostsPath that is not readable;
+//   - a FixedHostKey that is not a valid public key.
+//
+// Unlike Executor, which returns the first problem it hits, it checks the
+// whole configuration. It does not create the executor, nor dial the SSH
+// servers, nor resolve the secret references.
+// The Configs of custom kinds are checked by their Validate() error method,
+// if any.
+func (f ExecutorFactory) ValidateConfig() error {
+	var errs ConfigErrors
+	var set []string
+
+	executors := f.executorsMap()
+	for _, name := range sortedKeys(executors) {
+		if errors.Is(executors[name].Validate(), ErrNilExecutor) {
+			continue
+		}
+		set = append(set, name)
+		errs = append(errs, executorConfigErrors(name, executors[name])...)
+	}
+
+	if f.Kind != "" {
+		set = append(set, "Kind")
+		errs = append(errs, kindConfigErrors(f.Kind, f.Config)...)
+	} else if f.Config != nil {
+		errs.add("Config", fmt.Errorf("%w: Config is set without Kind", ErrExecutorBadConfig))
+	}
+
+	switch len(set) {
+	case 0:
+		errs.add("", fmt.Errorf("%w: all nil", ErrExecutorNotSet))
+	case 1:
+	default:
+		errs.add("", fmt.Errorf("%w: %v", ErrMultipleExecutors, set))
+	}
+	return errs.orNil()
+}
+
+// kindConfigErrors checks the config of the kind.
+func kindConfigErrors(name string, config any) (errs ConfigErrors) {
+	kind, ok := lookupExecutorKind(name)
+	if !ok {
+		errs.add("Kind", fmt.Errorf("%w: %q", ErrUnknownExecutorKind, name))
+		return errs
+	}
+	config, err := kind.decodeConfig(config)
+	if err != nil {
+		errs.add("Config", fmt.Errorf("%w: %w", ErrExecutorBadConfig, err))
+		return errs
+	}
+	return executorConfigErrors("Config", config)
+}
+
+// executorConfigErrors checks the config of an executor at the path: a
+// built-in executor, or the config of a custom kind.
+func executorConfigErrors(path string, config any) (errs ConfigErrors) {
+	switch c := config.(type) {
+	case *LocalExecutor:
+	case *ShellExecutor:
+		if c.ShellPath == "" {
+			errs.add(configPath(path, "ShellPath"), fmt.Errorf("%w: shell path is empty", ErrExecutorBadConfig))
+		}
+	case *ImmediateSshExecutor:
+		errs = sshConfigErrors(configPath(path, "Config"), c.Config)
+	case *KeepAliveSshExecutor:
+		errs = sshConfigErrors(configPath(path, "Config"), c.Config)
+	case interface{ Validate() error }:
+		if err := c.Validate(); err != nil {
+			errs.add(path, err)
+		}
+	}
+	return errs
+}
+
+// sshConfigErrors checks the SSH client config at the path.
+func sshConfigErrors(path string, c *SshClientConfig) (errs ConfigErrors) {
+	if c == nil {
+		errs.add(path, fmt.Errorf("%w: ssh config is nil", ErrExecutorBadConfig))
+		return errs
+	}
+
+	if c.Addr == "" {
+		errs.add(configPath(path, "Addr"), fmt.Errorf("%w: addr is empty", ErrExecutorBadConfig))
+	} else if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs.add(configPath(path, "Addr"), fmt.Errorf("%w: want host:port: %w", ErrExecutorBadConfig, err))
+	}
+
+	for i, auth := range c.Auth {
+		authPath := fmt.Sprintf("%s[%d]", configPath(path, "Auth"), i)
+		set := 0
+		for _, s := range []string{auth.Password, auth.PrivateKey, auth.PrivateKeyPath} {
+			if s != "" {
+				set++
+			}
+		}
+		if auth.authMethod != nil {
+			set++
+		}
+		if set != 1 {
+			errs.add(authPath, ErrSshAuthMutex)
+		}
+		if auth.PrivateKeyPath != "" {
+			if err := checkReadable(auth.PrivateKeyPath); err != nil {
+				errs.add(authPath+".PrivateKeyPath", fmt.Errorf("%w: unable to read private key: %w", ErrExecutorBadConfig, err))
+			}
+		}
+	}
+
+	if check := c.HostKeyCheck; check != nil {
+		checkPath := configPath(path, "HostKeyCheck")
+		if check.FixedHostKey != "" && !IsSecretRef(check.FixedHostKey) {
+			hostKey := strings.TrimPrefix(check.FixedHostKey, SecretLiteral+":")
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey)); err != nil {
+				errs.add(configPath(checkPath, "FixedHostKey"), fmt.Errorf("%w: bad host key: %w", ErrExecutorBadConfig, err))
+			}
+		}
+		for i, name := range check.KnownHostsPath {
+			if err := checkReadable(name); err != nil {
+				errs.add(fmt.Sprintf("%s[%d]", configPath(checkPath, "KnownHostsPath"), i),
+					fmt.Errorf("%w: unable to read known_hosts: %w", ErrExecutorBadConfig, err))
+			}
+		}
+	}
+	return errs
+}
+
+// checkReadable returns an error if the file cannot be opened for reading.
+func checkReadable(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// lookupConfigKey finds the key of the field in the object like
+// encoding/json does: an exact match, or else a case-insensitive one.
+func lookupConfigKey(obj map[string]any, field string) (string, bool) {
+	if _, ok := obj[field]; ok {
+		return field, true
+	}
+	for _, key := range sortedKeys(obj) {
+		if strings.EqualFold(key, field) {
+			return key, true
+		}
+	}
+	return "", false
+}
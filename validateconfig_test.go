@@ -0,0 +1,190 @@
+package rexec
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// configErrorPaths returns the paths of the ConfigErrors in the err.
+func configErrorPaths(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("❌ error is not ConfigErrors: %v", err)
+	}
+	paths := make([]string, len(errs))
+	for i, e := range errs {
+		paths[i] = e.Path
+	}
+	return paths
+}
+
+func TestDecodeConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantPaths []string
+		wantErr   error
+	}{
+		{
+			name: "ok json",
+			data: `{"Shell": {"ShellPath": "/bin/sh", "ShellArgs": ["-c"]}}`,
+		},
+		{
+			name: "ok yaml case-insensitive",
+			data: "shell:\n  shellPath: /bin/sh\n  shellArgs: [-c]\n",
+		},
+		{
+			name:      "unknown fields",
+			data:      `{"Shell": {"ShelPath": "/bin/sh", "ShellArgz": ["-c"]}, "Middlewares": []}`,
+			wantPaths: []string{"Middlewares", "Shell.ShelPath", "Shell.ShellArgz"},
+			wantErr:   ErrUnknownConfigField,
+		},
+		{
+			name:      "wrong types",
+			data:      "keepAliveSsh:\n  config:\n    addr: 22\n    auth:\n      - password: pw\n        retries: many\n",
+			wantPaths: []string{"keepAliveSsh.config.addr", "keepAliveSsh.config.auth[0].retries"},
+			wantErr:   ErrConfigType,
+		},
+		{
+			name:    "bad format",
+			data:    `{"Shell": `,
+			wantErr: ErrBadConfigFormat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f ExecutorFactory
+			err := DecodeConfig([]byte(tt.data), &f)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("❌ DecodeConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (f.Shell == nil || f.Shell.ShellPath != "/bin/sh") {
+				t.Errorf("❌ DecodeConfig() = %+v, want a Shell", f)
+			}
+			if tt.wantPaths != nil {
+				if paths := configErrorPaths(t, err); !slices.Equal(paths, tt.wantPaths) {
+					t.Errorf("❌ DecodeConfig() error paths = %v, want %v", paths, tt.wantPaths)
+				}
+			}
+			t.Logf("✅ DecodeConfig() error = %v", err)
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// want the errors at the paths, wrapping the errors, in order
+		wantPaths []string
+		wantErrs  []error
+	}{
+		{
+			name: "ok",
+			data: `{"KeepAliveSsh": {"Config": {"Addr": "localhost:22", "Auth": [{"Password": "env:SSH_PASS"}]}}}`,
+		},
+		{
+			name:      "readme typo",
+			data:      `{"Shell": {"ShelPath": "/usr/bin/bc", "ShellArgs": ["--expression"]}}`,
+			wantPaths: []string{"Shell.ShelPath", "Shell.ShellPath"},
+			wantErrs:  []error{ErrUnknownConfigField, ErrExecutorBadConfig},
+		},
+		{
+			name: "ssh problems",
+			data: `{"ImmediateSsh": {"Config": {
+				"Addr": "",
+				"Auth": [
+					{"Password": "pw", "PrivateKey": "key"},
+					{"PrivateKeyPath": "/nonexistent/id_rsa"}
+				],
+				"HostKeyCheck": {"FixedHostKey": "not a key", "KnownHostsPath": ["/nonexistent/known_hosts"]}
+			}}}`,
+			wantPaths: []string{
+				"ImmediateSsh.Config.Addr",
+				"ImmediateSsh.Config.Auth[0]",
+				"ImmediateSsh.Config.Auth[1].PrivateKeyPath",
+				"ImmediateSsh.Config.HostKeyCheck.FixedHostKey",
+				"ImmediateSsh.Config.HostKeyCheck.KnownHostsPath[0]",
+			},
+			wantErrs: []error{ErrExecutorBadConfig, ErrSshAuthMutex, ErrExecutorBadConfig, ErrExecutorBadConfig, ErrExecutorBadConfig},
+		},
+		{
+			name:      "multiple executors",
+			data:      `{"Local": {}, "Shell": {"ShellPath": "/bin/sh"}, "Kind": "test.echo", "Config": {"Prefix": "> "}}`,
+			wantPaths: []string{""},
+			wantErrs:  []error{ErrMultipleExecutors},
+		},
+		{
+			name:      "no executor",
+			data:      `{}`,
+			wantPaths: []string{""},
+			wantErrs:  []error{ErrExecutorNotSet},
+		},
+		{
+			name:      "unknown kind",
+			data:      `{"Kind": "nope"}`,
+			wantPaths: []string{"Kind"},
+			wantErrs:  []error{ErrUnknownExecutorKind},
+		},
+		{
+			name:      "kind config",
+			data:      `{"Kind": "test.echo", "Config": {"Prefx": "> "}}`,
+			wantPaths: []string{"Config.Prefx"},
+			wantErrs:  []error{ErrUnknownConfigField},
+		},
+		{
+			name:      "built-in kind config",
+			data:      `{"Kind": "KeepAliveSsh", "Config": {"Config": {"Addr": "localhost"}}}`,
+			wantPaths: []string{"Config.Config.Addr"},
+			wantErrs:  []error{ErrExecutorBadConfig},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConfig([]byte(tt.data))
+			if paths := configErrorPaths(t, err); !slices.Equal(paths, tt.wantPaths) {
+				t.Fatalf("❌ ValidateConfig() error paths = %v, want %v\n\terr = %v", paths, tt.wantPaths, err)
+			}
+			var errs ConfigErrors
+			errors.As(err, &errs)
+			for i, want := range tt.wantErrs {
+				if !errors.Is(errs[i], want) {
+					t.Errorf("❌ ValidateConfig() error %d = %v, want %v", i, errs[i], want)
+				}
+			}
+			t.Logf("✅ ValidateConfig() error = %v", err)
+		})
+	}
+}
+
+func TestExecutorFactory_ValidateConfig(t *testing.T) {
+	f := ExecutorFactory{
+		Local: &LocalExecutor{},
+		KeepAliveSsh: &KeepAliveSshExecutor{Config: &SshClientConfig{
+			Auth: []SshAuth{{PrivateKeyPath: "/nonexistent/id_rsa", Password: "pw"}},
+		}},
+	}
+	err := f.ValidateConfig()
+	want := []string{"", "KeepAliveSsh.Config.Addr", "KeepAliveSsh.Config.Auth[0]", "KeepAliveSsh.Config.Auth[0].PrivateKeyPath"}
+	if paths := configErrorPaths(t, err); !slices.Equal(paths, want) {
+		t.Fatalf("❌ ValidateConfig() error paths = %v, want %v", paths, want)
+	}
+	if !errors.Is(err, ErrMultipleExecutors) || !strings.Contains(err.Error(), "KeepAliveSsh.Config.Addr: ") {
+		t.Errorf("❌ ValidateConfig() error = %v", err)
+	}
+
+	// Executor reports the first problem, deterministically
+	for i := 0; i < 10; i++ {
+		_, err := f.Executor()
+		if !errors.Is(err, ErrExecutorBadConfig) || !strings.Contains(err.Error(), "(KeepAliveSsh)") {
+			t.Fatalf("❌ Executor() error = %v, want the bad KeepAliveSsh", err)
+		}
+	}
+	t.Logf("✅ ValidateConfig() error = %v", err)
+}